@@ -0,0 +1,237 @@
+package toyRetention
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// apiPrefix is where the admin API routes live, as /api/v1/tenants/<tenant>/<resource>.
+const apiPrefix = "/api/v1/tenants"
+
+// APIHandler exposes the service over HTTP:
+//
+//	GET  /api/v1/tenants                    tenant names
+//	GET  /api/v1/tenants/<tenant>/config    effective config
+//	GET  /api/v1/tenants/<tenant>/plan      actions a run would take now and their cost, ?months=<n>
+//	GET  /api/v1/tenants/<tenant>/last-run  outcome of the last run
+//	GET  /api/v1/tenants/<tenant>/blocks    retention state of every block
+//	POST /api/v1/tenants/<tenant>/run       run retention now
+//	GET  /api/v1/tenants/<tenant>/holds     legal holds, expired ones included
+//	POST /api/v1/tenants/<tenant>/holds     place a legal hold given as a LegalHold
+//	DELETE /api/v1/tenants/<tenant>/holds/<id>  release a legal hold
+//	GET  /api/v1/tenants/<tenant>/deletions  deletion requests and their status
+//	POST /api/v1/tenants/<tenant>/deletions  request a deletion given as a DeletionRequest
+//
+// along with the Prometheus TSDB admin API, see serveTSDBAdmin.
+type APIHandler struct {
+	service *Service
+}
+
+func NewAPIHandler(service *Service) *APIHandler {
+	return &APIHandler{service: service}
+}
+
+type planResponse struct {
+	Actions  []Action     `json:"actions"`
+	Deferred []Action     `json:"deferred"`
+	Cost     CostEstimate `json:"cost"`
+	// RemovedDropPolicies are drop policies applied to blocks but not configured anymore.
+	RemovedDropPolicies []DropPolicyStatus `json:"removed_drop_policies"`
+	// Held are the actions withheld because of legal holds.
+	Held []HeldAction `json:"held"`
+	// Pending are the rewrites held back to coalesce them with later changes.
+	Pending []PendingRewrite `json:"pending"`
+	// Quota is how the plan enforces the size quota, if the tenant has one.
+	Quota *QuotaReport `json:"quota,omitempty"`
+	// SeriesBudget is how the plan enforces the series budget, if the tenant has one.
+	SeriesBudget *SeriesBudgetReport `json:"series_budget,omitempty"`
+}
+
+// defaultCostMonths is the cost projection horizon when the plan request has no months.
+const defaultCostMonths = 12
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (h *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, tsdbAdminPrefix) {
+		h.serveTSDBAdmin(w, r)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, apiPrefix) {
+		writeAPIError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, apiPrefix), "/")
+	if path == "" {
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		writeAPIResponse(w, http.StatusOK, h.service.Tenants())
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	if len(parts) == 3 && parts[1] == "holds" {
+		if allowMethod(w, r, http.MethodDelete) {
+			err := h.service.ReleaseHold(parts[0], parts[2])
+			respond(w, struct{}{}, err)
+		}
+		return
+	}
+	if len(parts) != 2 {
+		writeAPIError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	name, resource := parts[0], parts[1]
+	switch resource {
+	case "config":
+		if allowMethod(w, r, http.MethodGet) {
+			config, err := h.service.Config(name)
+			respond(w, NewConfigFile(config), err)
+		}
+	case "plan":
+		if allowMethod(w, r, http.MethodGet) {
+			months := defaultCostMonths
+			if m := r.URL.Query().Get("months"); m != "" {
+				n, err := strconv.Atoi(m)
+				if err != nil || n < 0 {
+					writeAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid months %q", m))
+					return
+				}
+				months = n
+			}
+			report, err := h.service.PlanReport(name, months)
+			if err != nil {
+				respond(w, nil, err)
+				return
+			}
+			resp := planResponse{Actions: report.Scheduled, Deferred: report.Deferred, Cost: report.Cost, RemovedDropPolicies: report.RemovedDropPolicies, Held: report.Held, Pending: report.Pending}
+			if report.Quota.MaxBytes > 0 {
+				resp.Quota = &report.Quota
+			}
+			if report.SeriesBudget.Budget > 0 {
+				resp.SeriesBudget = &report.SeriesBudget
+			}
+			respond(w, resp, err)
+		}
+	case "last-run":
+		if allowMethod(w, r, http.MethodGet) {
+			record, err := h.service.LastRun(name)
+			if err == nil && record == nil {
+				writeAPIError(w, http.StatusNotFound, errors.New("tenant never ran"))
+				return
+			}
+			respond(w, record, err)
+		}
+	case "blocks":
+		if allowMethod(w, r, http.MethodGet) {
+			blocks, err := h.service.Blocks(name)
+			respond(w, blocks, err)
+		}
+	case "run":
+		if allowMethod(w, r, http.MethodPost) {
+			record, err := h.service.Run(name)
+			respond(w, record, err)
+		}
+	case "holds":
+		h.serveHolds(w, r, name)
+	case "deletions":
+		h.serveDeletions(w, r, name)
+	default:
+		writeAPIError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+func (h *APIHandler) serveHolds(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case http.MethodGet:
+		holds, err := h.service.Holds(name)
+		respond(w, holds, err)
+	case http.MethodPost:
+		hold := LegalHold{}
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&hold); err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Errorf("decoding legal hold: %w", err))
+			return
+		}
+		if err := hold.validate(); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+		hold, err := h.service.PlaceHold(name, hold)
+		respond(w, hold, err)
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		writeAPIError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+func (h *APIHandler) serveDeletions(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case http.MethodGet:
+		statuses, err := h.service.Deletions(name)
+		respond(w, statuses, err)
+	case http.MethodPost:
+		request := DeletionRequest{}
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&request); err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Errorf("decoding deletion request: %w", err))
+			return
+		}
+		if err := request.validate(); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+		request, err := h.service.RequestDeletion(name, request)
+		respond(w, request, err)
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		writeAPIError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeAPIError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	return false
+}
+
+// respond writes v, or the error with a status code matching its kind.
+func respond(w http.ResponseWriter, v interface{}, err error) {
+	var unknownTenant UnknownTenantError
+	var lockHeld *LockHeldError
+	var unknownHold UnknownHoldError
+	switch {
+	case err == nil:
+		writeAPIResponse(w, http.StatusOK, v)
+	case errors.As(err, &unknownTenant), errors.As(err, &unknownHold):
+		writeAPIError(w, http.StatusNotFound, err)
+	case errors.Is(err, ErrHoldExists), errors.Is(err, ErrDeletionExists):
+		writeAPIError(w, http.StatusConflict, err)
+	case errors.As(err, &lockHeld):
+		writeAPIError(w, http.StatusConflict, err)
+	default:
+		writeAPIError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	writeAPIResponse(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeAPIResponse(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
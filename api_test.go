@@ -0,0 +1,163 @@
+package toyRetention
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIHandler(t *testing.T) {
+	service := newTestService()
+	server := httptest.NewServer(NewAPIHandler(service))
+	defer server.Close()
+
+	get := func(path string, v interface{}) int {
+		resp, err := http.Get(server.URL + path)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		if v != nil {
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+		}
+		return resp.StatusCode
+	}
+	post := func(path string, v interface{}) int {
+		resp, err := http.Post(server.URL+path, "application/json", nil)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		if v != nil {
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+		}
+		return resp.StatusCode
+	}
+
+	tenants := []string{}
+	assert.Equal(t, http.StatusOK, get("/api/v1/tenants", &tenants))
+	assert.Equal(t, []string{"team-a", "team-b"}, tenants)
+
+	config := ConfigFile{}
+	assert.Equal(t, http.StatusOK, get("/api/v1/tenants/team-a/config", &config))
+	assert.Equal(t, ConfigFile{
+		BaseRetention: "10d",
+		Policies:      []PolicyFile{{RetentionPeriod: "5d", Policy: "service=h1"}, {RetentionPeriod: "20d", Policy: "name=ying"}},
+	}, config)
+
+	plan := planResponse{}
+	assert.Equal(t, http.StatusOK, get("/api/v1/tenants/team-a/plan", &plan))
+	assert.Equal(t, []int{3, 2}, blockIDs(plan.Actions))
+	assert.Equal(t, ActionDelete, plan.Actions[0].Kind)
+	assert.Equal(t, 13, len(plan.Cost.PerMonth))
+	assert.Equal(t, http.StatusOK, get("/api/v1/tenants/team-a/plan?months=2", &plan))
+	assert.Equal(t, 3, len(plan.Cost.PerMonth))
+	assert.Equal(t, http.StatusBadRequest, get("/api/v1/tenants/team-a/plan?months=x", nil))
+
+	apiErr := errorResponse{}
+	assert.Equal(t, http.StatusNotFound, get("/api/v1/tenants/team-a/last-run", &apiErr))
+	assert.Equal(t, "tenant never ran", apiErr.Error)
+
+	record := RunRecord{}
+	assert.Equal(t, http.StatusOK, post("/api/v1/tenants/team-a/run", &record))
+	assert.Equal(t, 2, len(record.Result.Applied))
+
+	lastRun := RunRecord{}
+	assert.Equal(t, http.StatusOK, get("/api/v1/tenants/team-a/last-run", &lastRun))
+	assert.Equal(t, record, lastRun)
+
+	blocks := []BlockInspection{}
+	assert.Equal(t, http.StatusOK, get("/api/v1/tenants/team-a/blocks", &blocks))
+	assert.Equal(t, 3, len(blocks))
+	assert.Equal(t, true, blocks[2].Deleted)
+
+	// errors
+	assert.Equal(t, http.StatusNotFound, get("/api/v1/tenants/team-c/plan", &apiErr))
+	assert.Equal(t, `unknown tenant "team-c"`, apiErr.Error)
+	assert.Equal(t, http.StatusMethodNotAllowed, get("/api/v1/tenants/team-a/run", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, post("/api/v1/tenants/team-a/plan", nil))
+	assert.Equal(t, http.StatusNotFound, get("/api/v1/tenants/team-a/nope", nil))
+	assert.Equal(t, http.StatusNotFound, get("/other", nil))
+
+	_, err := AcquireBucketLock(service.tenants["team-b"].bucket, "compactor-2", defaultLockTTL, theCurrentTime)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, post("/api/v1/tenants/team-b/run", &apiErr))
+	assert.Equal(t, http.StatusOK, get("/api/v1/tenants/team-b/last-run", &lastRun))
+	assert.Contains(t, lastRun.Error, "locked by")
+}
+
+func TestAPIHolds(t *testing.T) {
+	server := httptest.NewServer(NewAPIHandler(newTestService()))
+	defer server.Close()
+
+	do := func(method string, path string, body string, v interface{}) int {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		assert.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		if v != nil {
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+		}
+		return resp.StatusCode
+	}
+
+	hold := LegalHold{}
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/api/v1/tenants/team-a/holds", `{"block_ids": [3], "reason": "case 42"}`, &hold))
+	assert.Equal(t, LegalHold{ID: "hold-1", BlockIDs: []int{3}, Reason: "case 42", CreatedAt: theCurrentTime}, hold)
+	assert.Equal(t, http.StatusConflict, do(http.MethodPost, "/api/v1/tenants/team-a/holds", `{"id": "hold-1"}`, nil))
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/v1/tenants/team-a/holds", `{"block_ids": [1], "selector": "a=b"}`, nil))
+
+	holds := []LegalHold{}
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/v1/tenants/team-a/holds", "", &holds))
+	assert.Equal(t, []LegalHold{hold}, holds)
+
+	// the expired block is held back from deletion
+	plan := planResponse{}
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/v1/tenants/team-a/plan", "", &plan))
+	assert.Equal(t, []int{2}, blockIDs(plan.Actions))
+	assert.Equal(t, 1, len(plan.Held))
+	assert.Equal(t, 3, plan.Held[0].BlockID)
+	assert.Equal(t, []string{"hold-1"}, plan.Held[0].HeldBy)
+
+	assert.Equal(t, http.StatusOK, do(http.MethodDelete, "/api/v1/tenants/team-a/holds/hold-1", "", nil))
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/api/v1/tenants/team-a/holds/hold-1", "", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, do(http.MethodPut, "/api/v1/tenants/team-a/holds", "", nil))
+}
+
+func TestAPIDeletions(t *testing.T) {
+	service := newTestService()
+	server := httptest.NewServer(NewAPIHandler(service))
+	defer server.Close()
+
+	request := DeletionRequest{}
+	resp, err := http.Post(server.URL+"/api/v1/tenants/team-a/deletions", "application/json", strings.NewReader(`{"selector": "service=h1"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&request))
+	resp.Body.Close()
+	// a request without a max time has no end
+	assert.Equal(t, DeletionRequest{ID: "deletion-1", Selector: "service=h1", MaxT: math.MaxInt64, RequestedAt: theCurrentTime}, request)
+
+	resp, err = http.Post(server.URL+"/api/v1/tenants/team-a/deletions", "application/json", strings.NewReader(`{"selector": ""}`))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	statuses := []DeletionStatus{}
+	resp, err = http.Get(server.URL + "/api/v1/tenants/team-a/deletions")
+	assert.NoError(t, err)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&statuses))
+	resp.Body.Close()
+	assert.Equal(t, []DeletionStatus{{DeletionRequest: request, Status: DeletionPending, BlocksPending: 3}}, statuses)
+
+	// a run rewrites the blocks left after retention
+	resp, err = http.Post(server.URL+"/api/v1/tenants/team-a/run", "application/json", nil)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	statuses, err = service.Deletions("team-a")
+	assert.NoError(t, err)
+	assert.Equal(t, []DeletionStatus{{DeletionRequest: request, Status: DeletionDone, BlocksDone: 2}}, statuses)
+}
@@ -0,0 +1,109 @@
+package toyRetention
+
+import "fmt"
+
+// Kinds of invariant violations found by AuditBucket.
+const (
+	ViolationDeletedEarly      = "deleted_early"
+	ViolationSeriesKeptTooLong = "series_kept_too_long"
+	ViolationUnknownPolicy     = "unknown_policy"
+	ViolationRetainedMismatch  = "retained_mismatch"
+)
+
+type Violation struct {
+	BlockID int    `json:"block_id"`
+	Kind    string `json:"kind"`
+	Detail  string `json:"detail"`
+}
+
+// AuditBucket checks that retention neither removed data early nor kept it too long
+// in the bucket at currentTime. Data is only considered kept too long once it is
+// grace seconds past its retention, to leave time for retention to run.
+func AuditBucket(config UserConfig, userBucket *Bucket, currentTime int64, grace int64) []Violation {
+	violations := []Violation{}
+	known := map[string]bool{}
+	for _, p := range config.Policies {
+		known[p.Policy] = true
+	}
+
+	holds := userBucket.ActiveHolds(currentTime)
+	for _, b := range userBucket.snapshot() {
+		config := config.atResolution(b.MetaData.Resolution)
+		_, maxRetention := getRetentionPeriodRange(config.Policies, config.BaseRetention)
+		violation := func(kind string, format string, args ...interface{}) {
+			violations = append(violations, Violation{BlockID: b.ID, Kind: kind, Detail: fmt.Sprintf(format, args...)})
+		}
+
+		if b.Deleted {
+			if !deletedForQuota(b.MetaData) && !isBlockRetentionPassed(b.MaxT, currentTime, maxRetention) {
+				violation(ViolationDeletedEarly, "deleted while retained until %d", b.MaxT+maxRetention)
+			}
+			continue
+		}
+
+		// data under a legal hold is meant to outlive its retention
+		for s := range b.Series {
+			if seriesHeld(holds, b, s) {
+				continue
+			}
+			if retention := seriesRetention(config, s); isBlockRetentionPassed(b.MaxT, currentTime-grace, retention) {
+				violation(ViolationSeriesKeptTooLong, "series %s is retained until %d", s, b.MaxT+retention)
+			}
+		}
+
+		// policies removed after their data was dropped are expected, there is no
+		// record that retention dropped the others
+		for _, status := range dropPolicyStatuses(b, config) {
+			if status.State == DropStateRemovedUntracked {
+				violation(ViolationUnknownPolicy, "drop policy %s is not configured", status.Policy)
+			}
+		}
+		if kp := currentKeepPolicy(b.MetaData); kp != "" {
+			for _, policy := range splitKeepSet(decodePolicy(kp)) {
+				if !known[policy] {
+					violation(ViolationUnknownPolicy, "keep policy %s is not configured", policy)
+				}
+			}
+		}
+
+		// every rewrite records at least one drop policy, deletion request, downsampling,
+		// quota, series budget or legal hold step or keep set, and every keep set comes
+		// from its own rewrite
+		keepRewrites := keepPolicyRewrites(b.MetaData)
+		recorded := len(b.MetaData.DropPolicies) + len(b.MetaData.DeletionRequests) + len(b.MetaData.DownsampleLog) +
+			quotaRewrites(b.MetaData) + len(b.MetaData.SeriesBudgetLog) + len(b.MetaData.HoldLog)
+		minRetained := keepRewrites
+		if minRetained == 0 && recorded > 0 {
+			minRetained = 1
+		}
+		maxRetained := keepRewrites + recorded
+		if b.Retained < minRetained || b.Retained > maxRetained {
+			violation(ViolationRetainedMismatch, "retained %d times but metadata records between %d and %d rewrites", b.Retained, minRetained, maxRetained)
+		}
+	}
+	return violations
+}
+
+// seriesRetention is how long retention keeps a series: until the first configured
+// policy shorter than base retention matching it expires, otherwise until the longest
+// of base retention and the matching longer policies.
+func seriesRetention(config UserConfig, series string) int64 {
+	retention := config.BaseRetention
+	dropAt := int64(-1)
+	for _, p := range config.Policies {
+		if !matchesPolicy(series, p.Policy) {
+			continue
+		}
+		if p.RetentionPeriod <= config.BaseRetention {
+			if dropAt < 0 || p.RetentionPeriod < dropAt {
+				dropAt = p.RetentionPeriod
+			}
+		} else if p.RetentionPeriod > retention {
+			retention = p.RetentionPeriod
+		}
+	}
+	if dropAt >= 0 {
+		return dropAt
+	}
+	return retention
+}
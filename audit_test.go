@@ -0,0 +1,70 @@
+package toyRetention
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditBucket(t *testing.T) {
+	config := UserConfig{
+		BaseRetention: 10 * secondsInADay,
+		Policies: []PerSeriesRetentionPolicy{
+			{RetentionPeriod: 5 * secondsInADay, Policy: "service=h1"},
+			{RetentionPeriod: 20 * secondsInADay, Policy: "name=ying"},
+		},
+	}
+	bucket := &Bucket{
+		Blocks: []Block{
+			// deleted while name=ying still covers it
+			{ID: 1, MaxT: theCurrentTime - 15*secondsInADay, Deleted: true},
+			// service=h1 should be gone, service=h2 not yet
+			{ID: 2, MaxT: theCurrentTime - 7*secondsInADay, Series: map[string]interface{}{"service=h1": nil, "service=h2": nil}},
+			// dropped a policy that is not configured anymore, and claims two rewrites
+			{ID: 3, MaxT: theCurrentTime - 2*secondsInADay, Retained: 2, MetaData: MetaData{DropPolicies: []string{hashPolicy("service=h0")}}},
+			// in line with the config
+			{
+				ID:       4,
+				MaxT:     theCurrentTime - 12*secondsInADay,
+				Series:   map[string]interface{}{"name=ying,service=h2": nil},
+				Retained: 2,
+				MetaData: MetaData{DropPolicies: []string{hashPolicy("service=h1")}, KeepPolicies: []string{hashPolicy("name=ying")}},
+			},
+			{ID: 5, MaxT: theCurrentTime - 25*secondsInADay, Deleted: true},
+		},
+	}
+
+	assert.Equal(t, []Violation{
+		{BlockID: 1, Kind: ViolationDeletedEarly, Detail: "deleted while retained until " + strconv.FormatInt(theCurrentTime+5*secondsInADay, 10)},
+		{BlockID: 2, Kind: ViolationSeriesKeptTooLong, Detail: "series service=h1 is retained until " + strconv.FormatInt(theCurrentTime-2*secondsInADay, 10)},
+		{BlockID: 3, Kind: ViolationUnknownPolicy, Detail: "drop policy service=h0 is not configured"},
+		{BlockID: 3, Kind: ViolationRetainedMismatch, Detail: "retained 2 times but metadata records between 1 and 1 rewrites"},
+	}, AuditBucket(config, bucket, theCurrentTime, 0))
+
+	// within the grace period the late series is tolerated
+	assert.Equal(t, 3, len(AuditBucket(config, bucket, theCurrentTime, 3*secondsInADay)))
+}
+
+func TestAuditBucketAfterRetentionRuns(t *testing.T) {
+	config := UserConfig{
+		BaseRetention: 10 * secondsInADay,
+		Policies: []PerSeriesRetentionPolicy{
+			{RetentionPeriod: 5 * secondsInADay, Policy: "service=h1"},
+			{RetentionPeriod: 20 * secondsInADay, Policy: "name=ying"},
+			{RetentionPeriod: 30 * secondsInADay, Policy: "service=h1,name=ying"},
+		},
+	}
+	series := map[string]interface{}{"service=h1": nil, "service=h2": nil, "name=ying": nil, "name=ying,service=h1": nil, "name=ying,service=h2": nil}
+	bucket := &Bucket{}
+	for i := 0; i < 10; i++ {
+		bucket.Blocks = append(bucket.Blocks, Block{ID: i, MaxT: blockCreationTime + int64(i)*secondsInADay/2, Series: series})
+	}
+
+	// retention runs daily, so data may be up to a day late
+	for day := int64(0); day < 45; day++ {
+		now := blockCreationTime + day*secondsInADay
+		assert.NoError(t, ApplyBucketRetention(config, bucket, now))
+		assert.Equal(t, []Violation{}, AuditBucket(config, bucket, now, secondsInADay), "day %d", day)
+	}
+}
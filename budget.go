@@ -0,0 +1,244 @@
+package toyRetention
+
+import "sort"
+
+// seriesBudgetPolicyName stands for the series budget where a policy name is expected.
+const seriesBudgetPolicyName = "series_budget"
+
+// SeriesFamilyCut is a family of series dropped to meet the series budget.
+type SeriesFamilyCut struct {
+	Family string `json:"family"`
+	// Policy is the first policy matching the series, "default" for none.
+	Policy string `json:"policy"`
+	Series int64  `json:"series"`
+	Blocks []int  `json:"blocks"`
+}
+
+// SeriesBudgetReport tells how the bucket is brought under UserConfig.SeriesBudget.
+type SeriesBudgetReport struct {
+	Budget int64 `json:"budget"`
+	Window int64 `json:"window"`
+	// ActiveSeries counts the distinct series of the window once time-based
+	// retention ran, ActiveAfter once the budget was enforced as well.
+	ActiveSeries int64             `json:"active_series"`
+	ActiveAfter  int64             `json:"active_after"`
+	Cut          []SeriesFamilyCut `json:"cut"`
+}
+
+// Fits returns true if the window is within the series budget once the plan is applied.
+func (r SeriesBudgetReport) Fits() bool {
+	return r.Budget <= 0 || r.ActiveAfter <= r.Budget
+}
+
+// SeriesBudgetRecord is a series budget enforcement rewrite of a block.
+type SeriesBudgetRecord struct {
+	AppliedAt     int64 `json:"applied_at"`
+	SeriesDropped int64 `json:"series_dropped"`
+}
+
+// PlanSeriesBudget returns how the plan of PlanBucketRetention brings the bucket under
+// the configured series budget.
+func PlanSeriesBudget(policies UserConfig, userBucket *Bucket, currentTime int64) SeriesBudgetReport {
+	return planBucket(policies, userBucket, currentTime).seriesBudget
+}
+
+// seriesFamily is the metric name of the series, taken from its __name__ or name
+// label, or the series itself without either.
+func seriesFamily(series string) string {
+	labels := parseLabels(series)
+	if name := labels["__name__"]; name != "" {
+		return name
+	}
+	if name := labels["name"]; name != "" {
+		return name
+	}
+	return series
+}
+
+// seriesPriority is the index of the first policy matching the series, the base
+// retention coming last. The higher it is, the sooner the series is cut.
+func seriesPriority(policies []PerSeriesRetentionPolicy, series string) int {
+	for i, p := range policies {
+		if matchesPolicy(series, p.Policy) {
+			return i
+		}
+	}
+	return len(policies)
+}
+
+// enforceSeriesBudget adds the rewrites needed to bring the distinct series of the
+// blocks overlapping the budget window under UserConfig.SeriesBudget, once the
+// retention actions are applied. Only series found in no block younger than
+// UserConfig.MinRetention and under no legal hold can be cut. The lowest priority
+// series go first, the largest families of a priority before the smaller ones.
+func enforceSeriesBudget(policies UserConfig, blocks []Block, actions []Action, holds []LegalHold, currentTime int64) ([]Action, SeriesBudgetReport) {
+	report := SeriesBudgetReport{Budget: policies.SeriesBudget, Window: policies.SeriesBudgetWindow, Cut: []SeriesFamilyCut{}}
+	if policies.SeriesBudget <= 0 {
+		return actions, report
+	}
+	planned := map[int]int{}
+	for i, a := range actions {
+		planned[a.index] = i
+	}
+
+	// series found in a block that cannot be rewritten stay active whatever happens
+	active, pinned := map[string]bool{}, map[string]bool{}
+	for i, b := range blocks {
+		if b.Deleted || b.MaxT < currentTime-policies.SeriesBudgetWindow {
+			continue
+		}
+		j, ok := planned[i]
+		if ok && actions[j].Kind == ActionDelete {
+			continue
+		}
+		old := isBlockRetentionPassed(b.MaxT, currentTime, policies.MinRetention)
+		for s := range b.Series {
+			if ok {
+				if _, removed := seriesRemovedBy(s, b, actions[j]); removed {
+					continue
+				}
+			}
+			active[s] = true
+			if !old || seriesHeld(holds, b, s) {
+				pinned[s] = true
+			}
+		}
+	}
+	report.ActiveSeries = int64(len(active))
+	report.ActiveAfter = report.ActiveSeries
+	excess := report.ActiveSeries - policies.SeriesBudget
+	if excess <= 0 {
+		return actions, report
+	}
+
+	candidates := []string{}
+	familySize := map[string]int{}
+	for s := range active {
+		if !pinned[s] {
+			candidates = append(candidates, s)
+			familySize[seriesFamily(s)]++
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		si, sj := candidates[i], candidates[j]
+		if pi, pj := seriesPriority(policies.Policies, si), seriesPriority(policies.Policies, sj); pi != pj {
+			return pi > pj
+		}
+		fi, fj := seriesFamily(si), seriesFamily(sj)
+		if familySize[fi] != familySize[fj] {
+			return familySize[fi] > familySize[fj]
+		}
+		if fi != fj {
+			return fi < fj
+		}
+		return si < sj
+	})
+	if int64(len(candidates)) > excess {
+		candidates = candidates[:excess]
+	}
+	cut := map[string]bool{}
+	for _, s := range candidates {
+		cut[s] = true
+	}
+	report.ActiveAfter -= int64(len(candidates))
+
+	cutBlocks := map[string][]int{}
+	for i, b := range blocks {
+		if b.Deleted || b.MaxT < currentTime-policies.SeriesBudgetWindow {
+			continue
+		}
+		prev, ok := Action{}, false
+		if j, found := planned[i]; found {
+			prev, ok = actions[j], true
+		}
+		if ok && prev.Kind == ActionDelete {
+			continue
+		}
+		a := withBudgetSeries(b, prev, ok, cut, currentTime)
+		if len(a.BudgetSeries) == 0 {
+			continue
+		}
+		for _, s := range a.BudgetSeries {
+			cutBlocks[s] = append(cutBlocks[s], b.ID)
+		}
+		a.index, a.generation = i, b.MetaData.Generation
+		if ok {
+			actions[planned[i]] = a
+		} else {
+			planned[i] = len(actions)
+			actions = append(actions, a)
+		}
+	}
+	sort.SliceStable(actions, func(i, j int) bool {
+		return actions[i].index < actions[j].index
+	})
+
+	// families are reported in the order they were cut
+	families := map[string]int{}
+	for _, s := range candidates {
+		policy := defaultPolicyName
+		if p := seriesPriority(policies.Policies, s); p < len(policies.Policies) {
+			policy = policies.Policies[p].Policy
+		}
+		key := seriesFamily(s) + "\x00" + policy
+		i, ok := families[key]
+		if !ok {
+			i = len(report.Cut)
+			families[key] = i
+			report.Cut = append(report.Cut, SeriesFamilyCut{Family: seriesFamily(s), Policy: policy, Blocks: []int{}})
+		}
+		report.Cut[i].Series++
+		for _, id := range cutBlocks[s] {
+			if !containsInt(report.Cut[i].Blocks, id) {
+				report.Cut[i].Blocks = append(report.Cut[i].Blocks, id)
+			}
+		}
+	}
+	for _, c := range report.Cut {
+		sort.Ints(c.Blocks)
+	}
+	return actions, report
+}
+
+// withBudgetSeries extends the action planned for the block, if any, to drop the
+// series cut for the budget it still has.
+func withBudgetSeries(b Block, prev Action, ok bool, cut map[string]bool, currentTime int64) Action {
+	a := prev
+	if !ok {
+		a = Action{BlockID: b.ID, Kind: ActionRewrite, Deadline: currentTime}
+	}
+	a.BudgetSeries = nil
+	for s := range b.Series {
+		if !cut[s] {
+			continue
+		}
+		if _, removed := seriesRemovedBy(s, b, a); !removed {
+			a.BudgetSeries = append(a.BudgetSeries, s)
+		}
+	}
+	if len(a.BudgetSeries) == 0 {
+		return prev
+	}
+	sort.Strings(a.BudgetSeries)
+	a.SeriesDropped = seriesDroppedByPolicy(b, a)
+	a.EstimatedBytes = estimateReclaimedBytes(b, a)
+	return a
+}
+
+// logSeriesBudget records the series budget enforcement the action carries out, if any.
+func logSeriesBudget(md MetaData, a Action, currentTime int64) MetaData {
+	if len(a.BudgetSeries) == 0 {
+		return md
+	}
+	md.SeriesBudgetLog = append(md.SeriesBudgetLog, SeriesBudgetRecord{AppliedAt: currentTime, SeriesDropped: int64(len(a.BudgetSeries))})
+	return md
+}
+
+func containsInt(list []int, n int) bool {
+	for _, l := range list {
+		if l == n {
+			return true
+		}
+	}
+	return false
+}
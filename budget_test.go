@@ -0,0 +1,63 @@
+package toyRetention
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeriesBudget(t *testing.T) {
+	config := UserConfig{
+		BaseRetention: 30 * secondsInADay,
+		Policies: []PerSeriesRetentionPolicy{
+			{RetentionPeriod: 30 * secondsInADay, Policy: "service=api"},
+			{RetentionPeriod: 25 * secondsInADay, Policy: "team=infra"},
+		},
+		SeriesBudget:       4,
+		SeriesBudgetWindow: 10 * secondsInADay,
+		MinRetention:       2 * secondsInADay,
+	}
+	assert.Nil(t, ValidateConfig(config))
+	bucket := &Bucket{Blocks: []Block{
+		{ID: 1, MaxT: theCurrentTime - 20*secondsInADay, Series: map[string]interface{}{"name=junk,id=4": nil, "name=junk,id=5": nil}},
+		{ID: 2, MaxT: theCurrentTime - 5*secondsInADay, Stats: BlockStats{Bytes: 600}, Series: map[string]interface{}{
+			"name=req,service=api": nil, "name=cpu,team=infra,pod=a": nil, "name=cpu,team=infra,pod=b": nil,
+			"name=junk,id=1": nil, "name=junk,id=2": nil, "name=junk,id=3": nil,
+		}},
+		{ID: 3, MaxT: theCurrentTime - secondsInADay, Series: map[string]interface{}{"name=req,service=api": nil, "name=junk,id=3": nil}},
+	}}
+
+	// block 1 is out of the window and block 3 too recent to lose its series, the
+	// series matching no policy go first
+	actions := PlanBucketRetention(config, bucket, theCurrentTime)
+	assert.Equal(t, []int{2}, blockIDs(actions))
+	assert.Equal(t, []string{ReasonSeriesBudget}, actions[0].Reasons())
+	assert.Equal(t, []string{"name=junk,id=1", "name=junk,id=2"}, actions[0].BudgetSeries)
+	assert.Equal(t, int64(200), actions[0].EstimatedBytes)
+	assert.Equal(t, SeriesBudgetReport{
+		Budget:       4,
+		Window:       10 * secondsInADay,
+		ActiveSeries: 6,
+		ActiveAfter:  4,
+		Cut:          []SeriesFamilyCut{{Family: "junk", Policy: defaultPolicyName, Series: 2, Blocks: []int{2}}},
+	}, PlanSeriesBudget(config, bucket, theCurrentTime))
+
+	// then the series of the last policy
+	tight := config
+	tight.SeriesBudget = 1
+	report := PlanSeriesBudget(tight, bucket, theCurrentTime)
+	assert.Equal(t, []SeriesFamilyCut{
+		{Family: "junk", Policy: defaultPolicyName, Series: 2, Blocks: []int{2}},
+		{Family: "cpu", Policy: "team=infra", Series: 2, Blocks: []int{2}},
+	}, report.Cut)
+	assert.Equal(t, int64(2), report.ActiveAfter)
+	assert.False(t, report.Fits())
+
+	assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime))
+	b := bucket.Blocks[1]
+	assert.Equal(t, 4, len(b.Series))
+	assert.NotContains(t, b.Series, "name=junk,id=1")
+	assert.Equal(t, []SeriesBudgetRecord{{AppliedAt: theCurrentTime, SeriesDropped: 2}}, b.MetaData.SeriesBudgetLog)
+	assert.Empty(t, PlanBucketRetention(config, bucket, theCurrentTime))
+	assert.Equal(t, []Violation{}, AuditBucket(config, bucket, theCurrentTime, 0))
+}
@@ -0,0 +1,606 @@
+// Command toyretention plans and applies per-series retention on a filesystem bucket.
+//
+//	toyretention plan --bucket <dir> --config <file> [--now <unix>] [--output table|json] [--fail-on-delete]
+//	toyretention apply --bucket <dir> --config <file> [--now <unix>] [--owner <name>]
+//	toyretention inspect --bucket <dir> --block <id> [--config <file>] [--now <unix>] [--output table|json]
+//	toyretention validate-config --config <file> [--previous <file> [--bucket <dir>] [--now <unix>]]
+//	toyretention scenario <file>...
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"toyRetention"
+)
+
+const (
+	exitOK = iota
+	exitError
+	exitUsage
+	// exitWouldDelete is returned by plan when it would delete data, now or in a
+	// later run, and --fail-on-delete is set.
+	exitWouldDelete
+)
+
+const usage = `usage: toyretention <command> [flags]
+
+commands:
+  plan             show what retention would do to a bucket
+  apply            apply retention to a bucket
+  inspect          decode the retention metadata of a block
+  validate-config  check a retention config file
+  scenario         run scenario files and report mismatches
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+	switch args[0] {
+	case "plan":
+		return runPlan(args[1:], stdout, stderr)
+	case "apply":
+		return runApply(args[1:], stdout, stderr)
+	case "inspect":
+		return runInspect(args[1:], stdout, stderr)
+	case "validate-config":
+		return runValidateConfig(args[1:], stdout, stderr)
+	case "scenario":
+		return runScenario(args[1:], stdout, stderr)
+	case "-h", "--help", "help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", args[0], usage)
+		return exitUsage
+	}
+}
+
+// commonFlags are shared by the commands working on a bucket.
+type commonFlags struct {
+	bucket        string
+	config        string
+	now           int64
+	output        string
+	rewriteBudget int
+}
+
+func newFlagSet(name string, stderr io.Writer, c *commonFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&c.bucket, "bucket", "", "path of the filesystem bucket, the directory name is the tenant")
+	fs.StringVar(&c.config, "config", "", "path of the retention config file")
+	fs.Int64Var(&c.now, "now", time.Now().Unix(), "evaluation time as a unix timestamp")
+	fs.StringVar(&c.output, "output", "table", "output format, table or json")
+	fs.IntVar(&c.rewriteBudget, "rewrite-budget", 0, "maximum number of rewrites, 0 means unlimited")
+	return fs
+}
+
+func (c commonFlags) validate() error {
+	if c.bucket == "" || c.config == "" {
+		return errors.New("--bucket and --config are required")
+	}
+	return c.validateOutput()
+}
+
+func (c commonFlags) validateOutput() error {
+	if c.output != "table" && c.output != "json" {
+		return fmt.Errorf("unknown output format %q", c.output)
+	}
+	return nil
+}
+
+// load reads the bucket and a valid config.
+func (c commonFlags) load() (*toyRetention.Bucket, toyRetention.UserConfig, error) {
+	config, err := loadConfig(c.config)
+	if err != nil {
+		return nil, config, err
+	}
+	userBucket, err := toyRetention.LoadBucket(c.bucket)
+	return userBucket, config, err
+}
+
+func loadConfig(path string) (toyRetention.UserConfig, error) {
+	config, err := toyRetention.LoadConfig(path)
+	if err != nil {
+		return config, err
+	}
+	if errs := toyRetention.ValidateConfig(config); errs != nil {
+		return config, fmt.Errorf("invalid config: %v", errs[0])
+	}
+	return config, nil
+}
+
+type planOutput struct {
+	Tenant   string                    `json:"tenant"`
+	Now      int64                     `json:"now"`
+	Actions  []toyRetention.Action     `json:"actions"`
+	Deferred []toyRetention.Action     `json:"deferred"`
+	Cost     toyRetention.CostEstimate `json:"cost"`
+	// RemovedDropPolicies are applied to blocks but not configured anymore.
+	RemovedDropPolicies []toyRetention.DropPolicyStatus `json:"removed_drop_policies"`
+	// Held are the actions withheld because of legal holds.
+	Held []toyRetention.HeldAction `json:"held"`
+	// Pending are the rewrites held back to coalesce them with later changes.
+	Pending []toyRetention.PendingRewrite `json:"pending"`
+	// Quota is how the plan enforces the size quota, if the config has one.
+	Quota *toyRetention.QuotaReport `json:"quota,omitempty"`
+	// SeriesBudget is how the plan enforces the series budget, if the config has one.
+	SeriesBudget *toyRetention.SeriesBudgetReport `json:"series_budget,omitempty"`
+}
+
+func runPlan(args []string, stdout io.Writer, stderr io.Writer) int {
+	c := commonFlags{}
+	fs := newFlagSet("plan", stderr, &c)
+	failOnDelete := fs.Bool("fail-on-delete", false, "exit with code 3 when the plan deletes data, now or once deferred, held or pending")
+	costMonths := fs.Int("cost-months", 12, "number of months to project the cost of the config over")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if err := c.validate(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+	userBucket, config, err := c.load()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+
+	plan := toyRetention.PlanRetention(config, userBucket, c.now)
+	scheduled, deferred := toyRetention.ScheduleActions(plan.Actions, c.rewriteBudget)
+	out := planOutput{
+		Tenant:   userBucket.Tenant,
+		Now:      c.now,
+		Actions:  scheduled,
+		Deferred: deferred,
+		Cost:     toyRetention.EstimateCost(config, userBucket, c.now, *costMonths),
+
+		RemovedDropPolicies: toyRetention.RemovedDropPolicies(config, userBucket),
+		Held:                plan.Held,
+		Pending:             plan.Pending,
+	}
+	if config.MaxBytes > 0 {
+		out.Quota = &plan.Quota
+	}
+	if config.SeriesBudget > 0 {
+		out.SeriesBudget = &plan.SeriesBudget
+	}
+	if c.output == "json" {
+		err = writeJSON(stdout, out)
+	} else {
+		err = writePlanTable(stdout, out)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+
+	if *failOnDelete && deletesData(plannedActions(out)) {
+		fmt.Fprintln(stderr, "plan deletes data")
+		return exitWouldDelete
+	}
+	return exitOK
+}
+
+// plannedActions returns every action of the plan, whether it is carried out by this
+// run or deferred, held or pending until a later one.
+func plannedActions(out planOutput) []toyRetention.Action {
+	actions := append([]toyRetention.Action{}, out.Actions...)
+	actions = append(actions, out.Deferred...)
+	for _, h := range out.Held {
+		actions = append(actions, h.Action)
+	}
+	for _, p := range out.Pending {
+		actions = append(actions, p.Action)
+	}
+	return actions
+}
+
+// deletesData returns true if any action removes a block, series or samples from it.
+func deletesData(actions []toyRetention.Action) bool {
+	for _, a := range actions {
+		if a.Kind == toyRetention.ActionDelete || a.RewriteDropPolicy || a.RewriteKeepPolicy || len(a.DeletionRequests) > 0 || len(a.PartialDeletions) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func writePlanTable(w io.Writer, out planOutput) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "tenant %s, %d actions, %d deferred\n", out.Tenant, len(out.Actions), len(out.Deferred))
+	fmt.Fprintln(tw, "BLOCK\tACTION\tREASONS\tDROP POLICIES\tKEEP POLICIES\tEST. BYTES\tOVERDUE")
+	rows := func(actions []toyRetention.Action, deferred bool) {
+		for _, a := range actions {
+			action := a.Kind.String()
+			if deferred {
+				action += " (deferred)"
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%d\t%s\n", a.BlockID, action, strings.Join(a.Reasons(), ","),
+				listOrDash(a.DropPolicies), listOrDash(a.KeepPolicies), a.EstimatedBytes, formatAge(a.Overdue(out.Now)))
+		}
+	}
+	rows(out.Actions, false)
+	rows(out.Deferred, true)
+	for _, h := range out.Held {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%d\t%s\n", h.BlockID, h.Kind.String()+" (held by "+strings.Join(h.HeldBy, ",")+")", strings.Join(h.Reasons(), ","),
+			listOrDash(h.DropPolicies), listOrDash(h.KeepPolicies), h.EstimatedBytes, formatAge(h.Overdue(out.Now)))
+	}
+	for _, p := range out.Pending {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%d\t%s\n", p.BlockID, p.Kind.String()+" (pending until "+formatTime(p.FlushAt)+")", strings.Join(p.Reasons(), ","),
+			listOrDash(p.DropPolicies), listOrDash(p.KeepPolicies), p.EstimatedBytes, formatAge(p.Overdue(out.Now)))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	cost := out.Cost
+	_, err := fmt.Fprintf(w, "\nreclaimed now: %d bytes, within %d months: %d bytes\nrewrites within %d months: %d, reading %d bytes and writing %d bytes\n",
+		cost.ReclaimedNow, cost.Months, cost.ReclaimedOverHorizon, cost.Months, cost.Rewrites, cost.RewriteBytesRead, cost.RewriteBytesWritten)
+	if err != nil {
+		return err
+	}
+
+	if len(out.RemovedDropPolicies) > 0 {
+		fmt.Fprintln(w, "\ndrop policies no longer configured:")
+		tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "BLOCK\tPOLICY\tAPPLIED\tSTATE")
+		for _, status := range out.RemovedDropPolicies {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", status.BlockID, status.Policy, formatTime(status.AppliedAt), status.State)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	if out.SeriesBudget != nil {
+		if err := writeSeriesBudget(w, *out.SeriesBudget); err != nil {
+			return err
+		}
+	}
+	if out.Quota != nil {
+		return writeQuota(w, *out.Quota)
+	}
+	return nil
+}
+
+func writeSeriesBudget(w io.Writer, budget toyRetention.SeriesBudgetReport) error {
+	fmt.Fprintf(w, "\nseries budget: %d series over %s, %d active, %d after cuts\n",
+		budget.Budget, toyRetention.FormatRetentionPeriod(budget.Window), budget.ActiveSeries, budget.ActiveAfter)
+	if len(budget.Cut) > 0 {
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "FAMILY\tPOLICY\tSERIES\tBLOCKS")
+		for _, c := range budget.Cut {
+			blocks := make([]string, 0, len(c.Blocks))
+			for _, id := range c.Blocks {
+				blocks = append(blocks, strconv.Itoa(id))
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", c.Family, c.Policy, c.Series, listOrDash(blocks))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	if !budget.Fits() {
+		_, err := fmt.Fprintln(w, "window does not fit the series budget")
+		return err
+	}
+	return nil
+}
+
+func writeQuota(w io.Writer, quota toyRetention.QuotaReport) error {
+	fmt.Fprintf(w, "\nquota: %d bytes, %d bytes after retention, %d bytes after quota\n", quota.MaxBytes, quota.BytesAfterRetention, quota.BytesAfterQuota)
+	if len(quota.Steps) > 0 {
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "BLOCK\tACTION\tRECLAIMED\tBYTES AFTER")
+		for _, step := range quota.Steps {
+			action := step.Kind.String()
+			if len(step.HeldBy) > 0 {
+				action += " (held by " + strings.Join(step.HeldBy, ",") + ")"
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%d\t%d\n", step.BlockID, action, step.BytesReclaimed, step.BytesAfter)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	if !quota.Fits() {
+		_, err := fmt.Fprintln(w, "bucket does not fit the quota")
+		return err
+	}
+	return nil
+}
+
+type applyOutput struct {
+	Tenant string                 `json:"tenant"`
+	Now    int64                  `json:"now"`
+	Result toyRetention.RunResult `json:"result"`
+}
+
+func runApply(args []string, stdout io.Writer, stderr io.Writer) int {
+	c := commonFlags{}
+	fs := newFlagSet("apply", stderr, &c)
+	hostname, _ := os.Hostname()
+	owner := fs.String("owner", hostname, "name of this process in the bucket lock")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if err := c.validate(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+	engine := toyRetention.NewEngine(*owner)
+	engine.RewriteBudget = c.rewriteBudget
+	// the lock file keeps other processes off the bucket from loading it to saving it,
+	// its expiry is wall clock time whatever --now is
+	lease, err := toyRetention.AcquireDirLock(c.bucket, *owner, engine.LockTTL, time.Now().Unix())
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	defer lease.Release()
+
+	userBucket, config, err := c.load()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	result, err := engine.Run(config, userBucket, c.now)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	if err := lease.Renew(time.Now().Unix()); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	if err := toyRetention.SaveBucket(c.bucket, userBucket); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+
+	out := applyOutput{Tenant: userBucket.Tenant, Now: c.now, Result: result}
+	if c.output == "json" {
+		err = writeJSON(stdout, out)
+	} else {
+		err = writeApplyTable(stdout, out)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	return exitOK
+}
+
+func writeApplyTable(w io.Writer, out applyOutput) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "tenant %s, %d applied, %d deferred, %d vetoed\n", out.Tenant, len(out.Result.Applied), len(out.Result.Deferred), len(out.Result.Vetoed))
+	fmt.Fprintln(tw, "BLOCK\tACTION\tSTATUS\tREASONS")
+	rows := func(actions []toyRetention.Action, status string) {
+		for _, a := range actions {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", a.BlockID, a.Kind, status, strings.Join(a.Reasons(), ","))
+		}
+	}
+	rows(out.Result.Applied, "applied")
+	rows(out.Result.Deferred, "deferred")
+	rows(out.Result.Vetoed, "vetoed")
+	for _, h := range out.Result.Held {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", h.BlockID, h.Kind, "held by "+strings.Join(h.HeldBy, ","), strings.Join(h.Reasons(), ","))
+	}
+	for _, p := range out.Result.Pending {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", p.BlockID, p.Kind, "pending until "+formatTime(p.FlushAt), strings.Join(p.Reasons(), ","))
+	}
+	return tw.Flush()
+}
+
+func runInspect(args []string, stdout io.Writer, stderr io.Writer) int {
+	c := commonFlags{}
+	fs := newFlagSet("inspect", stderr, &c)
+	blockID := fs.Int("block", -1, "id of the block to inspect")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if c.bucket == "" || *blockID < 0 {
+		fmt.Fprintln(stderr, "--bucket and --block are required")
+		return exitUsage
+	}
+	if err := c.validateOutput(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+
+	// without a config there is nothing to be out of sync with
+	config := toyRetention.UserConfig{}
+	var err error
+	if c.config != "" {
+		if config, err = loadConfig(c.config); err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitError
+		}
+	}
+	userBucket, err := toyRetention.LoadBucket(c.bucket)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+
+	for i, b := range userBucket.Blocks {
+		if b.ID != *blockID {
+			continue
+		}
+		in := toyRetention.InspectBucketBlock(userBucket, i, config, c.now)
+		if c.output == "json" {
+			err = writeJSON(stdout, in)
+		} else {
+			err = writeInspection(stdout, in)
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitError
+		}
+		return exitOK
+	}
+	fmt.Fprintf(stderr, "block %d not found in %s\n", *blockID, c.bucket)
+	return exitError
+}
+
+func writeInspection(w io.Writer, in toyRetention.BlockInspection) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "block:\t%d\n", in.ID)
+	fmt.Fprintf(tw, "time range:\t%s - %s\n", formatTime(in.MinT), formatTime(in.MaxT))
+	fmt.Fprintf(tw, "retained:\t%d\n", in.Retained)
+	fmt.Fprintf(tw, "deleted:\t%t\n", in.Deleted)
+	fmt.Fprintf(tw, "drop policies:\t%s\n", listOrDash(in.DropPolicies))
+	for _, status := range in.DropPolicyStates {
+		if status.State != toyRetention.DropStateApplied {
+			fmt.Fprintf(tw, "  %s\t%s\n", status.Policy, status.State)
+		}
+	}
+	fmt.Fprintf(tw, "keep policy history (%d rewrites):\n", in.KeepPolicyRewrites)
+	for i, keepSet := range in.KeepPolicyHistory {
+		fmt.Fprintf(tw, "  %d\t%s\n", i, listOrDash(keepSet))
+	}
+	if len(in.DeletionRequests) > 0 {
+		fmt.Fprintf(tw, "deletion requests:\t%s\n", strings.Join(in.DeletionRequests, ","))
+	}
+	if in.Resolution > 0 || len(in.Downsampled) > 0 {
+		fmt.Fprintf(tw, "resolution:\t%s\n", toyRetention.FormatResolution(in.Resolution))
+		policies := make([]string, 0, len(in.Downsampled))
+		for p := range in.Downsampled {
+			policies = append(policies, p)
+		}
+		sort.Strings(policies)
+		for _, p := range policies {
+			fmt.Fprintf(tw, "  %s\t%s\n", p, toyRetention.FormatResolution(in.Downsampled[p]))
+		}
+	}
+	if len(in.Holds) > 0 {
+		fmt.Fprintf(tw, "legal holds:\t%s\n", strings.Join(in.Holds, ","))
+	}
+	fmt.Fprintln(tw, "out of sync with:")
+	for _, ps := range in.OutOfSync {
+		fmt.Fprintf(tw, "  %s\t%s\n", ps.Policy, ps.State)
+	}
+	return tw.Flush()
+}
+
+func runValidateConfig(args []string, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("validate-config", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configPath := fs.String("config", "", "path of the retention config file")
+	previousPath := fs.String("previous", "", "path of the config being replaced, to warn about retention extensions")
+	bucketPath := fs.String("bucket", "", "path of the filesystem bucket the config applies to, refines the extension warnings")
+	now := fs.Int64("now", time.Now().Unix(), "evaluation time as a unix timestamp")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *configPath == "" {
+		fmt.Fprintln(stderr, "--config is required")
+		return exitUsage
+	}
+	config, err := toyRetention.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	if errs := toyRetention.ValidateConfig(config); errs != nil {
+		for _, err := range errs {
+			fmt.Fprintln(stdout, err)
+		}
+		return exitError
+	}
+	fmt.Fprintf(stdout, "config is valid: base retention %s, %d policies\n", toyRetention.FormatRetentionPeriod(config.BaseRetention), len(config.Policies))
+	if *previousPath == "" {
+		return exitOK
+	}
+
+	previous, err := toyRetention.LoadConfig(*previousPath)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	var userBucket *toyRetention.Bucket
+	if *bucketPath != "" {
+		if userBucket, err = toyRetention.LoadBucket(*bucketPath); err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitError
+		}
+	}
+	for _, e := range toyRetention.FindRetentionExtensions(previous, config, userBucket, *now) {
+		if e.DataGone {
+			fmt.Fprintf(stdout, "warning: %s\n", e)
+		}
+	}
+	return exitOK
+}
+
+// runScenario runs every scenario file given and fails if any of them fails.
+func runScenario(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "at least one scenario file is required")
+		return exitUsage
+	}
+	code := exitOK
+	for _, path := range args {
+		s, err := toyRetention.LoadScenario(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", path, err)
+			code = exitError
+			continue
+		}
+		result, err := toyRetention.RunScenario(s)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", path, err)
+			code = exitError
+			continue
+		}
+		if result.Passed() {
+			fmt.Fprintf(stdout, "PASS %s (%s)\n", path, s.Name)
+			continue
+		}
+		fmt.Fprintf(stdout, "FAIL %s (%s)\n%s", path, s.Name, result.Diff())
+		code = exitError
+	}
+	return code
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func listOrDash(l []string) string {
+	if len(l) == 0 {
+		return "-"
+	}
+	return strings.Join(l, ",")
+}
+
+func formatTime(unix int64) string {
+	return time.Unix(unix, 0).UTC().Format(time.RFC3339)
+}
+
+// formatAge renders a number of seconds in whole days, or as a duration below a day.
+func formatAge(seconds int64) string {
+	const day = 24 * 60 * 60
+	if seconds >= day || seconds <= -day {
+		return fmt.Sprintf("%dd", seconds/day)
+	}
+	return (time.Duration(seconds) * time.Second).String()
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"toyRetention"
+)
+
+const secondsInADay = int64(24 * 60 * 60)
+
+var now = int64(1700000000)
+
+func setup(t *testing.T) (string, string) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.json")
+	assert.NoError(t, os.WriteFile(configPath, []byte(`{
+		"base_retention": "10d",
+		"policies": [
+			{"retention_period": "5d", "policy": "service=h1"},
+			{"retention_period": "20d", "policy": "name=ying"}
+		]
+	}`), 0o644))
+
+	bucketDir := filepath.Join(dir, "team-a")
+	assert.NoError(t, toyRetention.SaveBucket(bucketDir, &toyRetention.Bucket{
+		Blocks: []toyRetention.Block{
+			{ID: 1, MaxT: now - 2*secondsInADay},
+			{ID: 2, MaxT: now - 6*secondsInADay, Series: map[string]interface{}{"service=h1": nil, "service=h2": nil}, Stats: toyRetention.BlockStats{Bytes: 1000}},
+			{ID: 3, MaxT: now - 30*secondsInADay},
+		},
+	}))
+	return bucketDir, configPath
+}
+
+func TestPlan(t *testing.T) {
+	bucketDir, configPath := setup(t)
+	nowFlag := "--now=" + strconv.FormatInt(now, 10)
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := run([]string{"plan", "--bucket", bucketDir, "--config", configPath, nowFlag}, stdout, stderr)
+	assert.Equal(t, exitOK, code, stderr.String())
+	assert.Equal(t, `tenant team-a, 2 actions, 0 deferred
+BLOCK  ACTION   REASONS                DROP POLICIES  KEEP POLICIES  EST. BYTES  OVERDUE
+2      rewrite  drop_policies_changed  service=h1     -              500         1d
+3      delete   retention_passed       -              -              0           10d
+
+reclaimed now: 500 bytes, within 12 months: 1000 bytes
+rewrites within 12 months: 4, reading 1500 bytes and writing 500 bytes
+`, stdout.String())
+
+	stdout.Reset()
+	code = run([]string{"plan", "--bucket", bucketDir, "--config", configPath, nowFlag, "--output=json", "--fail-on-delete"}, stdout, stderr)
+	assert.Equal(t, exitWouldDelete, code)
+	out := planOutput{}
+	assert.NoError(t, json.Unmarshal(stdout.Bytes(), &out))
+	assert.Equal(t, "team-a", out.Tenant)
+	assert.Equal(t, 2, len(out.Actions))
+	assert.Equal(t, toyRetention.ActionDelete, out.Actions[1].Kind)
+	assert.Equal(t, 13, len(out.Cost.PerMonth))
+
+	// planning does not change the bucket
+	userBucket, err := toyRetention.LoadBucket(bucketDir)
+	assert.NoError(t, err)
+	assert.Equal(t, false, userBucket.Blocks[2].Deleted)
+}
+
+func TestPlanWithHolds(t *testing.T) {
+	bucketDir, configPath := setup(t)
+	assert.NoError(t, os.WriteFile(filepath.Join(bucketDir, "holds.json"), []byte(`[{"id": "case-1", "block_ids": [3]}]`), 0o644))
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := run([]string{"plan", "--bucket", bucketDir, "--config", configPath, "--now=" + strconv.FormatInt(now, 10)}, stdout, stderr)
+	assert.Equal(t, exitOK, code, stderr.String())
+	assert.Equal(t, `tenant team-a, 1 actions, 0 deferred
+BLOCK  ACTION                   REASONS                DROP POLICIES  KEEP POLICIES  EST. BYTES  OVERDUE
+2      rewrite                  drop_policies_changed  service=h1     -              500         1d
+3      delete (held by case-1)  retention_passed       -              -              0           10d
+
+reclaimed now: 500 bytes, within 12 months: 1000 bytes
+rewrites within 12 months: 4, reading 1500 bytes and writing 500 bytes
+`, stdout.String())
+
+	// held deletions count for --fail-on-delete although nothing is carried out now
+	assert.NoError(t, os.WriteFile(filepath.Join(bucketDir, "holds.json"), []byte(`[{"id": "case-1", "block_ids": [2, 3]}]`), 0o644))
+	stdout.Reset()
+	code = run([]string{"plan", "--bucket", bucketDir, "--config", configPath, "--now=" + strconv.FormatInt(now, 10), "--output=json", "--fail-on-delete"}, stdout, stderr)
+	assert.Equal(t, exitWouldDelete, code)
+	out := planOutput{}
+	assert.NoError(t, json.Unmarshal(stdout.Bytes(), &out))
+	assert.Empty(t, out.Actions)
+	assert.Equal(t, 2, len(out.Held))
+}
+
+func TestPlanWithQuota(t *testing.T) {
+	bucketDir, _ := setup(t)
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	assert.NoError(t, os.WriteFile(configPath, []byte(`{
+		"base_retention": "10d",
+		"policies": [{"retention_period": "5d", "policy": "service=h1"}],
+		"max_bytes": 100,
+		"min_retention": "1d"
+	}`), 0o644))
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := run([]string{"plan", "--bucket", bucketDir, "--config", configPath, "--now=" + strconv.FormatInt(now, 10), "--cost-months=0"}, stdout, stderr)
+	assert.Equal(t, exitOK, code, stderr.String())
+	assert.Equal(t, `tenant team-a, 2 actions, 0 deferred
+BLOCK  ACTION  REASONS           DROP POLICIES  KEEP POLICIES  EST. BYTES  OVERDUE
+2      delete  quota_exceeded    -              -              1000        0s
+3      delete  retention_passed  -              -              0           20d
+
+reclaimed now: 1000 bytes, within 0 months: 1000 bytes
+rewrites within 0 months: 0, reading 0 bytes and writing 0 bytes
+
+quota: 100 bytes, 500 bytes after retention, 0 bytes after quota
+BLOCK  ACTION  RECLAIMED  BYTES AFTER
+2      delete  500        0
+`, stdout.String())
+}
+
+func TestPlanWithSeriesBudget(t *testing.T) {
+	bucketDir, _ := setup(t)
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	assert.NoError(t, os.WriteFile(configPath, []byte(`{
+		"base_retention": "10d",
+		"policies": [{"retention_period": "20d", "policy": "name=ying"}],
+		"series_budget": 1,
+		"series_budget_window": "10d",
+		"min_retention": "5d"
+	}`), 0o644))
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := run([]string{"plan", "--bucket", bucketDir, "--config", configPath, "--now=" + strconv.FormatInt(now, 10), "--cost-months=0"}, stdout, stderr)
+	assert.Equal(t, exitOK, code, stderr.String())
+	assert.Equal(t, `tenant team-a, 2 actions, 0 deferred
+BLOCK  ACTION   REASONS                 DROP POLICIES  KEEP POLICIES  EST. BYTES  OVERDUE
+2      rewrite  series_budget_exceeded  -              -              500         0s
+3      delete   retention_passed        -              -              0           10d
+
+reclaimed now: 500 bytes, within 0 months: 500 bytes
+rewrites within 0 months: 1, reading 1000 bytes and writing 500 bytes
+
+series budget: 1 series over 10d, 2 active, 1 after cuts
+FAMILY      POLICY   SERIES  BLOCKS
+service=h1  default  1       2
+`, stdout.String())
+}
+
+func TestPlanWithCoalescing(t *testing.T) {
+	bucketDir, _ := setup(t)
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	assert.NoError(t, os.WriteFile(configPath, []byte(`{
+		"base_retention": "10d",
+		"policies": [{"retention_period": "5d", "policy": "service=h1"}],
+		"coalesce": {"max_delay": "3d"}
+	}`), 0o644))
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := run([]string{"plan", "--bucket", bucketDir, "--config", configPath, "--now=" + strconv.FormatInt(now, 10), "--cost-months=0"}, stdout, stderr)
+	assert.Equal(t, exitOK, code, stderr.String())
+	assert.Equal(t, `tenant team-a, 1 actions, 0 deferred
+BLOCK  ACTION                                        REASONS                DROP POLICIES  KEEP POLICIES  EST. BYTES  OVERDUE
+3      delete                                        retention_passed       -              -              0           20d
+2      rewrite (pending until 2023-11-17T22:13:20Z)  drop_policies_changed  service=h1     -              500         1d
+
+reclaimed now: 0 bytes, within 0 months: 0 bytes
+rewrites within 0 months: 0, reading 0 bytes and writing 0 bytes
+`, stdout.String())
+}
+
+func TestApply(t *testing.T) {
+	bucketDir, configPath := setup(t)
+	nowFlag := "--now=" + strconv.FormatInt(now, 10)
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := run([]string{"apply", "--bucket", bucketDir, "--config", configPath, nowFlag, "--owner", "test"}, stdout, stderr)
+	assert.Equal(t, exitOK, code, stderr.String())
+	assert.Contains(t, stdout.String(), "tenant team-a, 2 applied, 0 deferred, 0 vetoed")
+
+	userBucket, err := toyRetention.LoadBucket(bucketDir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, userBucket.Blocks[1].Retained)
+	assert.Equal(t, true, userBucket.Blocks[2].Deleted)
+
+	// the bucket lock is released
+	_, err = os.Stat(filepath.Join(bucketDir, "lock.json"))
+	assert.True(t, os.IsNotExist(err))
+
+	// nothing left to do, even with --fail-on-delete
+	stdout.Reset()
+	code = run([]string{"plan", "--bucket", bucketDir, "--config", configPath, nowFlag, "--fail-on-delete"}, stdout, stderr)
+	assert.Equal(t, exitOK, code)
+}
+
+func TestApplyLocked(t *testing.T) {
+	bucketDir, configPath := setup(t)
+	lockPath := filepath.Join(bucketDir, "lock.json")
+	assert.NoError(t, os.WriteFile(lockPath, []byte(`{"Owner": "other", "ExpiresAt": 4102444800}`), 0o644))
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := run([]string{"apply", "--bucket", bucketDir, "--config", configPath, "--now=" + strconv.FormatInt(now, 10), "--owner", "test"}, stdout, stderr)
+	assert.Equal(t, exitError, code)
+	assert.Equal(t, "bucket is locked by \"other\" until 4102444800\n", stderr.String())
+
+	// the lock of the other process is left alone, and so is the bucket
+	_, err := os.Stat(lockPath)
+	assert.NoError(t, err)
+	userBucket, err := toyRetention.LoadBucket(bucketDir)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, userBucket.Blocks[1].Retained)
+}
+
+func TestValidateConfig(t *testing.T) {
+	_, configPath := setup(t)
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	assert.Equal(t, exitOK, run([]string{"validate-config", "--config", configPath}, stdout, stderr))
+	assert.Equal(t, "config is valid: base retention 10d, 2 policies\n", stdout.String())
+
+	invalid := filepath.Join(t.TempDir(), "invalid.json")
+	assert.NoError(t, os.WriteFile(invalid, []byte(`{"base_retention": "0d", "policies": [{"retention_period": "1d", "policy": "oops"}]}`), 0o644))
+	stdout.Reset()
+	assert.Equal(t, exitError, run([]string{"validate-config", "--config", invalid}, stdout, stderr))
+	assert.Equal(t, "base retention must be positive\npolicy 0 (\"oops\"): expected label pairs like name=value\n", stdout.String())
+}
+
+func TestValidateConfigWarnsAboutExtensions(t *testing.T) {
+	bucketDir, previousPath := setup(t)
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	assert.NoError(t, os.WriteFile(configPath, []byte(`{
+		"base_retention": "10d",
+		"policies": [
+			{"retention_period": "8d", "policy": "service=h1"},
+			{"retention_period": "20d", "policy": "name=ying"}
+		]
+	}`), 0o644))
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	assert.Equal(t, exitOK, run([]string{"apply", "--bucket", bucketDir, "--config", previousPath, "--now=" + strconv.FormatInt(now, 10)}, stdout, stderr), stderr.String())
+
+	stdout.Reset()
+	code := run([]string{"validate-config", "--config", configPath, "--previous", previousPath, "--bucket", bucketDir, "--now=" + strconv.FormatInt(now, 10)}, stdout, stderr)
+	assert.Equal(t, exitOK, code, stderr.String())
+	assert.Equal(t, `config is valid: base retention 10d, 2 policies
+warning: service=h1: retention extended from 5d to 8d, data from 1970-01-01T00:00:00Z to 2023-11-08T22:13:20Z is already gone, extension only effective from 2023-11-08T22:13:20Z
+`, stdout.String())
+}
+
+func TestUsage(t *testing.T) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	assert.Equal(t, exitUsage, run(nil, stdout, stderr))
+	assert.Equal(t, exitUsage, run([]string{"nope"}, stdout, stderr))
+	assert.Equal(t, exitUsage, run([]string{"plan"}, stdout, stderr))
+}
+
+func TestInspect(t *testing.T) {
+	bucketDir, configPath := setup(t)
+	nowFlag := "--now=" + strconv.FormatInt(now, 10)
+	assert.Equal(t, exitOK, run([]string{"apply", "--bucket", bucketDir, "--config", configPath, nowFlag}, &bytes.Buffer{}, &bytes.Buffer{}))
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := run([]string{"inspect", "--bucket", bucketDir, "--block", "2", "--config", configPath, "--now=" + strconv.FormatInt(now+5*secondsInADay, 10)}, stdout, stderr)
+	assert.Equal(t, exitOK, code, stderr.String())
+	assert.Equal(t, `block:          2
+time range:     1970-01-01T00:00:00Z - 2023-11-08T22:13:20Z
+retained:       1
+deleted:        false
+drop policies:  service=h1
+keep policy history (0 rewrites):
+out of sync with:
+  name=ying  kept but not in the recorded keep set
+`, stdout.String())
+
+	stdout.Reset()
+	code = run([]string{"inspect", "--bucket", bucketDir, "--block", "2", "--output", "json"}, stdout, stderr)
+	assert.Equal(t, exitOK, code, stderr.String())
+	in := toyRetention.BlockInspection{}
+	assert.NoError(t, json.Unmarshal(stdout.Bytes(), &in))
+	assert.Equal(t, []string{"service=h1"}, in.DropPolicies)
+	assert.Equal(t, []toyRetention.PolicySync{}, in.OutOfSync)
+
+	assert.Equal(t, exitError, run([]string{"inspect", "--bucket", bucketDir, "--block", "42"}, stdout, stderr))
+}
+
+func TestScenario(t *testing.T) {
+	failing := filepath.Join(t.TempDir(), "failing.json")
+	assert.NoError(t, os.WriteFile(failing, []byte(`{
+		"name": "wrong",
+		"blocks": [{"id": 1, "max_time": "0d"}],
+		"steps": [{"at": "1d", "config": {"base_retention": "10d"}, "expect": [{"id": 1, "deleted": true}]}]
+	}`), 0o644))
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	passing := "../../testdata/scenarios/policy_churn.json"
+	assert.Equal(t, exitOK, run([]string{"scenario", passing}, stdout, stderr), stderr.String())
+	assert.Equal(t, "PASS "+passing+" (one block through three years of policy churn)\n", stdout.String())
+
+	stdout.Reset()
+	assert.Equal(t, exitError, run([]string{"scenario", passing, failing}, stdout, stderr))
+	assert.Contains(t, stdout.String(), "FAIL "+failing+" (wrong)\nstep 0 (at 1d), block 1, deleted:\n- expected: true\n+ actual:   false\n")
+}
@@ -0,0 +1,112 @@
+package toyRetention
+
+// RewriteCoalescing holds back the rewrites applying drop and keep policy changes so
+// that changes due at different times are applied to a block in a single rewrite.
+// Deletions, including those legal holds turn into rewrites, deletion requests,
+// downsampling, the quota and the series budget are never held back, and pending
+// changes are applied along with them.
+type RewriteCoalescing struct {
+	// MaxDelay is how long the first pending change of a block may wait, from the run
+	// that first held it back, 0 disables coalescing.
+	MaxDelay int64
+	// MinBytes rewrites the block as soon as its pending changes reclaim that many
+	// bytes, 0 for no such threshold.
+	MinBytes int64
+}
+
+// PendingRewrite is a rewrite held back to coalesce it with later changes.
+type PendingRewrite struct {
+	Action
+	// FlushAt is when the rewrite happens at the latest, MaxDelay after the block
+	// started pending, unless more bytes or a tier boundary make it happen sooner.
+	FlushAt int64 `json:"flush_at"`
+}
+
+// PlanPendingRewrites returns the rewrites coalescing holds back at currentTime.
+func PlanPendingRewrites(policies UserConfig, userBucket *Bucket, currentTime int64) []PendingRewrite {
+	return planBucket(policies, userBucket, currentTime).pending
+}
+
+// holdsBack returns true if the rewrite can wait for more changes to accumulate:
+// the block has not been pending for MaxDelay yet, the rewrite reclaims less than
+// MinBytes and the block is not crossing a tier boundary, that is getting its first
+// keep set once base retention passed or being downsampled.
+func (c RewriteCoalescing) holdsBack(b Block, a Action, currentTime int64) bool {
+	if c.MaxDelay <= 0 || a.Kind == ActionDelete {
+		return false
+	}
+	if len(a.DeletionRequests) > 0 || len(a.ExpiredSeries) > 0 || a.Resolution > 0 || len(a.Downsample) > 0 || a.Quota || len(a.BudgetSeries) > 0 {
+		return false
+	}
+	if a.RewriteKeepPolicy && currentKeepPolicy(b.MetaData) == "" {
+		return false
+	}
+	if c.MinBytes > 0 && a.EstimatedBytes >= c.MinBytes {
+		return false
+	}
+	return currentTime-pendingSince(b.MetaData, currentTime) < c.MaxDelay
+}
+
+// pendingSince is when the block started pending, currentTime if it is not yet.
+func pendingSince(md MetaData, currentTime int64) int64 {
+	if md.PendingSince == 0 {
+		return currentTime
+	}
+	return md.PendingSince
+}
+
+// markPending records in block metadata since when the blocks coalescing holds back
+// have been pending, and forgets it for the blocks nothing is planned for anymore.
+// Blocks written meanwhile are left for the next run.
+func markPending(userBucket *Bucket, plan bucketPlan, currentTime int64) error {
+	pending, planned := map[int]bool{}, map[int]bool{}
+	for _, p := range plan.pending {
+		pending[p.index] = true
+	}
+	for _, a := range plan.actions {
+		planned[a.index] = true
+	}
+	for _, h := range plan.held {
+		planned[h.index] = true
+	}
+	for i, b := range userBucket.snapshot() {
+		switch {
+		case pending[i] && b.MetaData.PendingSince == 0:
+			b.MetaData.PendingSince = currentTime
+		case !pending[i] && !planned[i] && b.MetaData.PendingSince != 0:
+			b.MetaData.PendingSince = 0
+		default:
+			continue
+		}
+		if err := userBucket.WriteBlock(i, b, b.MetaData.Generation); err != nil && err != ErrConflict {
+			return err
+		}
+	}
+	return nil
+}
+
+// flushPending merges the changes pending for blocks the quota or the series budget
+// rewrite anyway into their rewrite, and returns the rewrites still pending.
+func flushPending(blocks []Block, actions []Action, pending []PendingRewrite) ([]Action, []PendingRewrite) {
+	planned := map[int]int{}
+	for i, a := range actions {
+		planned[a.index] = i
+	}
+	left := []PendingRewrite{}
+	for _, p := range pending {
+		j, ok := planned[p.index]
+		if !ok {
+			left = append(left, p)
+			continue
+		}
+		if actions[j].Kind == ActionDelete {
+			continue
+		}
+		a := p.Action
+		a.Quota, a.QuotaSeries, a.BudgetSeries = actions[j].Quota, actions[j].QuotaSeries, actions[j].BudgetSeries
+		a.SeriesDropped = seriesDroppedByPolicy(blocks[p.index], a)
+		a.EstimatedBytes = estimateReclaimedBytes(blocks[p.index], a)
+		actions[j] = a
+	}
+	return actions, left
+}
@@ -0,0 +1,162 @@
+package toyRetention
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func coalescingBucket(maxT int64) *Bucket {
+	return &Bucket{Blocks: []Block{{
+		ID:     1,
+		MinT:   maxT - secondsInADay,
+		MaxT:   maxT,
+		Series: map[string]interface{}{"service=h1": nil, "service=h2": nil, "service=h3": nil, "service=h4": nil},
+		Stats:  BlockStats{Bytes: 400, NumSeries: 4, NumSamples: 400},
+	}}}
+}
+
+func TestCoalescing(t *testing.T) {
+	config := UserConfig{
+		BaseRetention: 30 * secondsInADay,
+		Policies: []PerSeriesRetentionPolicy{
+			{RetentionPeriod: 5 * secondsInADay, Policy: "service=h1"},
+			{RetentionPeriod: 6 * secondsInADay, Policy: "service=h2"},
+			{RetentionPeriod: 7 * secondsInADay, Policy: "service=h3"},
+		},
+	}
+	maxT := theCurrentTime - 20*secondsInADay
+
+	// every drop policy costs a rewrite of its own
+	bucket := coalescingBucket(maxT)
+	for day := int64(1); day <= 10; day++ {
+		assert.NoError(t, ApplyBucketRetention(config, bucket, maxT+day*secondsInADay))
+	}
+	assert.Equal(t, 3, bucket.Blocks[0].Retained)
+
+	// the changes wait for the first one to be three days overdue
+	config.Coalescing = RewriteCoalescing{MaxDelay: 3 * secondsInADay}
+	assert.Nil(t, ValidateConfig(config))
+	bucket = coalescingBucket(maxT)
+	for day := int64(1); day < 8; day++ {
+		at := maxT + day*secondsInADay
+		assert.NoError(t, ApplyBucketRetention(config, bucket, at))
+		if day >= 5 {
+			pending := PlanPendingRewrites(config, bucket, at)
+			assert.Equal(t, []int{1}, blockIDs(actionsOf(pending)))
+			assert.Equal(t, maxT+8*secondsInADay, pending[0].FlushAt)
+		}
+	}
+	assert.Equal(t, 0, bucket.Blocks[0].Retained)
+	assert.Equal(t, 4, len(bucket.Blocks[0].Series))
+
+	at := maxT + 8*secondsInADay
+	actions := PlanBucketRetention(config, bucket, at)
+	assert.Equal(t, []string{"service=h1", "service=h2", "service=h3"}, actions[0].DropPolicies)
+	assert.Empty(t, PlanPendingRewrites(config, bucket, at))
+	assert.NoError(t, ApplyBucketRetention(config, bucket, at))
+	assert.Equal(t, 1, bucket.Blocks[0].Retained)
+	assert.Equal(t, map[string]interface{}{"service=h4": nil}, bucket.Blocks[0].Series)
+	assert.Equal(t, []Violation{}, AuditBucket(config, bucket, at, 0))
+
+	// deletions are never held back
+	at = maxT + 30*secondsInADay
+	actions = PlanBucketRetention(config, bucket, at)
+	assert.Equal(t, ActionDelete, actions[0].Kind)
+	assert.Empty(t, PlanPendingRewrites(config, bucket, at))
+}
+
+func TestCoalescingConfigEdits(t *testing.T) {
+	config := UserConfig{BaseRetention: 60 * secondsInADay, Coalescing: RewriteCoalescing{MaxDelay: 3 * secondsInADay}}
+	maxT := theCurrentTime - 30*secondsInADay
+	bucket := coalescingBucket(maxT)
+
+	// a policy is added every day, each one long overdue for the block, and they wait
+	// for the first one to have been pending for three days
+	for day, policy := range []string{"service=h1", "service=h2", "service=h3"} {
+		at := theCurrentTime + int64(day)*secondsInADay
+		config.Policies = append(config.Policies, PerSeriesRetentionPolicy{RetentionPeriod: 5 * secondsInADay, Policy: policy})
+		assert.NoError(t, ApplyBucketRetention(config, bucket, at))
+		assert.Equal(t, 0, bucket.Blocks[0].Retained)
+		assert.Equal(t, theCurrentTime, bucket.Blocks[0].MetaData.PendingSince)
+		assert.Equal(t, theCurrentTime+3*secondsInADay, PlanPendingRewrites(config, bucket, at)[0].FlushAt)
+	}
+
+	at := theCurrentTime + 3*secondsInADay
+	assert.NoError(t, ApplyBucketRetention(config, bucket, at))
+	assert.Equal(t, 1, bucket.Blocks[0].Retained)
+	assert.Equal(t, map[string]interface{}{"service=h4": nil}, bucket.Blocks[0].Series)
+	assert.Equal(t, int64(0), bucket.Blocks[0].MetaData.PendingSince)
+
+	// a change that is no longer planned is forgotten
+	config.Policies = append(config.Policies, PerSeriesRetentionPolicy{RetentionPeriod: 5 * secondsInADay, Policy: "service=h4"})
+	assert.NoError(t, ApplyBucketRetention(config, bucket, at+secondsInADay))
+	assert.Equal(t, at+secondsInADay, bucket.Blocks[0].MetaData.PendingSince)
+	config.Policies = config.Policies[:3]
+	assert.NoError(t, ApplyBucketRetention(config, bucket, at+2*secondsInADay))
+	assert.Equal(t, int64(0), bucket.Blocks[0].MetaData.PendingSince)
+	assert.Equal(t, 1, bucket.Blocks[0].Retained)
+}
+
+func TestCoalescingMinBytes(t *testing.T) {
+	config := UserConfig{
+		BaseRetention: 30 * secondsInADay,
+		Policies: []PerSeriesRetentionPolicy{
+			{RetentionPeriod: 5 * secondsInADay, Policy: "service=h1"},
+			{RetentionPeriod: 6 * secondsInADay, Policy: "service=h2"},
+			{RetentionPeriod: 7 * secondsInADay, Policy: "service=h3"},
+		},
+		Coalescing: RewriteCoalescing{MaxDelay: 3 * secondsInADay, MinBytes: 200},
+	}
+	assert.Nil(t, ValidateConfig(config))
+	maxT := theCurrentTime - 20*secondsInADay
+	bucket := coalescingBucket(maxT)
+
+	// two policies reclaim enough to rewrite the block at once, the third one waits
+	assert.NoError(t, ApplyBucketRetention(config, bucket, maxT+5*secondsInADay))
+	assert.Equal(t, 0, bucket.Blocks[0].Retained)
+	assert.NoError(t, ApplyBucketRetention(config, bucket, maxT+6*secondsInADay))
+	assert.Equal(t, 1, bucket.Blocks[0].Retained)
+	assert.Equal(t, 2, len(bucket.Blocks[0].Series))
+
+	pending := PlanPendingRewrites(config, bucket, maxT+7*secondsInADay)
+	assert.Equal(t, int64(100), pending[0].EstimatedBytes)
+	assert.Equal(t, maxT+10*secondsInADay, pending[0].FlushAt)
+}
+
+func TestCoalescingTierBoundary(t *testing.T) {
+	config := UserConfig{
+		BaseRetention: 30 * secondsInADay,
+		Policies: []PerSeriesRetentionPolicy{
+			{RetentionPeriod: 5 * secondsInADay, Policy: "service=h1"},
+			{RetentionPeriod: 60 * secondsInADay, Policy: "service=h2"},
+		},
+		Downsample: []DownsampleRule{{After: 6 * secondsInADay, Resolution: 300}},
+		Coalescing: RewriteCoalescing{MaxDelay: 10 * secondsInADay},
+	}
+	maxT := theCurrentTime - 40*secondsInADay
+	bucket := coalescingBucket(maxT)
+
+	assert.NoError(t, ApplyBucketRetention(config, bucket, maxT+5*secondsInADay))
+	assert.Equal(t, 0, bucket.Blocks[0].Retained)
+
+	// downsampling is not held back and takes the pending drop along
+	actions := PlanBucketRetention(config, bucket, maxT+6*secondsInADay)
+	assert.Equal(t, []string{"service=h1"}, actions[0].DropPolicies)
+	assert.Equal(t, int64(300), actions[0].Resolution)
+	assert.NoError(t, ApplyBucketRetention(config, bucket, maxT+6*secondsInADay))
+	assert.Equal(t, 1, bucket.Blocks[0].Retained)
+
+	// neither is the first keep set once base retention passed
+	actions = PlanBucketRetention(config, bucket, maxT+30*secondsInADay)
+	assert.Equal(t, []int{1}, blockIDs(actions))
+	assert.True(t, actions[0].RewriteKeepPolicy)
+}
+
+func actionsOf(pending []PendingRewrite) []Action {
+	actions := make([]Action, 0, len(pending))
+	for _, p := range pending {
+		actions = append(actions, p.Action)
+	}
+	return actions
+}
@@ -0,0 +1,384 @@
+package toyRetention
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConfigFile is the file form of a UserConfig, with human readable periods.
+type ConfigFile struct {
+	BaseRetention string `json:"base_retention"`
+	// BaseRetentionByResolution is UserConfig.BaseRetentionByResolution, keyed by
+	// resolutions such as "raw", "5m" or "1h".
+	BaseRetentionByResolution map[string]string `json:"base_retention_by_resolution,omitempty"`
+	Policies                  []PolicyFile      `json:"policies"`
+	// KeepHistoryLimit is UserConfig.KeepHistoryLimit, -1 keeps the full history.
+	KeepHistoryLimit int `json:"keep_history_limit,omitempty"`
+	// Downsample is UserConfig.Downsample, for whole blocks.
+	Downsample []DownsampleFile `json:"downsample,omitempty"`
+	// MaxBytes and MinRetention are the size quota of UserConfig.
+	MaxBytes     int64  `json:"max_bytes,omitempty"`
+	MinRetention string `json:"min_retention,omitempty"`
+	// SeriesBudget and SeriesBudgetWindow are the series budget of UserConfig.
+	SeriesBudget       int64  `json:"series_budget,omitempty"`
+	SeriesBudgetWindow string `json:"series_budget_window,omitempty"`
+	// Coalesce is UserConfig.Coalescing, such as {"max_delay": "30d", "min_bytes": 1000000}.
+	Coalesce *CoalesceFile `json:"coalesce,omitempty"`
+}
+
+// CoalesceFile is the file form of a RewriteCoalescing.
+type CoalesceFile struct {
+	MaxDelay string `json:"max_delay"`
+	MinBytes int64  `json:"min_bytes,omitempty"`
+}
+
+type PolicyFile struct {
+	RetentionPeriod       string            `json:"retention_period"`
+	RetentionByResolution map[string]string `json:"retention_by_resolution,omitempty"`
+	Policy                string            `json:"policy"`
+	Downsample            []DownsampleFile  `json:"downsample,omitempty"`
+}
+
+// DownsampleFile is the file form of a DownsampleRule, such as
+// {"after": "40h", "resolution": "5m"}.
+type DownsampleFile struct {
+	After      string `json:"after"`
+	Resolution string `json:"resolution"`
+}
+
+// ParseConfig reads a JSON config file such as
+//
+//	{"base_retention": "390d", "policies": [{"retention_period": "180d", "policy": "service=h1"}]}
+//
+// Periods are given in seconds or with one of the s, m, h, d, w or y units.
+func ParseConfig(r io.Reader) (UserConfig, error) {
+	f := ConfigFile{}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f); err != nil {
+		return UserConfig{}, fmt.Errorf("decoding config: %w", err)
+	}
+	return f.ToUserConfig()
+}
+
+// ToUserConfig parses the periods of the config file.
+func (f ConfigFile) ToUserConfig() (UserConfig, error) {
+	base, err := ParseRetentionPeriod(f.BaseRetention)
+	if err != nil {
+		return UserConfig{}, fmt.Errorf("base_retention: %w", err)
+	}
+	config := UserConfig{BaseRetention: base, Policies: []PerSeriesRetentionPolicy{}, KeepHistoryLimit: f.KeepHistoryLimit}
+	if config.Downsample, err = parseDownsampleRules(f.Downsample); err != nil {
+		return UserConfig{}, fmt.Errorf("downsample%w", err)
+	}
+	if config.BaseRetentionByResolution, err = parseRetentionTiers(f.BaseRetentionByResolution); err != nil {
+		return UserConfig{}, fmt.Errorf("base_retention_by_resolution%w", err)
+	}
+	config.MaxBytes = f.MaxBytes
+	if f.MinRetention != "" {
+		if config.MinRetention, err = ParseRetentionPeriod(f.MinRetention); err != nil {
+			return UserConfig{}, fmt.Errorf("min_retention: %w", err)
+		}
+	}
+	config.SeriesBudget = f.SeriesBudget
+	if f.SeriesBudgetWindow != "" {
+		if config.SeriesBudgetWindow, err = ParseRetentionPeriod(f.SeriesBudgetWindow); err != nil {
+			return UserConfig{}, fmt.Errorf("series_budget_window: %w", err)
+		}
+	}
+	if f.Coalesce != nil {
+		if config.Coalescing.MaxDelay, err = ParseRetentionPeriod(f.Coalesce.MaxDelay); err != nil {
+			return UserConfig{}, fmt.Errorf("coalesce.max_delay: %w", err)
+		}
+		config.Coalescing.MinBytes = f.Coalesce.MinBytes
+	}
+	for i, p := range f.Policies {
+		period, err := ParseRetentionPeriod(p.RetentionPeriod)
+		if err != nil {
+			return UserConfig{}, fmt.Errorf("policies[%d].retention_period: %w", i, err)
+		}
+		tiers, err := parseRetentionTiers(p.RetentionByResolution)
+		if err != nil {
+			return UserConfig{}, fmt.Errorf("policies[%d].retention_by_resolution%w", i, err)
+		}
+		rules, err := parseDownsampleRules(p.Downsample)
+		if err != nil {
+			return UserConfig{}, fmt.Errorf("policies[%d].downsample%w", i, err)
+		}
+		config.Policies = append(config.Policies, PerSeriesRetentionPolicy{RetentionPeriod: period, RetentionByResolution: tiers, Policy: p.Policy, Downsample: rules})
+	}
+	return config, nil
+}
+
+// parseDownsampleRules parses the periods of downsampling rules. Errors are prefixed
+// with the index and field of the faulty rule.
+func parseDownsampleRules(files []DownsampleFile) ([]DownsampleRule, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+	rules := []DownsampleRule{}
+	for i, f := range files {
+		after, err := ParseRetentionPeriod(f.After)
+		if err != nil {
+			return nil, fmt.Errorf("[%d].after: %w", i, err)
+		}
+		resolution, err := ParseRetentionPeriod(f.Resolution)
+		if err != nil {
+			return nil, fmt.Errorf("[%d].resolution: %w", i, err)
+		}
+		rules = append(rules, DownsampleRule{After: after, Resolution: resolution})
+	}
+	return rules, nil
+}
+
+// parseRetentionTiers parses retention periods keyed by resolution. Errors are
+// prefixed with the faulty key.
+func parseRetentionTiers(files map[string]string) (map[int64]int64, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+	keys := make([]string, 0, len(files))
+	for k := range files {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	tiers := map[int64]int64{}
+	for _, k := range keys {
+		resolution, err := ParseResolution(k)
+		if err != nil {
+			return nil, fmt.Errorf("[%q]: %w", k, err)
+		}
+		retention, err := ParseRetentionPeriod(files[k])
+		if err != nil {
+			return nil, fmt.Errorf("[%q]: %w", k, err)
+		}
+		tiers[resolution] = retention
+	}
+	return tiers, nil
+}
+
+func newRetentionTierFiles(tiers map[int64]int64) map[string]string {
+	if len(tiers) == 0 {
+		return nil
+	}
+	files := map[string]string{}
+	for resolution, retention := range tiers {
+		files[FormatResolution(resolution)] = FormatRetentionPeriod(retention)
+	}
+	return files
+}
+
+// ParseResolution parses a resolution like "5m" into seconds, "raw" being 0.
+func ParseResolution(s string) (int64, error) {
+	if strings.TrimSpace(s) == "raw" {
+		return ResolutionRaw, nil
+	}
+	return ParseRetentionPeriod(s)
+}
+
+// FormatResolution is the inverse of ParseResolution.
+func FormatResolution(seconds int64) string {
+	if seconds == ResolutionRaw {
+		return "raw"
+	}
+	return FormatRetentionPeriod(seconds)
+}
+
+func newDownsampleFiles(rules []DownsampleRule) []DownsampleFile {
+	if len(rules) == 0 {
+		return nil
+	}
+	files := []DownsampleFile{}
+	for _, r := range rules {
+		files = append(files, DownsampleFile{After: FormatRetentionPeriod(r.After), Resolution: FormatRetentionPeriod(r.Resolution)})
+	}
+	return files
+}
+
+// NewConfigFile is the inverse of ConfigFile.ToUserConfig.
+func NewConfigFile(config UserConfig) ConfigFile {
+	f := ConfigFile{
+		BaseRetention:             FormatRetentionPeriod(config.BaseRetention),
+		BaseRetentionByResolution: newRetentionTierFiles(config.BaseRetentionByResolution),
+		Policies:                  []PolicyFile{},
+		KeepHistoryLimit:          config.KeepHistoryLimit,
+		Downsample:                newDownsampleFiles(config.Downsample),
+		MaxBytes:                  config.MaxBytes,
+		SeriesBudget:              config.SeriesBudget,
+	}
+	if config.MinRetention != 0 {
+		f.MinRetention = FormatRetentionPeriod(config.MinRetention)
+	}
+	if config.SeriesBudgetWindow != 0 {
+		f.SeriesBudgetWindow = FormatRetentionPeriod(config.SeriesBudgetWindow)
+	}
+	if config.Coalescing != (RewriteCoalescing{}) {
+		f.Coalesce = &CoalesceFile{MaxDelay: FormatRetentionPeriod(config.Coalescing.MaxDelay), MinBytes: config.Coalescing.MinBytes}
+	}
+	for _, p := range config.Policies {
+		f.Policies = append(f.Policies, PolicyFile{
+			RetentionPeriod:       FormatRetentionPeriod(p.RetentionPeriod),
+			RetentionByResolution: newRetentionTierFiles(p.RetentionByResolution),
+			Policy:                p.Policy,
+			Downsample:            newDownsampleFiles(p.Downsample),
+		})
+	}
+	return f
+}
+
+func LoadConfig(path string) (UserConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return UserConfig{}, err
+	}
+	defer f.Close()
+	return ParseConfig(f)
+}
+
+var periodUnits = map[string]int64{
+	"s": 1,
+	"m": 60,
+	"h": 60 * 60,
+	"d": 24 * 60 * 60,
+	"w": 7 * 24 * 60 * 60,
+	"y": 365 * 24 * 60 * 60,
+}
+
+// ParseRetentionPeriod parses a period like "180d" into seconds.
+func ParseRetentionPeriod(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, errors.New("empty retention period")
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	unit, ok := periodUnits[s[len(s)-1:]]
+	if !ok {
+		return 0, fmt.Errorf("invalid retention period %q", s)
+	}
+	n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid retention period %q", s)
+	}
+	return n * unit, nil
+}
+
+// FormatRetentionPeriod renders seconds with the largest unit dividing them exactly.
+func FormatRetentionPeriod(seconds int64) string {
+	if seconds == 0 {
+		return "0s"
+	}
+	for _, u := range []string{"y", "w", "d", "h", "m"} {
+		if seconds%periodUnits[u] == 0 {
+			return strconv.FormatInt(seconds/periodUnits[u], 10) + u
+		}
+	}
+	return strconv.FormatInt(seconds, 10) + "s"
+}
+
+// ValidateConfig returns every problem found in the config, or nil if it is usable.
+func ValidateConfig(config UserConfig) []error {
+	errs := []error{}
+	if config.BaseRetention <= 0 {
+		errs = append(errs, errors.New("base retention must be positive"))
+	}
+	for _, err := range validateRetentionTiers(config.BaseRetentionByResolution) {
+		errs = append(errs, fmt.Errorf("base %w", err))
+	}
+	if config.MaxBytes < 0 {
+		errs = append(errs, errors.New("max bytes must not be negative"))
+	}
+	if config.MinRetention < 0 {
+		errs = append(errs, errors.New("min retention must not be negative"))
+	}
+	if config.SeriesBudget < 0 {
+		errs = append(errs, errors.New("series budget must not be negative"))
+	} else if config.SeriesBudget > 0 {
+		if config.SeriesBudgetWindow <= 0 {
+			errs = append(errs, errors.New("series budget window must be positive"))
+		}
+		// without it, every block but the very last would lose series to the budget
+		if config.MinRetention <= 0 {
+			errs = append(errs, errors.New("series budget needs a min retention"))
+		}
+	}
+	if config.Coalescing.MaxDelay < 0 || config.Coalescing.MinBytes < 0 {
+		errs = append(errs, errors.New("coalescing max delay and min bytes must not be negative"))
+	} else if config.Coalescing.MinBytes > 0 && config.Coalescing.MaxDelay == 0 {
+		errs = append(errs, errors.New("coalescing min bytes needs a max delay"))
+	}
+	if config.KeepHistoryLimit < FullKeepHistory {
+		errs = append(errs, fmt.Errorf("keep history limit must be %d or more", FullKeepHistory))
+	}
+	_, maxRetention := getRetentionPeriodRange(config.Policies, config.BaseRetention)
+	for _, err := range validateDownsampleRules(config.Downsample, maxRetention) {
+		errs = append(errs, fmt.Errorf("downsample %w", err))
+	}
+	seen := map[string]bool{}
+	for i, p := range config.Policies {
+		for _, err := range validateDownsampleRules(p.Downsample, p.RetentionPeriod) {
+			errs = append(errs, fmt.Errorf("policy %d (%q): downsample %w", i, p.Policy, err))
+		}
+		if p.RetentionPeriod <= 0 {
+			errs = append(errs, fmt.Errorf("policy %d (%q): retention period must be positive", i, p.Policy))
+		}
+		for _, err := range validateRetentionTiers(p.RetentionByResolution) {
+			errs = append(errs, fmt.Errorf("policy %d (%q): %w", i, p.Policy, err))
+		}
+		if len(parseLabels(p.Policy)) == 0 {
+			errs = append(errs, fmt.Errorf("policy %d (%q): expected label pairs like name=value", i, p.Policy))
+		}
+		if seen[p.Policy] {
+			errs = append(errs, fmt.Errorf("policy %d (%q): duplicate policy", i, p.Policy))
+		}
+		seen[p.Policy] = true
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// validateDownsampleRules checks that every rule downsamples data before it expires,
+// each one later and coarser than the previous one.
+func validateDownsampleRules(rules []DownsampleRule, retention int64) []error {
+	errs := []error{}
+	for i, r := range rules {
+		if r.After <= 0 || r.Resolution <= 0 {
+			errs = append(errs, fmt.Errorf("rule %d: after and resolution must be positive", i))
+			continue
+		}
+		if r.After >= retention {
+			errs = append(errs, fmt.Errorf("rule %d: data expires before it is downsampled", i))
+		}
+		if i > 0 && (r.After <= rules[i-1].After || r.Resolution <= rules[i-1].Resolution) {
+			errs = append(errs, fmt.Errorf("rule %d: must come later and be coarser than rule %d", i, i-1))
+		}
+	}
+	return errs
+}
+
+// validateRetentionTiers checks the retention of every resolution, finest first.
+func validateRetentionTiers(tiers map[int64]int64) []error {
+	errs := []error{}
+	resolutions := make([]int64, 0, len(tiers))
+	for r := range tiers {
+		resolutions = append(resolutions, r)
+	}
+	sort.Slice(resolutions, func(i, j int) bool { return resolutions[i] < resolutions[j] })
+	for _, r := range resolutions {
+		if r < 0 {
+			errs = append(errs, fmt.Errorf("retention for resolution %ds: resolution must not be negative", r))
+		} else if tiers[r] <= 0 {
+			errs = append(errs, fmt.Errorf("retention for resolution %s must be positive", FormatResolution(r)))
+		}
+	}
+	return errs
+}
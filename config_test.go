@@ -0,0 +1,159 @@
+package toyRetention
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseConfig(t *testing.T) {
+	config, err := ParseConfig(strings.NewReader(`{
+		"base_retention": "390d",
+		"policies": [
+			{"retention_period": "180d", "policy": "service=h1"},
+			{"retention_period": "2y", "policy": "namespace=b1"},
+			{"retention_period": "3600", "policy": "name=ying"}
+		]
+	}`))
+	assert.NoError(t, err)
+	assert.Equal(t, UserConfig{
+		BaseRetention: 390 * secondsInADay,
+		Policies: []PerSeriesRetentionPolicy{
+			{RetentionPeriod: 180 * secondsInADay, Policy: "service=h1"},
+			{RetentionPeriod: 2 * 365 * secondsInADay, Policy: "namespace=b1"},
+			{RetentionPeriod: 3600, Policy: "name=ying"},
+		},
+	}, config)
+
+	_, err = ParseConfig(strings.NewReader(`{"base_retention": "13 months"}`))
+	assert.EqualError(t, err, `base_retention: invalid retention period "13 months"`)
+
+	_, err = ParseConfig(strings.NewReader(`{"base_retention": "1d", "retention": "1d"}`))
+	assert.Error(t, err)
+}
+
+func TestParseConfigDownsample(t *testing.T) {
+	config, err := ParseConfig(strings.NewReader(`{
+		"base_retention": "390d",
+		"downsample": [{"after": "30d", "resolution": "1h"}],
+		"policies": [
+			{"retention_period": "180d", "policy": "service=h1", "downsample": [{"after": "40h", "resolution": "5m"}]}
+		]
+	}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []DownsampleRule{{After: 30 * secondsInADay, Resolution: 3600}}, config.Downsample)
+	assert.Equal(t, []DownsampleRule{{After: 40 * 3600, Resolution: 300}}, config.Policies[0].Downsample)
+	assert.Equal(t, []DownsampleFile{{After: "40h", Resolution: "5m"}}, NewConfigFile(config).Policies[0].Downsample)
+
+	_, err = ParseConfig(strings.NewReader(`{"base_retention": "1d", "policies": [{"retention_period": "1d", "policy": "a=b", "downsample": [{"after": "1d", "resolution": "soon"}]}]}`))
+	assert.EqualError(t, err, `policies[0].downsample[0].resolution: invalid retention period "soon"`)
+
+	errs := ValidateConfig(UserConfig{
+		BaseRetention: 10 * secondsInADay,
+		Downsample:    []DownsampleRule{{After: 10 * secondsInADay, Resolution: 300}},
+		Policies: []PerSeriesRetentionPolicy{{
+			RetentionPeriod: 5 * secondsInADay,
+			Policy:          "service=h1",
+			Downsample:      []DownsampleRule{{After: secondsInADay, Resolution: 3600}, {After: 2 * secondsInADay, Resolution: 300}, {}},
+		}},
+	})
+	assert.Equal(t, []string{
+		"downsample rule 0: data expires before it is downsampled",
+		`policy 0 ("service=h1"): downsample rule 1: must come later and be coarser than rule 0`,
+		`policy 0 ("service=h1"): downsample rule 2: after and resolution must be positive`,
+	}, errorStrings(errs))
+}
+
+func TestParseConfigRetentionByResolution(t *testing.T) {
+	config, err := ParseConfig(strings.NewReader(`{
+		"base_retention": "30d",
+		"base_retention_by_resolution": {"raw": "30d", "5m": "90d", "1h": "1y"},
+		"policies": [
+			{"retention_period": "7d", "retention_by_resolution": {"1h": "30d"}, "policy": "service=h1"}
+		]
+	}`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[int64]int64{ResolutionRaw: 30 * secondsInADay, Resolution5m: 90 * secondsInADay, Resolution1h: 365 * secondsInADay}, config.BaseRetentionByResolution)
+	assert.Equal(t, map[int64]int64{Resolution1h: 30 * secondsInADay}, config.Policies[0].RetentionByResolution)
+	assert.Equal(t, map[string]string{"raw": "30d", "5m": "90d", "1h": "1y"}, NewConfigFile(config).BaseRetentionByResolution)
+
+	_, err = ParseConfig(strings.NewReader(`{"base_retention": "1d", "base_retention_by_resolution": {"fine": "1d"}}`))
+	assert.EqualError(t, err, `base_retention_by_resolution["fine"]: invalid retention period "fine"`)
+
+	errs := ValidateConfig(UserConfig{
+		BaseRetention:             secondsInADay,
+		BaseRetentionByResolution: map[int64]int64{Resolution1h: 0},
+		Policies:                  []PerSeriesRetentionPolicy{{RetentionPeriod: secondsInADay, RetentionByResolution: map[int64]int64{ResolutionRaw: -1}, Policy: "service=h1"}},
+	})
+	assert.Equal(t, []string{
+		"base retention for resolution 1h must be positive",
+		`policy 0 ("service=h1"): retention for resolution raw must be positive`,
+	}, errorStrings(errs))
+}
+
+func TestParseConfigQuota(t *testing.T) {
+	config, err := ParseConfig(strings.NewReader(`{"base_retention": "30d", "max_bytes": 1000000, "min_retention": "2d"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000000), config.MaxBytes)
+	assert.Equal(t, 2*secondsInADay, config.MinRetention)
+	assert.Equal(t, "2d", NewConfigFile(config).MinRetention)
+
+	assert.Equal(t, []string{"max bytes must not be negative"}, errorStrings(ValidateConfig(UserConfig{BaseRetention: secondsInADay, MaxBytes: -1})))
+}
+
+func TestParseConfigSeriesBudget(t *testing.T) {
+	config, err := ParseConfig(strings.NewReader(`{"base_retention": "30d", "series_budget": 100000, "series_budget_window": "1d", "min_retention": "2d"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100000), config.SeriesBudget)
+	assert.Equal(t, secondsInADay, config.SeriesBudgetWindow)
+	assert.Equal(t, "1d", NewConfigFile(config).SeriesBudgetWindow)
+
+	assert.Equal(t, []string{"series budget window must be positive"}, errorStrings(ValidateConfig(UserConfig{BaseRetention: secondsInADay, SeriesBudget: 10, MinRetention: secondsInADay})))
+	assert.Equal(t, []string{"series budget needs a min retention"}, errorStrings(ValidateConfig(UserConfig{BaseRetention: secondsInADay, SeriesBudget: 10, SeriesBudgetWindow: secondsInADay})))
+}
+
+func TestFormatRetentionPeriod(t *testing.T) {
+	for seconds, expected := range map[int64]string{
+		0:                   "0s",
+		90:                  "90s",
+		3600:                "1h",
+		180 * secondsInADay: "180d",
+		14 * secondsInADay:  "2w",
+		365 * secondsInADay: "1y",
+	} {
+		assert.Equal(t, expected, FormatRetentionPeriod(seconds))
+		parsed, err := ParseRetentionPeriod(expected)
+		assert.NoError(t, err)
+		assert.Equal(t, seconds, parsed)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	assert.Nil(t, ValidateConfig(UserConfig{
+		BaseRetention: 10 * secondsInADay,
+		Policies:      []PerSeriesRetentionPolicy{{RetentionPeriod: secondsInADay, Policy: "service=h1"}},
+	}))
+
+	errs := ValidateConfig(UserConfig{
+		Policies: []PerSeriesRetentionPolicy{
+			{RetentionPeriod: secondsInADay, Policy: "service=h1"},
+			{RetentionPeriod: 0, Policy: "service"},
+			{RetentionPeriod: secondsInADay, Policy: "service=h1"},
+		},
+	})
+	assert.Equal(t, []string{
+		"base retention must be positive",
+		`policy 1 ("service"): retention period must be positive`,
+		`policy 1 ("service"): expected label pairs like name=value`,
+		`policy 2 ("service=h1"): duplicate policy`,
+	}, errorStrings(errs))
+}
+
+func errorStrings(errs []error) []string {
+	s := []string{}
+	for _, err := range errs {
+		s = append(s, err.Error())
+	}
+	return s
+}
@@ -0,0 +1,125 @@
+package toyRetention
+
+// monthSeconds is the length of a month in cost projections.
+const monthSeconds = int64(30 * 24 * 60 * 60)
+
+// CostEstimate is what applying a config to a bucket costs and saves, from now and
+// then monthly over a horizon, assuming nothing else changes meanwhile.
+type CostEstimate struct {
+	Months int `json:"months"`
+	// ReclaimedNow is what a run now would reclaim.
+	ReclaimedNow int64 `json:"reclaimed_now"`
+	// ReclaimedOverHorizon includes ReclaimedNow.
+	ReclaimedOverHorizon int64 `json:"reclaimed_over_horizon"`
+	// RewriteBytesRead and RewriteBytesWritten are what the rewrites over the
+	// horizon read from and write back to the bucket.
+	RewriteBytesRead    int64       `json:"rewrite_bytes_read"`
+	RewriteBytesWritten int64       `json:"rewrite_bytes_written"`
+	Rewrites            int         `json:"rewrites"`
+	PerMonth            []MonthCost `json:"per_month"`
+}
+
+type MonthCost struct {
+	// Month 0 is now.
+	Month        int   `json:"month"`
+	At           int64 `json:"at"`
+	Reclaimed    int64 `json:"reclaimed"`
+	BytesRead    int64 `json:"bytes_read"`
+	BytesWritten int64 `json:"bytes_written"`
+}
+
+// EstimateCost runs the config against a copy of the bucket now, then at every time a
+// policy, a downsampling rule or a coalescing delay falls due and at the end of every
+// month of the horizon, summing per month the bytes the runs reclaim and rewrite.
+func EstimateCost(config UserConfig, userBucket *Bucket, currentTime int64, months int) CostEstimate {
+	estimate := CostEstimate{Months: months, PerMonth: []MonthCost{}}
+	projected := &Bucket{Tenant: userBucket.Tenant, Blocks: userBucket.snapshot(), Holds: userBucket.ActiveHolds(currentTime), Deletions: userBucket.ListDeletionRequests()}
+	last := currentTime
+	for m := 0; m <= months; m++ {
+		at := currentTime + int64(m)*monthSeconds
+		mc := MonthCost{Month: m, At: at}
+		for t := nextDeadline(config, projected, last); t > last && t < at; t = nextDeadline(config, projected, last) {
+			estimate.Rewrites += projectRun(config, projected, t, &mc)
+			last = t
+		}
+		estimate.Rewrites += projectRun(config, projected, at, &mc)
+		last = at
+
+		if m == 0 {
+			estimate.ReclaimedNow = mc.Reclaimed
+		}
+		estimate.ReclaimedOverHorizon += mc.Reclaimed
+		estimate.RewriteBytesRead += mc.BytesRead
+		estimate.RewriteBytesWritten += mc.BytesWritten
+		estimate.PerMonth = append(estimate.PerMonth, mc)
+	}
+	return estimate
+}
+
+// projectRun applies the config to the projected bucket at the given time, adds what
+// it reclaims and rewrites to the month and returns the number of rewrites.
+func projectRun(config UserConfig, projected *Bucket, at int64, mc *MonthCost) int {
+	rewrites := 0
+	plan := planBucket(config, projected, at)
+	for _, a := range plan.actions {
+		size := projected.Blocks[a.index].Stats.Bytes
+		if a.Kind == ActionDelete {
+			mc.Reclaimed += size
+			continue
+		}
+		mc.Reclaimed += a.EstimatedBytes
+		mc.BytesRead += size
+		mc.BytesWritten += size - a.EstimatedBytes
+		rewrites++
+	}
+	// nobody else writes to the projected bucket, there can be no conflicts
+	_ = ApplyPlan(config, projected, plan.actions, at)
+	_ = markPending(projected, plan, at)
+	return rewrites
+}
+
+// nextDeadline returns the first time after currentTime a retention period, a
+// downsampling rule or a coalescing delay falls due for a block of the bucket, or a
+// hold expires, currentTime if there is none.
+func nextDeadline(config UserConfig, userBucket *Bucket, currentTime int64) int64 {
+	periods := []int64{config.BaseRetention, config.MinRetention}
+	for _, r := range config.BaseRetentionByResolution {
+		periods = append(periods, r)
+	}
+	for _, r := range config.Downsample {
+		periods = append(periods, r.After)
+	}
+	for _, p := range config.Policies {
+		periods = append(periods, p.RetentionPeriod)
+		for _, r := range p.RetentionByResolution {
+			periods = append(periods, r)
+		}
+		for _, r := range p.Downsample {
+			periods = append(periods, r.After)
+		}
+	}
+
+	next := currentTime
+	earliest := func(t int64) {
+		if t > currentTime && (next == currentTime || t < next) {
+			next = t
+		}
+	}
+	for _, b := range userBucket.snapshot() {
+		if b.Deleted {
+			continue
+		}
+		for _, p := range periods {
+			earliest(b.MaxT + p)
+		}
+		if b.MetaData.PendingSince > 0 {
+			earliest(b.MetaData.PendingSince + config.Coalescing.MaxDelay)
+		}
+	}
+	for _, h := range userBucket.ListHolds() {
+		if h.ExpiresAt > 0 {
+			earliest(h.ExpiresAt)
+		}
+	}
+	return next
+}
@@ -0,0 +1,74 @@
+package toyRetention
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimateCost(t *testing.T) {
+	config := UserConfig{
+		BaseRetention: 60 * secondsInADay,
+		Policies: []PerSeriesRetentionPolicy{
+			{RetentionPeriod: 20 * secondsInADay, Policy: "service=h1"},
+			{RetentionPeriod: 90 * secondsInADay, Policy: "name=ying"},
+		},
+	}
+	series := map[string]interface{}{"service=h1": nil, "service=h2": nil, "service=h3": nil, "name=ying": nil}
+	bucket := &Bucket{
+		Blocks: []Block{
+			// loses service=h1 now, everything but name=ying next month, the rest the month after
+			{ID: 1, MaxT: theCurrentTime - 40*secondsInADay, Series: series, Stats: BlockStats{Bytes: 400, NumSeries: 4}},
+			// already past every policy
+			{ID: 2, MaxT: theCurrentTime - 100*secondsInADay, Stats: BlockStats{Bytes: 1000}},
+		},
+	}
+
+	estimate := EstimateCost(config, bucket, theCurrentTime, 3)
+	assert.Equal(t, []MonthCost{
+		{Month: 0, At: theCurrentTime, Reclaimed: 1100, BytesRead: 400, BytesWritten: 300},
+		{Month: 1, At: theCurrentTime + 30*secondsInADay, Reclaimed: 200, BytesRead: 300, BytesWritten: 100},
+		{Month: 2, At: theCurrentTime + 60*secondsInADay, Reclaimed: 100},
+		{Month: 3, At: theCurrentTime + 90*secondsInADay},
+	}, estimate.PerMonth)
+	assert.Equal(t, int64(1100), estimate.ReclaimedNow)
+	assert.Equal(t, int64(1400), estimate.ReclaimedOverHorizon)
+	assert.Equal(t, int64(700), estimate.RewriteBytesRead)
+	assert.Equal(t, int64(400), estimate.RewriteBytesWritten)
+	assert.Equal(t, 2, estimate.Rewrites)
+
+	// the bucket itself is left alone
+	assert.Equal(t, 0, bucket.Blocks[0].Retained)
+	assert.Equal(t, 4, len(bucket.Blocks[0].Series))
+}
+
+func TestEstimateCostSeveralDeadlinesInAMonth(t *testing.T) {
+	config := UserConfig{
+		BaseRetention: 300 * secondsInADay,
+		Policies: []PerSeriesRetentionPolicy{
+			{RetentionPeriod: 45 * secondsInADay, Policy: "service=h1"},
+			{RetentionPeriod: 50 * secondsInADay, Policy: "service=h2"},
+		},
+	}
+	bucket := &Bucket{Blocks: []Block{{
+		ID:     1,
+		MaxT:   theCurrentTime - 10*secondsInADay,
+		Series: map[string]interface{}{"service=h1": nil, "service=h2": nil, "service=h3": nil, "service=h4": nil},
+		Stats:  BlockStats{Bytes: 400, NumSeries: 4},
+	}}}
+
+	// both policies fall due during the second month, each costing a rewrite of its own
+	estimate := EstimateCost(config, bucket, theCurrentTime, 2)
+	assert.Equal(t, []MonthCost{
+		{Month: 0, At: theCurrentTime},
+		{Month: 1, At: theCurrentTime + 30*secondsInADay},
+		{Month: 2, At: theCurrentTime + 60*secondsInADay, Reclaimed: 200, BytesRead: 700, BytesWritten: 500},
+	}, estimate.PerMonth)
+	assert.Equal(t, 2, estimate.Rewrites)
+
+	// unless they are coalesced
+	config.Coalescing = RewriteCoalescing{MaxDelay: 10 * secondsInADay}
+	estimate = EstimateCost(config, bucket, theCurrentTime, 2)
+	assert.Equal(t, MonthCost{Month: 2, At: theCurrentTime + 60*secondsInADay, Reclaimed: 200, BytesRead: 400, BytesWritten: 200}, estimate.PerMonth[2])
+	assert.Equal(t, 1, estimate.Rewrites)
+}
@@ -0,0 +1,257 @@
+package toyRetention
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// DeletionRequest asks for the series matching Selector with samples between MinT
+// and MaxT to be removed. Retention carries it out with the same rewrites as drop
+// policies, removing the matching series from every block within the time range,
+// and records it in the metadata of each block so that no block is rewritten twice
+// for it. Blocks only partly in the time range keep the series but lose their
+// samples within it, see DeletedRange. A request without MaxT has no end, as in the
+// Prometheus delete_series API.
+type DeletionRequest struct {
+	ID          string `json:"id"`
+	Selector    string `json:"selector"`
+	MinT        int64  `json:"min_time"`
+	MaxT        int64  `json:"max_time"`
+	RequestedAt int64  `json:"requested_at"`
+}
+
+// Deletion request states.
+const (
+	DeletionPending    = "pending"
+	DeletionInProgress = "in-progress"
+	DeletionDone       = "done"
+)
+
+// DeletionStatus is how far retention got with a deletion request.
+type DeletionStatus struct {
+	DeletionRequest
+	Status string `json:"status"`
+	// BlocksDone and BlocksPending count the blocks rewritten for the request and
+	// those still to rewrite.
+	BlocksDone    int `json:"blocks_done"`
+	BlocksPending int `json:"blocks_pending"`
+}
+
+// DeletedRange records the samples a deletion request removed from a block lying only
+// partly in its time range: those of the series matching Selector between MinT and
+// MaxT.
+type DeletedRange struct {
+	Request  string `json:"request"`
+	Selector string `json:"selector"`
+	MinT     int64  `json:"min_time"`
+	MaxT     int64  `json:"max_time"`
+}
+
+// ErrDeletionExists is returned when requesting a deletion with the ID of an existing one.
+var ErrDeletionExists = errors.New("deletion request already exists")
+
+func (r DeletionRequest) validate() error {
+	if len(parseLabels(r.Selector)) == 0 {
+		return fmt.Errorf("selector %q: expected label pairs like name=value", r.Selector)
+	}
+	if r.MaxT != 0 && r.MaxT < r.MinT {
+		return errors.New("max time of a deletion request must not be before its min time")
+	}
+	return nil
+}
+
+func (r DeletionRequest) overlaps(b Block) bool {
+	return b.MinT <= r.MaxT && b.MaxT >= r.MinT
+}
+
+// covers tells whether the block lies within the time range of the request.
+func (r DeletionRequest) covers(b Block) bool {
+	return b.MinT >= r.MinT && b.MaxT <= r.MaxT
+}
+
+// matches tells whether the block may hold series matching the request, which blocks
+// with unknown series always may.
+func (r DeletionRequest) matches(b Block) bool {
+	if len(b.Series) == 0 {
+		return true
+	}
+	for s := range b.Series {
+		if matchesPolicy(s, r.Selector) {
+			return true
+		}
+	}
+	return false
+}
+
+// deletionRequestName stands for the deletion request where a policy name is expected.
+func deletionRequestName(id string) string {
+	return "deletion_request:" + id
+}
+
+// RequestDeletion stores a deletion request for the tenant, to be carried out by the
+// next retention runs. It is returned with its ID and request time set, an ID is
+// generated when it has none.
+func (bkt *Bucket) RequestDeletion(r DeletionRequest, currentTime int64) (DeletionRequest, error) {
+	if err := r.validate(); err != nil {
+		return DeletionRequest{}, err
+	}
+	if r.MaxT == 0 {
+		r.MaxT = math.MaxInt64
+	}
+	bkt.mu.Lock()
+	defer bkt.mu.Unlock()
+	exists := func(id string) bool {
+		for _, existing := range bkt.Deletions {
+			if existing.ID == id {
+				return true
+			}
+		}
+		return false
+	}
+	for n := len(bkt.Deletions) + 1; r.ID == ""; n++ {
+		if id := "deletion-" + strconv.Itoa(n); !exists(id) {
+			r.ID = id
+		}
+	}
+	if exists(r.ID) {
+		return DeletionRequest{}, fmt.Errorf("%q: %w", r.ID, ErrDeletionExists)
+	}
+	r.RequestedAt = currentTime
+	bkt.Deletions = append(bkt.Deletions, r)
+	return r, nil
+}
+
+// ListDeletionRequests returns every deletion request of the bucket by request time.
+func (bkt *Bucket) ListDeletionRequests() []DeletionRequest {
+	bkt.mu.Lock()
+	requests := append([]DeletionRequest{}, bkt.Deletions...)
+	bkt.mu.Unlock()
+	sort.SliceStable(requests, func(i, j int) bool {
+		return requests[i].RequestedAt < requests[j].RequestedAt
+	})
+	return requests
+}
+
+// deletionRequests returns the deletion requests made by currentTime.
+func (bkt *Bucket) deletionRequests(currentTime int64) []DeletionRequest {
+	requests := []DeletionRequest{}
+	for _, r := range bkt.ListDeletionRequests() {
+		if r.RequestedAt <= currentTime {
+			requests = append(requests, r)
+		}
+	}
+	return requests
+}
+
+// pendingDeletionRequests returns the requests the block still has to be rewritten
+// for: those overlapping it, not recorded in its metadata yet, and matching some of
+// its series. Blocks with unknown series are rewritten for every overlapping request.
+func pendingDeletionRequests(requests []DeletionRequest, b Block) []DeletionRequest {
+	pending := []DeletionRequest{}
+	for _, r := range requests {
+		if b.Deleted || !r.overlaps(b) || containsString(b.MetaData.DeletionRequests, r.ID) {
+			continue
+		}
+		if r.matches(b) {
+			pending = append(pending, r)
+		}
+	}
+	return pending
+}
+
+// DeletionStatuses returns the deletion requests of the bucket made by currentTime
+// with how far retention got with each of them.
+func DeletionStatuses(userBucket *Bucket, currentTime int64) []DeletionStatus {
+	blocks := userBucket.snapshot()
+	statuses := []DeletionStatus{}
+	for _, r := range userBucket.deletionRequests(currentTime) {
+		status := DeletionStatus{DeletionRequest: r}
+		for _, b := range blocks {
+			if containsString(b.MetaData.DeletionRequests, r.ID) {
+				status.BlocksDone++
+			} else if len(pendingDeletionRequests([]DeletionRequest{r}, b)) > 0 {
+				status.BlocksPending++
+			}
+		}
+		switch {
+		case status.BlocksPending == 0:
+			status.Status = DeletionDone
+		case status.BlocksDone == 0:
+			status.Status = DeletionPending
+		default:
+			status.Status = DeletionInProgress
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// seriesTrimmedBy returns the partial deletion of the rewrite removing samples of the
+// series, if any. The series the rewrite leaves for legal holds keep their samples.
+func seriesTrimmedBy(series string, a Action) (DeletionRequest, bool) {
+	if containsString(a.HeldSeries, series) {
+		return DeletionRequest{}, false
+	}
+	for _, id := range a.PartialDeletions {
+		if r, ok := a.deletions[id]; ok && matchesPolicy(series, r.Selector) {
+			return r, true
+		}
+	}
+	return DeletionRequest{}, false
+}
+
+// deletedRange is the part of the block the request removes samples from.
+func (r DeletionRequest) deletedRange(b Block) DeletedRange {
+	d := DeletedRange{Request: r.ID, Selector: r.Selector, MinT: b.MinT, MaxT: b.MaxT}
+	if r.MinT > d.MinT {
+		d.MinT = r.MinT
+	}
+	if r.MaxT < d.MaxT {
+		d.MaxT = r.MaxT
+	}
+	return d
+}
+
+// trimmedSamples estimates the bytes and samples the partial deletions of the rewrite
+// remove from the series it keeps, scaling the share of each series in the block by
+// the share of the time range of the block deleted.
+func trimmedSamples(b Block, a Action) (int64, int64) {
+	numSeries, span := seriesCount(b), b.MaxT-b.MinT
+	if numSeries == 0 || span <= 0 {
+		return 0, 0
+	}
+	bytes, samples := int64(0), int64(0)
+	for s := range b.Series {
+		if _, removed := seriesRemovedBy(s, b, a); removed {
+			continue
+		}
+		r, trimmed := seriesTrimmedBy(s, a)
+		if !trimmed {
+			continue
+		}
+		d := r.deletedRange(b)
+		bytes += b.Stats.Bytes * (d.MaxT - d.MinT) / (span * numSeries)
+		samples += b.Stats.NumSamples * (d.MaxT - d.MinT) / (span * numSeries)
+	}
+	return bytes, samples
+}
+
+// deleteSamples returns the bytes and samples the partial deletions of the rewrite
+// remove from the block, and the ranges they delete. Only the recorded requests are
+// carried out.
+func deleteSamples(b Block, a Action, recorded []string) (int64, int64, []DeletedRange) {
+	partial := a
+	partial.PartialDeletions = nil
+	ranges := []DeletedRange{}
+	for _, id := range a.PartialDeletions {
+		if containsString(recorded, id) {
+			partial.PartialDeletions = append(partial.PartialDeletions, id)
+			ranges = append(ranges, a.deletions[id].deletedRange(b))
+		}
+	}
+	bytes, samples := trimmedSamples(b, partial)
+	return bytes, samples, ranges
+}
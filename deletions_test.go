@@ -0,0 +1,143 @@
+package toyRetention
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeletionRequests(t *testing.T) {
+	config := UserConfig{
+		BaseRetention: 10 * secondsInADay,
+		Policies:      []PerSeriesRetentionPolicy{{RetentionPeriod: 5 * secondsInADay, Policy: "service=h1"}},
+	}
+	series := func() map[string]interface{} {
+		return map[string]interface{}{"service=h1": nil, "service=h2,pod=a": nil, "service=h2,pod=b": nil, "service=h3": nil}
+	}
+	bucket := &Bucket{Blocks: []Block{
+		{ID: 1, MinT: theCurrentTime - 7*secondsInADay, MaxT: theCurrentTime - 6*secondsInADay, Series: series(), Stats: BlockStats{Bytes: 400}},
+		{ID: 2, MinT: theCurrentTime - 3*secondsInADay, MaxT: theCurrentTime - 2*secondsInADay, Series: series(), Stats: BlockStats{Bytes: 400}},
+		{ID: 3, MinT: theCurrentTime - 2*secondsInADay, MaxT: theCurrentTime - secondsInADay, Series: map[string]interface{}{"service=h3": nil}},
+		{ID: 4, MinT: theCurrentTime - secondsInADay, MaxT: theCurrentTime, Series: series()},
+	}}
+
+	r, err := bucket.RequestDeletion(DeletionRequest{Selector: "service=h2", MinT: theCurrentTime - 8*secondsInADay, MaxT: theCurrentTime - 2*secondsInADay}, theCurrentTime)
+	assert.NoError(t, err)
+	assert.Equal(t, DeletionRequest{ID: "deletion-1", Selector: "service=h2", MinT: theCurrentTime - 8*secondsInADay, MaxT: theCurrentTime - 2*secondsInADay, RequestedAt: theCurrentTime}, r)
+	_, err = bucket.RequestDeletion(DeletionRequest{Selector: "oops"}, theCurrentTime)
+	assert.Error(t, err)
+
+	// block 3 has no matching series and block 4 is out of range
+	actions := PlanBucketRetention(config, bucket, theCurrentTime)
+	assert.Equal(t, []int{1, 2}, blockIDs(actions))
+	assert.Equal(t, []string{ReasonDropPoliciesChanged, ReasonDeletionRequested}, actions[0].Reasons())
+	assert.Equal(t, map[string]int64{"service=h1": 1, "deletion_request:deletion-1": 2}, actions[0].SeriesDropped)
+	assert.Equal(t, []string{ReasonDeletionRequested}, actions[1].Reasons())
+	assert.Equal(t, int64(200), actions[1].EstimatedBytes)
+	assert.Equal(t, theCurrentTime, actions[1].Deadline)
+	assert.Equal(t, DeletionPending, DeletionStatuses(bucket, theCurrentTime)[0].Status)
+
+	// with a budget of one rewrite the request is only partly carried out
+	_, err = ApplyBucketRetentionWithBudget(config, bucket, theCurrentTime, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []DeletionStatus{{DeletionRequest: r, Status: DeletionInProgress, BlocksDone: 1, BlocksPending: 1}}, DeletionStatuses(bucket, theCurrentTime))
+
+	assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime))
+	assert.Equal(t, []DeletionStatus{{DeletionRequest: r, Status: DeletionDone, BlocksDone: 2}}, DeletionStatuses(bucket, theCurrentTime))
+	for _, b := range bucket.Blocks[:2] {
+		assert.Equal(t, 1, b.Retained)
+		assert.Equal(t, []string{"deletion-1"}, b.MetaData.DeletionRequests)
+		assert.NotContains(t, b.Series, "service=h2,pod=a")
+		assert.Contains(t, b.Series, "service=h3")
+	}
+	assert.Equal(t, int64(200), bucket.Blocks[1].Stats.Bytes)
+	assert.Equal(t, series(), bucket.Blocks[3].Series)
+
+	// every block is rewritten at most once per request
+	assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime+secondsInADay))
+	assert.Equal(t, 1, bucket.Blocks[1].Retained)
+	assert.Equal(t, []Violation{}, AuditBucket(config, bucket, theCurrentTime+secondsInADay, 0))
+}
+
+func TestDeletionRequestsHonourHolds(t *testing.T) {
+	bucket := &Bucket{Blocks: []Block{{ID: 1, MinT: 0, MaxT: 10, Series: map[string]interface{}{"service=h1": nil}}}}
+	_, err := bucket.RequestDeletion(DeletionRequest{Selector: "service=h1", MaxT: 10}, theCurrentTime)
+	assert.NoError(t, err)
+	_, err = bucket.PlaceHold(LegalHold{Selector: "service=h1", MaxT: 5}, theCurrentTime)
+	assert.NoError(t, err)
+
+	config := UserConfig{BaseRetention: 100 * 365 * secondsInADay}
+	assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime))
+	assert.Contains(t, bucket.Blocks[0].Series, "service=h1")
+	assert.Equal(t, DeletionPending, DeletionStatuses(bucket, theCurrentTime)[0].Status)
+
+	assert.NoError(t, bucket.ReleaseHold("hold-1"))
+	assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime))
+	assert.NotContains(t, bucket.Blocks[0].Series, "service=h1")
+	assert.Equal(t, DeletionDone, DeletionStatuses(bucket, theCurrentTime)[0].Status)
+}
+
+func TestDeletionRequestsWithoutTimeRange(t *testing.T) {
+	config := UserConfig{BaseRetention: 10 * secondsInADay}
+	bucket := &Bucket{Blocks: []Block{
+		{ID: 1, MinT: theCurrentTime - 4*secondsInADay, MaxT: theCurrentTime - 2*secondsInADay, Series: map[string]interface{}{"service=h1": nil, "service=h2": nil}},
+		{ID: 2, MinT: theCurrentTime - 2*secondsInADay, MaxT: theCurrentTime, Series: map[string]interface{}{"service=h1": nil}},
+	}}
+
+	// a request without a time range covers every block
+	r, err := bucket.RequestDeletion(DeletionRequest{Selector: "service=h1"}, theCurrentTime)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(math.MaxInt64), r.MaxT)
+	assert.Equal(t, []DeletionStatus{{DeletionRequest: r, Status: DeletionPending, BlocksPending: 2}}, DeletionStatuses(bucket, theCurrentTime))
+	assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime))
+	assert.Equal(t, map[string]interface{}{"service=h2": nil}, bucket.Blocks[0].Series)
+	assert.Equal(t, map[string]interface{}{}, bucket.Blocks[1].Series)
+	assert.Equal(t, []DeletionStatus{{DeletionRequest: r, Status: DeletionDone, BlocksDone: 2}}, DeletionStatuses(bucket, theCurrentTime))
+
+	_, err = bucket.RequestDeletion(DeletionRequest{Selector: "service=h1", MinT: theCurrentTime, MaxT: 1}, theCurrentTime)
+	assert.EqualError(t, err, "max time of a deletion request must not be before its min time")
+}
+
+func TestDeletionRequestsTrimPartlyOverlappingBlocks(t *testing.T) {
+	config := UserConfig{BaseRetention: 10 * secondsInADay}
+	series := func() map[string]interface{} {
+		return map[string]interface{}{"service=h1": nil, "service=h2": nil}
+	}
+	bucket := &Bucket{Blocks: []Block{
+		{ID: 1, MinT: theCurrentTime - 4*secondsInADay, MaxT: theCurrentTime - 2*secondsInADay, Series: series(), Stats: BlockStats{Bytes: 400, NumSamples: 4000}},
+		{ID: 2, MinT: theCurrentTime - 2*secondsInADay, MaxT: theCurrentTime - secondsInADay, Series: series(), Stats: BlockStats{Bytes: 200}},
+	}}
+
+	// the last day of block 1 is in the range, removing service=h1 from it would
+	// remove another day of samples: only its samples within the range go
+	r, err := bucket.RequestDeletion(DeletionRequest{Selector: "service=h1", MinT: theCurrentTime - 3*secondsInADay, MaxT: theCurrentTime - secondsInADay}, theCurrentTime)
+	assert.NoError(t, err)
+	actions := PlanBucketRetention(config, bucket, theCurrentTime)
+	assert.Equal(t, []int{1, 2}, blockIDs(actions))
+	assert.Equal(t, []string{r.ID}, actions[0].PartialDeletions)
+	assert.Equal(t, int64(100), actions[0].EstimatedBytes)
+	assert.Empty(t, actions[1].PartialDeletions)
+
+	assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime))
+	b := bucket.Blocks[0]
+	assert.Equal(t, 1, b.Retained)
+	assert.Equal(t, series(), b.Series)
+	assert.Equal(t, BlockStats{Bytes: 300, NumSamples: 3000}, b.Stats)
+	assert.Equal(t, []DeletedRange{{Request: r.ID, Selector: "service=h1", MinT: theCurrentTime - 3*secondsInADay, MaxT: theCurrentTime - 2*secondsInADay}}, b.MetaData.DeletedRanges)
+	assert.Equal(t, map[string]interface{}{"service=h2": nil}, bucket.Blocks[1].Series)
+	assert.Equal(t, []DeletionStatus{{DeletionRequest: r, Status: DeletionDone, BlocksDone: 2}}, DeletionStatuses(bucket, theCurrentTime))
+	assert.Equal(t, []Violation{}, AuditBucket(config, bucket, theCurrentTime, 0))
+
+	// a held series keeps its samples until the hold is released
+	bucket.Blocks[0] = Block{ID: 1, MinT: theCurrentTime - 4*secondsInADay, MaxT: theCurrentTime - 2*secondsInADay, Series: series(), Stats: BlockStats{Bytes: 400}}
+	_, err = bucket.PlaceHold(LegalHold{ID: "case-1", Selector: "service=h1"}, theCurrentTime)
+	assert.NoError(t, err)
+	assert.Empty(t, PlanBucketRetention(config, bucket, theCurrentTime))
+	assert.Equal(t, []string{"case-1"}, PlanHeldActions(config, bucket, theCurrentTime)[0].HeldBy)
+	assert.Equal(t, DeletionInProgress, DeletionStatuses(bucket, theCurrentTime)[0].Status)
+	assert.NoError(t, bucket.ReleaseHold("case-1"))
+	assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime))
+	assert.Equal(t, int64(300), bucket.Blocks[0].Stats.Bytes)
+	assert.Equal(t, DeletionDone, DeletionStatuses(bucket, theCurrentTime)[0].Status)
+}
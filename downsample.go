@@ -0,0 +1,201 @@
+package toyRetention
+
+import "sort"
+
+// DownsampleAggregates are the aggregates a downsampled series keeps for every
+// window, as Thanos does: enough to answer sum, count, min, max, avg and rate.
+var DownsampleAggregates = []string{"count", "sum", "min", "max", "counter"}
+
+// DownsampleRule rewrites data at Resolution seconds per sample once its block is
+// After seconds past its max time. Rules of a policy or of the whole block are
+// ordered by age, each one coarser than the previous one.
+type DownsampleRule struct {
+	After      int64
+	Resolution int64
+}
+
+// dueResolution returns the coarsest resolution of the rules due at currentTime for a
+// block ending at maxT, and when it became due. It returns 0 if none is due.
+func dueResolution(rules []DownsampleRule, maxT int64, currentTime int64) (int64, int64) {
+	resolution, dueAt := int64(0), int64(0)
+	for _, r := range rules {
+		if isBlockRetentionPassed(maxT, currentTime, r.After) && r.Resolution > resolution {
+			resolution, dueAt = r.Resolution, maxT+r.After
+		}
+	}
+	return resolution, dueAt
+}
+
+// planDownsampling adds the downsampling due for the block to the action planned for
+// it, if any, or plans a rewrite for it alone.
+func planDownsampling(policies UserConfig, b Block, currentTime int64, a Action, ok bool) (Action, bool) {
+	deadline := currentTime
+	blockResolution := b.MetaData.Resolution
+	if r, dueAt := dueResolution(policies.Downsample, b.MaxT, currentTime); r > blockResolution {
+		blockResolution = r
+		if dueAt < deadline {
+			deadline = dueAt
+		}
+	} else {
+		blockResolution = 0
+	}
+
+	seriesResolutions := map[string]int64{}
+	for _, p := range policies.Policies {
+		// the series of expired policies are dropped rather than downsampled
+		if isBlockRetentionPassed(b.MaxT, currentTime, p.RetentionPeriod) {
+			continue
+		}
+		r, dueAt := dueResolution(p.Downsample, b.MaxT, currentTime)
+		if r <= blockResolution || r <= policyResolution(b.MetaData, p.Policy) {
+			continue
+		}
+		seriesResolutions[p.Policy] = r
+		if dueAt < deadline {
+			deadline = dueAt
+		}
+	}
+	if blockResolution == 0 && len(seriesResolutions) == 0 {
+		return a, ok
+	}
+
+	if !ok {
+		a = Action{BlockID: b.ID, Kind: ActionRewrite, Deadline: deadline}
+	} else if deadline < a.Deadline {
+		a.Deadline = deadline
+	}
+	a.Resolution = blockResolution
+	if len(seriesResolutions) > 0 {
+		a.Downsample = seriesResolutions
+	}
+	a.EstimatedBytes = estimateReclaimedBytes(b, a)
+	return a, true
+}
+
+// policyResolution is the resolution the series matching the policy were last
+// downsampled to.
+func policyResolution(md MetaData, policy string) int64 {
+	resolution := md.Resolution
+	for _, r := range md.DownsampleLog {
+		if r.Fingerprint == hashPolicy(policy) && r.Resolution > resolution {
+			resolution = r.Resolution
+		}
+	}
+	return resolution
+}
+
+// seriesResolution is the resolution the series is stored at.
+func seriesResolution(md MetaData, series string) int64 {
+	resolution := md.Resolution
+	for _, r := range md.DownsampleLog {
+		if r.Fingerprint != "" && r.Resolution > resolution && matchesPolicy(series, decodePolicy(r.Fingerprint)) {
+			resolution = r.Resolution
+		}
+	}
+	return resolution
+}
+
+// seriesDownsampledTo returns the resolution the rewrite downsamples the series to,
+// if it lowers the resolution of the series at all.
+func seriesDownsampledTo(series string, b Block, a Action) (int64, bool) {
+	resolution := a.Resolution
+	for p, r := range a.Downsample {
+		if r > resolution && matchesPolicy(series, p) {
+			resolution = r
+		}
+	}
+	if resolution <= seriesResolution(b.MetaData, series) {
+		return 0, false
+	}
+	return resolution, true
+}
+
+// downsampledSamples estimates how many samples a series of the block holds once
+// downsampled to the resolution: one per window and aggregate.
+func downsampledSamples(b Block, resolution int64) int64 {
+	return ((b.MaxT-b.MinT)/resolution + 1) * int64(len(DownsampleAggregates))
+}
+
+// seriesSamples estimates the samples of every known series of the block. Downsampled
+// series hold downsampledSamples, raw series share the rest evenly.
+func seriesSamples(b Block) map[string]int64 {
+	samples := map[string]int64{}
+	raw, rawSeries := b.Stats.NumSamples, seriesCount(b)
+	for s := range b.Series {
+		if r := seriesResolution(b.MetaData, s); r > 0 {
+			samples[s] = downsampledSamples(b, r)
+			raw -= samples[s]
+			rawSeries--
+		}
+	}
+	if raw < 0 {
+		raw = 0
+	}
+	for s := range b.Series {
+		if _, ok := samples[s]; !ok && rawSeries > 0 {
+			samples[s] = raw / rawSeries
+		}
+	}
+	return samples
+}
+
+// samplesSaved is how many of its samples a series saves when downsampled to the
+// resolution.
+func samplesSaved(b Block, samples int64, resolution int64) int64 {
+	if kept := downsampledSamples(b, resolution); kept < samples {
+		return samples - kept
+	}
+	return 0
+}
+
+// downsampleSavings estimates the bytes and samples the rewrite saves by downsampling
+// the series it keeps, bytes being spread evenly over samples. Blocks without known
+// series are only downsampled as a whole.
+func downsampleSavings(b Block, a Action) (int64, int64) {
+	if a.Kind == ActionDelete || b.Stats.NumSamples == 0 {
+		return 0, 0
+	}
+	samples := int64(0)
+	if len(b.Series) == 0 {
+		if numSeries := seriesCount(b); numSeries > 0 && a.Resolution > b.MetaData.Resolution {
+			samples = numSeries * samplesSaved(b, b.Stats.NumSamples/numSeries, a.Resolution)
+		}
+	} else {
+		current := seriesSamples(b)
+		for s := range b.Series {
+			if _, removed := seriesRemovedBy(s, b, a); removed {
+				continue
+			}
+			if r, ok := seriesDownsampledTo(s, b, a); ok {
+				samples += samplesSaved(b, current[s], r)
+			}
+		}
+	}
+	return b.Stats.Bytes * samples / b.Stats.NumSamples, samples
+}
+
+// downsampleSeries lowers the resolution of the series the rewrite downsamples,
+// shrinking the block stats accordingly, and records it in the block metadata.
+// The series removed by the rewrite must already be gone from the block.
+func downsampleSeries(b Block, a Action, currentTime int64) Block {
+	if a.Resolution == 0 && len(a.Downsample) == 0 {
+		return b
+	}
+	bytes, samples := downsampleSavings(b, a)
+	b.Stats.Bytes -= bytes
+	b.Stats.NumSamples -= samples
+
+	if a.Resolution > 0 {
+		b.MetaData.Resolution = a.Resolution
+		b.MetaData.DownsampleLog = append(b.MetaData.DownsampleLog, DownsampleRecord{Resolution: a.Resolution, AppliedAt: currentTime})
+	}
+	policies := make([]string, 0, len(a.Downsample))
+	for p := range a.Downsample {
+		policies = append(policies, p)
+	}
+	sort.Strings(policies)
+	for _, p := range policies {
+		b.MetaData.DownsampleLog = append(b.MetaData.DownsampleLog, DownsampleRecord{Fingerprint: hashPolicy(p), Resolution: a.Downsample[p], AppliedAt: currentTime})
+	}
+	return b
+}
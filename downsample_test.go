@@ -0,0 +1,89 @@
+package toyRetention
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownsampling(t *testing.T) {
+	config := UserConfig{
+		BaseRetention: 30 * secondsInADay,
+		Policies: []PerSeriesRetentionPolicy{{
+			RetentionPeriod: 60 * secondsInADay,
+			Policy:          "service=h1",
+			Downsample:      []DownsampleRule{{After: 2 * secondsInADay, Resolution: 300}, {After: 10 * secondsInADay, Resolution: 3600}},
+		}},
+		Downsample: []DownsampleRule{{After: 20 * secondsInADay, Resolution: 300}},
+	}
+	assert.Nil(t, ValidateConfig(config))
+	maxT := theCurrentTime - 3*secondsInADay
+	// a day of 15s samples, two bytes each
+	bucket := &Bucket{Blocks: []Block{{
+		ID:     1,
+		MinT:   maxT - secondsInADay,
+		MaxT:   maxT,
+		Series: map[string]interface{}{"service=h1,pod=a": nil, "service=h1,pod=b": nil, "service=h2": nil, "service=h3": nil},
+		Stats:  BlockStats{Bytes: 46080, NumSeries: 4, NumSamples: 23040},
+	}}}
+
+	// the service=h1 series keep 289 five minute windows of 5 aggregates
+	actions := PlanBucketRetention(config, bucket, theCurrentTime)
+	assert.Equal(t, []int{1}, blockIDs(actions))
+	assert.Equal(t, []string{ReasonDownsampleDue}, actions[0].Reasons())
+	assert.Equal(t, map[string]int64{"service=h1": 300}, actions[0].Downsample)
+	assert.Equal(t, int64(0), actions[0].Resolution)
+	assert.Equal(t, maxT+2*secondsInADay, actions[0].Deadline)
+	assert.Equal(t, int64(17260), actions[0].EstimatedBytes)
+
+	assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime))
+	b := bucket.Blocks[0]
+	assert.Equal(t, BlockStats{Bytes: 28820, NumSeries: 4, NumSamples: 14410}, b.Stats)
+	assert.Equal(t, []DownsampleRecord{{Fingerprint: hashPolicy("service=h1"), Resolution: 300, AppliedAt: theCurrentTime}}, b.MetaData.DownsampleLog)
+	assert.Equal(t, 4, len(b.Series))
+	assert.Empty(t, PlanBucketRetention(config, bucket, theCurrentTime+secondsInADay))
+
+	// the next tier only saves what the five minute aggregates still take
+	at := maxT + 10*secondsInADay
+	actions = PlanBucketRetention(config, bucket, at)
+	assert.Equal(t, map[string]int64{"service=h1": 3600}, actions[0].Downsample)
+	assert.Equal(t, int64(5280), actions[0].EstimatedBytes)
+	assert.NoError(t, ApplyBucketRetention(config, bucket, at))
+
+	// downsampling the whole block leaves the coarser service=h1 series alone
+	at = maxT + 20*secondsInADay
+	actions = PlanBucketRetention(config, bucket, at)
+	assert.Equal(t, int64(300), actions[0].Resolution)
+	assert.Empty(t, actions[0].Downsample)
+	assert.Equal(t, int64(17260), actions[0].EstimatedBytes)
+	assert.NoError(t, ApplyBucketRetention(config, bucket, at))
+	assert.Equal(t, int64(6280), bucket.Blocks[0].Stats.Bytes)
+	assert.Equal(t, 3, bucket.Blocks[0].Retained)
+
+	in := InspectBlock(bucket.Blocks[0], config, at)
+	assert.Equal(t, int64(300), in.Resolution)
+	assert.Equal(t, map[string]int64{"service=h1": 3600}, in.Downsampled)
+	assert.Equal(t, []Violation{}, AuditBucket(config, bucket, at, 0))
+}
+
+func TestDownsamplingHeld(t *testing.T) {
+	config := UserConfig{
+		BaseRetention: 30 * secondsInADay,
+		Policies: []PerSeriesRetentionPolicy{
+			{RetentionPeriod: 30 * secondsInADay, Policy: "service=h1", Downsample: []DownsampleRule{{After: secondsInADay, Resolution: 300}}},
+		},
+	}
+	bucket := &Bucket{Blocks: []Block{
+		{ID: 1, MaxT: theCurrentTime - 2*secondsInADay, Series: map[string]interface{}{"service=h1": nil, "service=h2": nil}},
+	}}
+	_, err := bucket.PlaceHold(LegalHold{Selector: "service=h2", MinT: theCurrentTime - 3*secondsInADay, MaxT: theCurrentTime}, theCurrentTime)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(PlanBucketRetention(config, bucket, theCurrentTime)))
+
+	// raw samples of held series are not aggregated away
+	_, err = bucket.PlaceHold(LegalHold{ID: "case-1", Selector: "service=h1", MinT: theCurrentTime - 3*secondsInADay, MaxT: theCurrentTime}, theCurrentTime)
+	assert.NoError(t, err)
+	assert.Empty(t, PlanBucketRetention(config, bucket, theCurrentTime))
+	held := PlanHeldActions(config, bucket, theCurrentTime)
+	assert.Equal(t, []string{"case-1"}, held[0].HeldBy)
+}
@@ -0,0 +1,106 @@
+package toyRetention
+
+import "sort"
+
+// States of a drop policy recorded in block metadata, relative to the config.
+const (
+	DropStateApplied = "applied"
+	// DropStateRemovedAfterDrop is a policy taken out of the config after retention
+	// already removed its series, if any, from the block. They cannot come back.
+	DropStateRemovedAfterDrop = "removed from config after data already dropped"
+	// DropStateRemovedUntracked is a policy taken out of the config that was applied
+	// without a record of what happened to its data, or only a legacy one.
+	DropStateRemovedUntracked = "removed from config, not known to be dropped"
+)
+
+// DropPolicyStatus is the state of a drop policy applied to a block.
+type DropPolicyStatus struct {
+	BlockID       int    `json:"block_id"`
+	Policy        string `json:"policy"`
+	AppliedAt     int64  `json:"applied_at"`
+	DataDropped   bool   `json:"data_dropped"`
+	SeriesDropped int64  `json:"series_dropped"`
+	State         string `json:"state"`
+}
+
+// logDropPolicies records the drop policies the rewrite applies for the first time,
+// given the block metadata before the rewrite. The policies older versions applied
+// without a record are backfilled as legacy ones.
+func logDropPolicies(md MetaData, a Action, currentTime int64) []DropPolicyRecord {
+	log := md.DropPolicyLog
+	for _, fingerprint := range md.DropPolicies {
+		if _, ok := dropPolicyRecord(log, fingerprint); !ok {
+			log = append(log, DropPolicyRecord{Fingerprint: fingerprint, Legacy: true})
+		}
+	}
+	for _, dp := range a.DropPolicies {
+		fingerprint := hashPolicy(dp)
+		if _, ok := dropPolicyRecord(log, fingerprint); ok {
+			continue
+		}
+		log = append(log, DropPolicyRecord{Fingerprint: fingerprint, AppliedAt: currentTime, DataDropped: a.SeriesDropped[dp] > 0, SeriesDropped: a.SeriesDropped[dp]})
+	}
+	return log
+}
+
+func dropPolicyRecord(log []DropPolicyRecord, fingerprint string) (DropPolicyRecord, bool) {
+	for _, r := range log {
+		if r.Fingerprint == fingerprint {
+			return r, true
+		}
+	}
+	return DropPolicyRecord{}, false
+}
+
+// dropPolicyStatuses returns the state of every drop policy applied to the block.
+func dropPolicyStatuses(b Block, config UserConfig) []DropPolicyStatus {
+	configured := map[string]bool{}
+	for _, p := range config.Policies {
+		configured[p.Policy] = true
+	}
+	statuses := []DropPolicyStatus{}
+	for _, dp := range b.MetaData.DropPolicies {
+		r, tracked := dropPolicyRecord(b.MetaData.DropPolicyLog, dp)
+		tracked = tracked && !r.Legacy
+		status := DropPolicyStatus{
+			BlockID:       b.ID,
+			Policy:        decodePolicy(dp),
+			AppliedAt:     r.AppliedAt,
+			DataDropped:   r.DataDropped,
+			SeriesDropped: r.SeriesDropped,
+		}
+		switch {
+		case configured[status.Policy]:
+			status.State = DropStateApplied
+		case tracked:
+			status.State = DropStateRemovedAfterDrop
+		default:
+			status.State = DropStateRemovedUntracked
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// RemovedDropPolicies returns the drop policies applied to blocks of the bucket that
+// are not configured anymore, by block and policy.
+func RemovedDropPolicies(config UserConfig, userBucket *Bucket) []DropPolicyStatus {
+	removed := []DropPolicyStatus{}
+	for _, b := range userBucket.snapshot() {
+		if b.Deleted {
+			continue
+		}
+		for _, status := range dropPolicyStatuses(b, config) {
+			if status.State != DropStateApplied {
+				removed = append(removed, status)
+			}
+		}
+	}
+	sort.SliceStable(removed, func(i, j int) bool {
+		if removed[i].BlockID != removed[j].BlockID {
+			return removed[i].BlockID < removed[j].BlockID
+		}
+		return removed[i].Policy < removed[j].Policy
+	})
+	return removed
+}
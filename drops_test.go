@@ -0,0 +1,84 @@
+package toyRetention
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDropPolicyLifecycle(t *testing.T) {
+	config := UserConfig{
+		BaseRetention: 10 * secondsInADay,
+		Policies: []PerSeriesRetentionPolicy{
+			{RetentionPeriod: 5 * secondsInADay, Policy: "service=h1"},
+			{RetentionPeriod: 6 * secondsInADay, Policy: "service=h2"},
+		},
+	}
+	bucket := &Bucket{Blocks: []Block{{
+		ID:     1,
+		MaxT:   theCurrentTime - 7*secondsInADay,
+		Series: map[string]interface{}{"service=h1,a=1": nil, "service=h1,a=2": nil, "service=h2": nil, "service=h3": nil},
+	}}}
+	assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime))
+	assert.Equal(t, []DropPolicyRecord{
+		{Fingerprint: hashPolicy("service=h1"), AppliedAt: theCurrentTime, DataDropped: true, SeriesDropped: 2},
+		{Fingerprint: hashPolicy("service=h2"), AppliedAt: theCurrentTime, DataDropped: true, SeriesDropped: 1},
+	}, bucket.Blocks[0].MetaData.DropPolicyLog)
+	assert.Equal(t, []DropPolicyStatus{}, RemovedDropPolicies(config, bucket))
+
+	// service=h1 is taken out of the config after its series are gone
+	config.Policies = config.Policies[1:]
+	assert.Equal(t, []DropPolicyStatus{
+		{BlockID: 1, Policy: "service=h1", AppliedAt: theCurrentTime, DataDropped: true, SeriesDropped: 2, State: DropStateRemovedAfterDrop},
+	}, RemovedDropPolicies(config, bucket))
+	for _, v := range AuditBucket(config, bucket, theCurrentTime, 0) {
+		assert.NotEqual(t, ViolationUnknownPolicy, v.Kind, v.Detail)
+	}
+
+	// applying again neither rewrites the block nor forgets the record
+	assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime+secondsInADay))
+	assert.Equal(t, 1, bucket.Blocks[0].Retained)
+	assert.Equal(t, 2, len(bucket.Blocks[0].MetaData.DropPolicyLog))
+}
+
+func TestRemovedDropPoliciesUntracked(t *testing.T) {
+	bucket := &Bucket{Blocks: []Block{
+		{ID: 2, MetaData: MetaData{DropPolicies: []string{hashPolicy("service=h1")}}},
+		{ID: 1, Deleted: true, MetaData: MetaData{DropPolicies: []string{hashPolicy("service=h0")}}},
+	}}
+	assert.Equal(t, []DropPolicyStatus{
+		{BlockID: 2, Policy: "service=h1", State: DropStateRemovedUntracked},
+	}, RemovedDropPolicies(UserConfig{BaseRetention: secondsInADay}, bucket))
+}
+
+func TestDropPolicyLogBackfillsLegacyPolicies(t *testing.T) {
+	config := UserConfig{
+		BaseRetention: 10 * secondsInADay,
+		Policies: []PerSeriesRetentionPolicy{
+			{RetentionPeriod: 5 * secondsInADay, Policy: "service=h1"},
+			{RetentionPeriod: 5 * secondsInADay, Policy: "service=h9"},
+		},
+	}
+	// service=h0 was applied by an older version, without a record
+	bucket := &Bucket{Blocks: []Block{{
+		ID:       1,
+		MaxT:     theCurrentTime - 7*secondsInADay,
+		Series:   map[string]interface{}{"service=h1": nil, "service=h2": nil},
+		Retained: 1,
+		MetaData: MetaData{DropPolicies: []string{hashPolicy("service=h0")}},
+	}}}
+	assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime))
+	assert.Equal(t, []DropPolicyRecord{
+		{Fingerprint: hashPolicy("service=h0"), Legacy: true},
+		{Fingerprint: hashPolicy("service=h1"), AppliedAt: theCurrentTime, DataDropped: true, SeriesDropped: 1},
+		{Fingerprint: hashPolicy("service=h9"), AppliedAt: theCurrentTime},
+	}, bucket.Blocks[0].MetaData.DropPolicyLog)
+
+	// a legacy policy is still not known to have dropped its data, a policy that
+	// matched nothing can go
+	config.Policies = config.Policies[:1]
+	assert.Equal(t, []DropPolicyStatus{
+		{BlockID: 1, Policy: "service=h0", State: DropStateRemovedUntracked},
+		{BlockID: 1, Policy: "service=h9", AppliedAt: theCurrentTime, State: DropStateRemovedAfterDrop},
+	}, RemovedDropPolicies(config, bucket))
+}
@@ -0,0 +1,240 @@
+package toyRetention
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// defaultLockTTL is how long a retention run may go without a heartbeat before
+// another replica is allowed to take over its bucket.
+const defaultLockTTL = int64(5 * 60)
+
+// Engine runs retention for a compactor replica. Unlike ApplyBucketRetention it
+// holds the bucket lock for the duration of the run, so that two replicas never
+// rewrite the same tenant at once.
+type Engine struct {
+	// Owner identifies this replica in the bucket lock.
+	Owner string
+	// LockTTL is the lease duration in seconds, renewed after every block.
+	LockTTL int64
+	// RewriteBudget caps the rewrites done in one run, 0 means unlimited.
+	RewriteBudget int
+	// Metrics is optional, nothing is reported when nil.
+	Metrics *RetentionMetrics
+	// Logger receives one record per block decision, nothing is logged when nil.
+	Logger Logger
+	// Hooks are called before every block change and after the run, when set.
+	Hooks Hooks
+
+	// clock measures how long the run has been going to renew the lease, time.Now when
+	// nil.
+	clock func() time.Time
+}
+
+// RunResult is what a retention run did to a bucket.
+type RunResult struct {
+	Applied  []Action `json:"applied"`
+	Deferred []Action `json:"deferred"`
+	// Vetoed are the actions refused by a hook.
+	Vetoed []Action `json:"vetoed"`
+	// Held are the actions withheld because of legal holds.
+	Held []HeldAction `json:"held"`
+	// Pending are the rewrites held back to coalesce them with later changes.
+	Pending []PendingRewrite `json:"pending"`
+}
+
+func NewEngine(owner string) *Engine {
+	return &Engine{Owner: owner, LockTTL: defaultLockTTL}
+}
+
+// Run applies retention to the bucket under its lock. It fails with a *LockHeldError
+// when another owner is running on the bucket.
+func (e *Engine) Run(config UserConfig, userBucket *Bucket, currentTime int64) (RunResult, error) {
+	start := time.Now()
+	result, err := e.run(config, userBucket, currentTime, false)
+	if e.Metrics != nil {
+		e.Metrics.RunDuration.Set(time.Since(start).Seconds(), userBucket.Tenant)
+		if err == nil {
+			e.Metrics.LastSuccessfulRun.Set(float64(currentTime), userBucket.Tenant)
+		}
+	}
+	return result, err
+}
+
+// ErrDeletionsWithheld is returned when cleaning tombstones could not carry out every
+// pending deletion request, because of a legal hold or a hook.
+var ErrDeletionsWithheld = errors.New("deletion requests withheld")
+
+// CleanTombstones carries out the pending deletion requests of the bucket under its
+// lock, whatever the rewrite budget, and leaves every other change for the next run.
+// It fails with ErrDeletionsWithheld when a request could not be carried out.
+func (e *Engine) CleanTombstones(config UserConfig, userBucket *Bucket, currentTime int64) (RunResult, error) {
+	result, err := e.run(config, userBucket, currentTime, true)
+	if err != nil {
+		return result, err
+	}
+	withheld := []int{}
+	for _, h := range result.Held {
+		if len(h.DeletionRequests) > 0 {
+			withheld = append(withheld, h.BlockID)
+		}
+	}
+	for _, a := range result.Vetoed {
+		if len(a.DeletionRequests) > 0 {
+			withheld = append(withheld, a.BlockID)
+		}
+	}
+	if len(withheld) > 0 {
+		return result, fmt.Errorf("blocks %v: %w", withheld, ErrDeletionsWithheld)
+	}
+	return result, nil
+}
+
+// run applies the plan of the bucket, or only its deletion requests when
+// deletionsOnly is set.
+func (e *Engine) run(config UserConfig, userBucket *Bucket, currentTime int64, deletionsOnly bool) (RunResult, error) {
+	result := RunResult{Applied: []Action{}, Vetoed: []Action{}, Held: []HeldAction{}, Pending: []PendingRewrite{}}
+	// the lease is taken at currentTime and renewed as time goes by from there, so that
+	// a run outlasting the TTL keeps it
+	start := e.now()
+	leaseTime := func() int64 {
+		return currentTime + int64(e.now().Sub(start)/time.Second)
+	}
+	lease, err := AcquireBucketLock(userBucket, e.Owner, e.LockTTL, currentTime)
+	if err != nil {
+		return result, err
+	}
+	defer lease.Release()
+
+	e.recordEvaluated(userBucket)
+	plan := planBucket(config, userBucket, currentTime)
+	budget := e.RewriteBudget
+	if deletionsOnly {
+		plan, budget = plan.deletions(), 0
+	} else {
+		e.logUnchanged(config, userBucket, plan, currentTime)
+	}
+	actions, held := plan.actions, plan.held
+	scheduled, deferred := ScheduleActions(actions, budget)
+	result.Deferred = deferred
+	result.Held = held
+	result.Pending = plan.pending
+	for _, h := range held {
+		e.logger().Info(decisionMessage, append(decisionArgs(userBucket.Tenant, config, userBucket.ReadBlock(h.index), decisionHeld, h.Action, currentTime), "held_by", h.HeldBy)...)
+	}
+	for _, p := range plan.pending {
+		e.logger().Info(decisionMessage, append(decisionArgs(userBucket.Tenant, config, userBucket.ReadBlock(p.index), decisionPending, p.Action, currentTime), "flush_at", p.FlushAt)...)
+	}
+	for _, a := range deferred {
+		e.logger().Info(decisionMessage, decisionArgs(userBucket.Tenant, config, userBucket.ReadBlock(a.index), decisionDefer, a, currentTime)...)
+	}
+	for _, planned := range scheduled {
+		if err := lease.Renew(leaseTime()); err != nil {
+			return result, err
+		}
+		// the decision is logged against the block as it was planned, before downsampling
+		b := userBucket.ReadBlock(planned.index)
+		a, applied, err := applyBlockAction(config, userBucket, planned, currentTime, e.approver(userBucket.Tenant, config, currentTime))
+		var veto *VetoError
+		if errors.As(err, &veto) {
+			e.logger().Info(decisionMessage, append(decisionArgs(userBucket.Tenant, config, b, decisionVetoed, a, currentTime), "err", veto.Err)...)
+			result.Vetoed = append(result.Vetoed, a)
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		if !applied {
+			e.logger().Debug(decisionMessage, decisionArgs(userBucket.Tenant, config, b, decisionNone, a, currentTime)...)
+			continue
+		}
+		e.logger().Info(decisionMessage, decisionArgs(userBucket.Tenant, config, b, a.Kind.String(), a, currentTime)...)
+		e.recordApplied(userBucket.Tenant, a)
+		result.Applied = append(result.Applied, a)
+	}
+
+	// the plan of a deletions only run says nothing about the pending rewrites
+	if !deletionsOnly {
+		if err := markPending(userBucket, plan, currentTime); err != nil {
+			return result, err
+		}
+	}
+
+	if e.Hooks != nil {
+		event := PoliciesAppliedEvent{Tenant: userBucket.Tenant, Config: config, CurrentTime: currentTime, Result: result}
+		if err := e.Hooks.OnPoliciesApplied(event); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func (e *Engine) approver(tenant string, config UserConfig, currentTime int64) func(Block, Action) error {
+	if e.Hooks == nil {
+		return nil
+	}
+	return func(b Block, a Action) error {
+		return callBlockHook(e.Hooks, BlockEvent{Tenant: tenant, Config: config, CurrentTime: currentTime, Block: b, Action: a})
+	}
+}
+
+func (e *Engine) now() time.Time {
+	if e.clock == nil {
+		return time.Now()
+	}
+	return e.clock()
+}
+
+func (e *Engine) logger() Logger {
+	if e.Logger == nil {
+		return nopLogger{}
+	}
+	return e.Logger
+}
+
+// logUnchanged reports the blocks retention had nothing to do for.
+func (e *Engine) logUnchanged(config UserConfig, userBucket *Bucket, plan bucketPlan, currentTime int64) {
+	hasAction := map[int]bool{}
+	for _, a := range plan.actions {
+		hasAction[a.index] = true
+	}
+	for _, h := range plan.held {
+		hasAction[h.index] = true
+	}
+	for _, p := range plan.pending {
+		hasAction[p.index] = true
+	}
+	for i, b := range userBucket.snapshot() {
+		if !b.Deleted && !hasAction[i] {
+			e.logger().Debug(decisionMessage, decisionArgs(userBucket.Tenant, config, b, decisionNone, Action{}, currentTime)...)
+		}
+	}
+}
+
+func (e *Engine) recordEvaluated(userBucket *Bucket) {
+	if e.Metrics == nil {
+		return
+	}
+	for _, b := range userBucket.snapshot() {
+		if !b.Deleted {
+			e.Metrics.BlocksEvaluated.Inc(userBucket.Tenant)
+		}
+	}
+}
+
+func (e *Engine) recordApplied(tenant string, a Action) {
+	if e.Metrics == nil {
+		return
+	}
+	if a.Kind == ActionDelete {
+		e.Metrics.BlocksDeleted.Inc(tenant)
+		return
+	}
+	for _, reason := range a.Reasons() {
+		e.Metrics.BlocksRewritten.Inc(tenant, reason)
+	}
+	for policy, n := range a.SeriesDropped {
+		e.Metrics.SeriesDropped.Add(float64(n), tenant, policy)
+	}
+}
@@ -0,0 +1,77 @@
+package toyRetention
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEngineRun(t *testing.T) {
+	config := UserConfig{
+		BaseRetention: 10 * secondsInADay,
+		Policies:      []PerSeriesRetentionPolicy{{RetentionPeriod: 5 * secondsInADay, Policy: "service=h1"}},
+	}
+
+	t.Run("another owner holding the lock stops the run", func(t *testing.T) {
+		bucket := &Bucket{Blocks: []Block{{MaxT: theCurrentTime - 6*secondsInADay}}}
+		_, err := AcquireBucketLock(bucket, "compactor-2", defaultLockTTL, theCurrentTime)
+		assert.NoError(t, err)
+
+		_, err = NewEngine("compactor-1").Run(config, bucket, theCurrentTime)
+		assert.IsType(t, &LockHeldError{}, err)
+		assert.Equal(t, 0, bucket.Blocks[0].Retained)
+
+		// once the lock went stale the run takes it over
+		_, err = NewEngine("compactor-1").Run(config, bucket, theCurrentTime+defaultLockTTL)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, bucket.Blocks[0].Retained)
+		assert.Nil(t, bucket.Lock)
+	})
+
+	t.Run("a long run renews its lease as time goes by", func(t *testing.T) {
+		bucket := &Bucket{Blocks: []Block{
+			{ID: 1, MaxT: theCurrentTime - 6*secondsInADay},
+			{ID: 2, MaxT: theCurrentTime - 6*secondsInADay},
+		}}
+		// every block takes ten minutes, twice the lock TTL
+		clock := time.Unix(theCurrentTime, 0)
+		engine := NewEngine("compactor-1")
+		engine.clock = func() time.Time {
+			clock = clock.Add(10 * time.Minute)
+			return clock
+		}
+		hooks := &leaseWatchingHooks{bucket: bucket}
+		engine.Hooks = hooks
+		_, err := engine.Run(config, bucket, theCurrentTime)
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{theCurrentTime + 600, theCurrentTime + 1200}, hooks.renewedAt)
+	})
+
+	t.Run("concurrent replicas rewrite a block only once", func(t *testing.T) {
+		bucket := &Bucket{Blocks: []Block{{MaxT: theCurrentTime - 6*secondsInADay}}}
+		wg := sync.WaitGroup{}
+		for _, owner := range []string{"compactor-1", "compactor-2", "compactor-3"} {
+			wg.Add(1)
+			go func(owner string) {
+				defer wg.Done()
+				_, _ = NewEngine(owner).Run(config, bucket, theCurrentTime)
+			}(owner)
+		}
+		wg.Wait()
+		assert.Equal(t, 1, bucket.Blocks[0].Retained)
+	})
+}
+
+// leaseWatchingHooks records when the lease was last renewed before every rewrite.
+type leaseWatchingHooks struct {
+	NopHooks
+	bucket    *Bucket
+	renewedAt []int64
+}
+
+func (h *leaseWatchingHooks) OnBlockRewritten(BlockEvent) error {
+	h.renewedAt = append(h.renewedAt, h.bucket.Lock.RenewedAt)
+	return nil
+}
@@ -0,0 +1,98 @@
+package toyRetention
+
+import (
+	"fmt"
+	"time"
+)
+
+// RetentionExtension is a policy a config change retains longer. Retention cannot
+// bring back the series it already dropped, so the longer retention only applies to
+// data that is still there.
+type RetentionExtension struct {
+	Policy       string `json:"policy"`
+	OldRetention int64  `json:"old_retention"`
+	NewRetention int64  `json:"new_retention"`
+	// DataGone is set when some data the new retention covers was already dropped,
+	// between GoneFrom and GoneUntil in sample time.
+	DataGone  bool  `json:"data_gone"`
+	GoneFrom  int64 `json:"gone_from,omitempty"`
+	GoneUntil int64 `json:"gone_until,omitempty"`
+	// EffectiveFrom is the sample time from which data is kept for the new retention.
+	EffectiveFrom int64 `json:"effective_from"`
+}
+
+func (e RetentionExtension) String() string {
+	s := fmt.Sprintf("%s: retention extended from %s to %s", e.Policy, FormatRetentionPeriod(e.OldRetention), FormatRetentionPeriod(e.NewRetention))
+	if !e.DataGone {
+		return s
+	}
+	return fmt.Sprintf("%s, data from %s to %s is already gone, extension only effective from %s",
+		s, formatUnix(e.GoneFrom), formatUnix(e.GoneUntil), formatUnix(e.EffectiveFrom))
+}
+
+// FindRetentionExtensions compares two configs and returns the policies, "default"
+// for the base retention, the new config retains longer. With a bucket the data
+// already gone is taken from its blocks, without one it is assumed that retention
+// kept up with the old config until currentTime.
+func FindRetentionExtensions(oldConfig UserConfig, newConfig UserConfig, userBucket *Bucket, currentTime int64) []RetentionExtension {
+	extensions := []RetentionExtension{}
+	policies := append([]PerSeriesRetentionPolicy{{RetentionPeriod: newConfig.BaseRetention, Policy: defaultPolicyName}}, newConfig.Policies...)
+	for _, p := range policies {
+		old := policyRetention(oldConfig, p.Policy)
+		if p.RetentionPeriod <= old {
+			continue
+		}
+		e := RetentionExtension{Policy: p.Policy, OldRetention: old, NewRetention: p.RetentionPeriod, EffectiveFrom: currentTime - p.RetentionPeriod}
+		if userBucket == nil {
+			e.DataGone, e.GoneFrom, e.GoneUntil = true, currentTime-p.RetentionPeriod, currentTime-old
+		} else {
+			for _, b := range userBucket.snapshot() {
+				if isBlockRetentionPassed(b.MaxT, currentTime, p.RetentionPeriod) || !policyDataGone(b, p.Policy) {
+					continue
+				}
+				if !e.DataGone || b.MinT < e.GoneFrom {
+					e.GoneFrom = b.MinT
+				}
+				if !e.DataGone || b.MaxT > e.GoneUntil {
+					e.GoneUntil = b.MaxT
+				}
+				e.DataGone = true
+			}
+		}
+		if e.DataGone {
+			e.EffectiveFrom = e.GoneUntil
+		}
+		extensions = append(extensions, e)
+	}
+	return extensions
+}
+
+// policyRetention is how long the config retains the series of a policy. Series of
+// a policy the config does not have fall back to the base retention.
+func policyRetention(config UserConfig, policy string) int64 {
+	for _, p := range config.Policies {
+		if p.Policy == policy {
+			return p.RetentionPeriod
+		}
+	}
+	return config.BaseRetention
+}
+
+// policyDataGone tells whether retention already removed the series of the policy
+// from the block, "default" standing for the series only kept by base retention.
+func policyDataGone(b Block, policy string) bool {
+	if b.Deleted {
+		return true
+	}
+	if policy != defaultPolicyName && containsString(b.MetaData.DropPolicies, hashPolicy(policy)) {
+		return true
+	}
+	if keepPolicyRewrites(b.MetaData) == 0 {
+		return false
+	}
+	return policy == defaultPolicyName || !containsString(splitKeepSet(decodePolicy(currentKeepPolicy(b.MetaData))), policy)
+}
+
+func formatUnix(unix int64) string {
+	return time.Unix(unix, 0).UTC().Format(time.RFC3339)
+}
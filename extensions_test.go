@@ -0,0 +1,74 @@
+package toyRetention
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindRetentionExtensionsWithoutBucket(t *testing.T) {
+	oldConfig := UserConfig{
+		BaseRetention: 13 * 30 * secondsInADay,
+		Policies: []PerSeriesRetentionPolicy{
+			{RetentionPeriod: 6 * 30 * secondsInADay, Policy: "service=h1"},
+			{RetentionPeriod: 3 * 12 * 30 * secondsInADay, Policy: "name=ying"},
+		},
+	}
+	newConfig := UserConfig{
+		BaseRetention: 13 * 30 * secondsInADay,
+		Policies: []PerSeriesRetentionPolicy{
+			{RetentionPeriod: 2 * 12 * 30 * secondsInADay, Policy: "service=h1"},
+			{RetentionPeriod: 2 * 12 * 30 * secondsInADay, Policy: "name=ying"},
+		},
+	}
+	assert.Equal(t, []RetentionExtension{{
+		Policy:        "service=h1",
+		OldRetention:  6 * 30 * secondsInADay,
+		NewRetention:  2 * 12 * 30 * secondsInADay,
+		DataGone:      true,
+		GoneFrom:      theCurrentTime - 2*12*30*secondsInADay,
+		GoneUntil:     theCurrentTime - 6*30*secondsInADay,
+		EffectiveFrom: theCurrentTime - 6*30*secondsInADay,
+	}}, FindRetentionExtensions(oldConfig, newConfig, nil, theCurrentTime))
+}
+
+func TestFindRetentionExtensionsFromBlocks(t *testing.T) {
+	oldConfig := UserConfig{
+		BaseRetention: 10 * secondsInADay,
+		Policies: []PerSeriesRetentionPolicy{
+			{RetentionPeriod: 5 * secondsInADay, Policy: "service=h1"},
+			{RetentionPeriod: 20 * secondsInADay, Policy: "name=ying"},
+		},
+	}
+	series := map[string]interface{}{"service=h1": nil, "service=h2": nil, "name=ying": nil}
+	bucket := &Bucket{}
+	for i := int64(0); i < 4; i++ {
+		bucket.Blocks = append(bucket.Blocks, Block{ID: int(i), MinT: blockCreationTime + i*secondsInADay, MaxT: blockCreationTime + (i+1)*secondsInADay, Series: series})
+	}
+	now := blockCreationTime + 13*secondsInADay
+	assert.NoError(t, ApplyBucketRetention(oldConfig, bucket, now))
+
+	newConfig := UserConfig{
+		BaseRetention: 30 * secondsInADay,
+		Policies: []PerSeriesRetentionPolicy{
+			{RetentionPeriod: 5 * secondsInADay, Policy: "service=h1"},
+			{RetentionPeriod: 20 * secondsInADay, Policy: "name=ying"},
+		},
+	}
+	e := FindRetentionExtensions(oldConfig, newConfig, bucket, now)
+	assert.Equal(t, []RetentionExtension{{
+		Policy:        defaultPolicyName,
+		OldRetention:  10 * secondsInADay,
+		NewRetention:  30 * secondsInADay,
+		DataGone:      true,
+		GoneFrom:      blockCreationTime,
+		GoneUntil:     blockCreationTime + 3*secondsInADay,
+		EffectiveFrom: blockCreationTime + 3*secondsInADay,
+	}}, e)
+	assert.Contains(t, e[0].String(), "default: retention extended from 10d to 30d, data from ")
+
+	// before retention dropped anything the extension is effective right away
+	e = FindRetentionExtensions(oldConfig, newConfig, &Bucket{Blocks: []Block{{MaxT: blockCreationTime, Series: series}}}, now)
+	assert.False(t, e[0].DataGone)
+	assert.Equal(t, "default: retention extended from 10d to 30d", e[0].String())
+}
@@ -0,0 +1,335 @@
+package toyRetention
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// blockMetaFile is the name of the file holding a block's metadata in a filesystem
+// bucket laid out as <dir>/<block id>/meta.json.
+const blockMetaFile = "meta.json"
+
+// holdsFile and deletionsFile hold the legal holds and deletion requests of the
+// tenant, next to its block directories.
+const (
+	holdsFile     = "holds.json"
+	deletionsFile = "deletions.json"
+)
+
+// lockFile holds the BucketLock of the process owning a filesystem bucket, see
+// AcquireDirLock.
+const lockFile = "lock.json"
+
+type blockFile struct {
+	ID       int        `json:"id"`
+	MinT     int64      `json:"min_time"`
+	MaxT     int64      `json:"max_time"`
+	Series   []string   `json:"series,omitempty"`
+	Stats    BlockStats `json:"stats"`
+	Retained int        `json:"retained"`
+	Deleted  bool       `json:"deleted"`
+	MetaData metaFile   `json:"metadata"`
+}
+
+type metaFile struct {
+	KeepPolicies        []string             `json:"keep_policies,omitempty"`
+	KeepPolicy          string               `json:"keep_policy,omitempty"`
+	KeepPolicyAppliedAt int64                `json:"keep_policy_applied_at,omitempty"`
+	KeepPolicyLog       []KeepPolicyRecord   `json:"keep_policy_log,omitempty"`
+	KeepPolicyRewrites  int                  `json:"keep_policy_rewrites,omitempty"`
+	DropPolicies        []string             `json:"drop_policies"`
+	DropPolicyLog       []DropPolicyRecord   `json:"drop_policy_log,omitempty"`
+	DeletionRequests    []string             `json:"deletion_requests,omitempty"`
+	DeletedRanges       []DeletedRange       `json:"deleted_ranges,omitempty"`
+	Resolution          int64                `json:"resolution,omitempty"`
+	DownsampleLog       []DownsampleRecord   `json:"downsample_log,omitempty"`
+	QuotaLog            []QuotaRecord        `json:"quota_log,omitempty"`
+	SeriesBudgetLog     []SeriesBudgetRecord `json:"series_budget_log,omitempty"`
+	HoldLog             []HoldRecord         `json:"hold_log,omitempty"`
+	PendingSince        int64                `json:"pending_since,omitempty"`
+	Generation          int64                `json:"generation"`
+}
+
+// LoadBucket reads a filesystem bucket. The tenant is the name of the directory.
+func LoadBucket(dir string) (*Bucket, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	userBucket := &Bucket{Tenant: filepath.Base(dir), Blocks: []Block{}, loaded: map[int]int64{}}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		f, err := readBlockFile(filepath.Join(dir, e.Name()))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("block %s: %w", e.Name(), err)
+		}
+		if _, ok := userBucket.loaded[f.ID]; ok {
+			return nil, fmt.Errorf("block %s: duplicate block id %d", e.Name(), f.ID)
+		}
+		userBucket.Blocks = append(userBucket.Blocks, f.toBlock())
+		userBucket.loaded[f.ID] = f.MetaData.Generation
+	}
+	sort.SliceStable(userBucket.Blocks, func(i, j int) bool {
+		return userBucket.Blocks[i].ID < userBucket.Blocks[j].ID
+	})
+
+	if err := loadTenantFile(dir, holdsFile, &userBucket.Holds); err != nil {
+		return nil, err
+	}
+	if err := loadTenantFile(dir, deletionsFile, &userBucket.Deletions); err != nil {
+		return nil, err
+	}
+	return userBucket, nil
+}
+
+func readBlockFile(blockDir string) (blockFile, error) {
+	f := blockFile{}
+	data, err := os.ReadFile(filepath.Join(blockDir, blockMetaFile))
+	if err != nil {
+		return f, err
+	}
+	return f, json.Unmarshal(data, &f)
+}
+
+func loadTenantFile(dir string, name string, v interface{}) error {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// SaveBucket writes the blocks of the bucket that changed since LoadBucket read them
+// to dir, replacing their metadata. It fails with ErrConflict when the metadata in dir
+// is not at the generation it was read at anymore, that is another process wrote the
+// block meanwhile, and the bucket should be loaded again.
+func SaveBucket(dir string, userBucket *Bucket) error {
+	userBucket.mu.Lock()
+	loaded := map[int]int64{}
+	for id, generation := range userBucket.loaded {
+		loaded[id] = generation
+	}
+	userBucket.mu.Unlock()
+
+	for _, b := range userBucket.snapshot() {
+		generation, wasLoaded := loaded[b.ID]
+		if wasLoaded && generation == b.MetaData.Generation {
+			continue
+		}
+		blockDir := filepath.Join(dir, strconv.Itoa(b.ID))
+		stored, err := readBlockFile(blockDir)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil && (!wasLoaded || stored.MetaData.Generation != generation) {
+			return fmt.Errorf("block %d: %w", b.ID, ErrConflict)
+		}
+		if err := os.MkdirAll(blockDir, 0o755); err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(newBlockFile(b), "", "  ")
+		if err != nil {
+			return err
+		}
+		// write then rename so that readers never see a partial file
+		tmp := filepath.Join(blockDir, blockMetaFile+".tmp")
+		if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, filepath.Join(blockDir, blockMetaFile)); err != nil {
+			return err
+		}
+		userBucket.mu.Lock()
+		if userBucket.loaded == nil {
+			userBucket.loaded = map[int]int64{}
+		}
+		userBucket.loaded[b.ID] = b.MetaData.Generation
+		userBucket.mu.Unlock()
+	}
+	holds, deletions := userBucket.ListHolds(), userBucket.ListDeletionRequests()
+	if err := saveTenantFile(dir, holdsFile, holds, len(holds)); err != nil {
+		return err
+	}
+	return saveTenantFile(dir, deletionsFile, deletions, len(deletions))
+}
+
+// saveTenantFile writes v to the named file of the bucket, or removes the file when v
+// has no entries.
+func saveTenantFile(dir string, name string, v interface{}, entries int) error {
+	path := filepath.Join(dir, name)
+	if entries == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func newBlockFile(b Block) blockFile {
+	f := blockFile{
+		ID:       b.ID,
+		MinT:     b.MinT,
+		MaxT:     b.MaxT,
+		Stats:    b.Stats,
+		Retained: b.Retained,
+		Deleted:  b.Deleted,
+		MetaData: metaFile(b.MetaData),
+	}
+	for s := range b.Series {
+		f.Series = append(f.Series, s)
+	}
+	sort.Strings(f.Series)
+	return f
+}
+
+func (f blockFile) toBlock() Block {
+	b := Block{
+		ID:       f.ID,
+		MinT:     f.MinT,
+		MaxT:     f.MaxT,
+		Stats:    f.Stats,
+		Retained: f.Retained,
+		Deleted:  f.Deleted,
+		MetaData: MetaData(f.MetaData),
+	}
+	if len(f.Series) > 0 {
+		b.Series = map[string]interface{}{}
+		for _, s := range f.Series {
+			b.Series[s] = nil
+		}
+	}
+	return b
+}
+
+// DirLease is a bucket lock held in the directory of a filesystem bucket, so that
+// processes sharing the directory, not only the goroutines sharing a Bucket, never
+// write it at once. It must be renewed before it expires and released once the
+// bucket is saved.
+type DirLease struct {
+	path  string
+	owner string
+	ttl   int64
+}
+
+// AcquireDirLock takes the lock of the bucket in dir for owner, creating its lock file
+// exclusively. Like AcquireBucketLock, it takes over a stale lock and renews our own.
+func AcquireDirLock(dir string, owner string, ttl int64, currentTime int64) (*DirLease, error) {
+	l := &DirLease{path: filepath.Join(dir, lockFile), owner: owner, ttl: ttl}
+	data, err := json.Marshal(BucketLock{Owner: owner, ExpiresAt: currentTime + ttl, RenewedAt: currentTime})
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; attempt < maxWriteAttempts; attempt++ {
+		f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			_, err = f.Write(append(data, '\n'))
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(l.path)
+				return nil, err
+			}
+			return l, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		held, raw, err := readDirLock(l.path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if held.Owner != owner && held.ExpiresAt > currentTime {
+			return nil, &LockHeldError{Owner: held.Owner, ExpiresAt: held.ExpiresAt}
+		}
+		// move the stale lock aside and race for the exclusive create again, putting
+		// the lock back if someone else replaced it since we read it
+		aside := l.path + "." + owner + ".stale"
+		if err := os.Rename(l.path, aside); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		if moved, err := os.ReadFile(aside); err == nil && string(moved) != string(raw) {
+			os.Link(aside, l.path)
+		}
+		os.Remove(aside)
+	}
+	return nil, fmt.Errorf("%s: giving up after %d attempts", l.path, maxWriteAttempts)
+}
+
+func readDirLock(path string) (BucketLock, []byte, error) {
+	lock := BucketLock{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return lock, nil, err
+	}
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return lock, nil, fmt.Errorf("%s: %w", lockFile, err)
+	}
+	return lock, data, nil
+}
+
+// owned returns ErrLockLost unless the lock file is still ours.
+func (l *DirLease) owned() error {
+	lock, _, err := readDirLock(l.path)
+	if os.IsNotExist(err) || (err == nil && lock.Owner != l.owner) {
+		return ErrLockLost
+	}
+	return err
+}
+
+// Renew is the lease heartbeat, it pushes the expiry ttl past currentTime. Call it
+// right before SaveBucket: it fails with ErrLockLost if another process took the lock
+// over meanwhile.
+func (l *DirLease) Renew(currentTime int64) error {
+	if err := l.owned(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(BucketLock{Owner: l.owner, ExpiresAt: currentTime + l.ttl, RenewedAt: currentTime})
+	if err != nil {
+		return err
+	}
+	tmp := l.path + "." + l.owner + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
+
+// Release removes the lock file if we still own it.
+func (l *DirLease) Release() error {
+	if err := l.owned(); err != nil {
+		return err
+	}
+	return os.Remove(l.path)
+}
@@ -0,0 +1,135 @@
+package toyRetention
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveAndLoadBucket(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "team-a")
+	bucket := &Bucket{
+		Tenant: "team-a",
+		Blocks: []Block{
+			{
+				ID:       2,
+				MinT:     theCurrentTime - 2*secondsInADay,
+				MaxT:     theCurrentTime - secondsInADay,
+				Series:   map[string]interface{}{"service=h1": nil, "name=ying": nil},
+				Stats:    BlockStats{Bytes: 100, NumSeries: 2, NumSamples: 1000},
+				Retained: 1,
+				MetaData: MetaData{DropPolicies: []string{hashPolicy("service=h2")}, Generation: 1},
+			},
+			{ID: 1, MaxT: theCurrentTime - 3*secondsInADay, Deleted: true},
+		},
+	}
+	assert.NoError(t, SaveBucket(dir, bucket))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("not a block"), 0o644))
+
+	loaded, err := LoadBucket(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "team-a", loaded.Tenant)
+	assert.Equal(t, 2, len(loaded.Blocks))
+	assert.Equal(t, 1, loaded.Blocks[0].ID)
+	assert.Equal(t, true, loaded.Blocks[0].Deleted)
+	assert.Equal(t, bucket.Blocks[0], loaded.Blocks[1])
+
+	// a copied block directory is not taken for another block
+	data, err := os.ReadFile(filepath.Join(dir, "2", "meta.json"))
+	assert.NoError(t, err)
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "3"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "3", "meta.json"), data, 0o644))
+	_, err = LoadBucket(dir)
+	assert.EqualError(t, err, "block 3: duplicate block id 2")
+}
+
+func TestSaveAndLoadBucketHolds(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "team-a")
+	bucket := &Bucket{Blocks: []Block{{ID: 1}}}
+	_, err := bucket.PlaceHold(LegalHold{Selector: "service=h1", MinT: 10, MaxT: 20, Reason: "case 42", ExpiresAt: 100}, 5)
+	assert.NoError(t, err)
+	assert.NoError(t, SaveBucket(dir, bucket))
+
+	loaded, err := LoadBucket(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []LegalHold{{ID: "hold-1", Selector: "service=h1", MinT: 10, MaxT: 20, Reason: "case 42", CreatedAt: 5, ExpiresAt: 100}}, loaded.Holds)
+
+	// releasing the last hold removes the file
+	assert.NoError(t, loaded.ReleaseHold("hold-1"))
+	assert.NoError(t, SaveBucket(dir, loaded))
+	_, err = os.Stat(filepath.Join(dir, holdsFile))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestSaveAndLoadBucketDeletions(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "team-a")
+	bucket := &Bucket{Blocks: []Block{{ID: 1, MetaData: MetaData{DeletionRequests: []string{"deletion-1"}}}}}
+	_, err := bucket.RequestDeletion(DeletionRequest{Selector: "service=h1", MaxT: 20}, 5)
+	assert.NoError(t, err)
+	assert.NoError(t, SaveBucket(dir, bucket))
+
+	loaded, err := LoadBucket(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, bucket.Deletions, loaded.Deletions)
+	assert.Equal(t, []string{"deletion-1"}, loaded.Blocks[0].MetaData.DeletionRequests)
+}
+
+func TestSaveBucketConflict(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "team-a")
+	config := UserConfig{BaseRetention: 10 * secondsInADay, Policies: []PerSeriesRetentionPolicy{{RetentionPeriod: 5 * secondsInADay, Policy: "service=h1"}}}
+	assert.NoError(t, SaveBucket(dir, &Bucket{Blocks: []Block{
+		{ID: 1, MaxT: theCurrentTime - 6*secondsInADay, Series: map[string]interface{}{"service=h1": nil, "service=h2": nil}, Stats: BlockStats{Bytes: 100}},
+		{ID: 2, MaxT: theCurrentTime - secondsInADay},
+	}}))
+
+	// two applies load the bucket before either saves it
+	first, err := LoadBucket(dir)
+	assert.NoError(t, err)
+	second, err := LoadBucket(dir)
+	assert.NoError(t, err)
+	_, err = NewEngine("compactor-1").Run(config, first, theCurrentTime)
+	assert.NoError(t, err)
+	_, err = NewEngine("compactor-2").Run(config, second, theCurrentTime)
+	assert.NoError(t, err)
+
+	assert.NoError(t, SaveBucket(dir, first))
+	assert.ErrorIs(t, SaveBucket(dir, second), ErrConflict)
+
+	// the first rewrite is kept, and saving again only writes what changed
+	loaded, err := LoadBucket(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, loaded.Blocks[0].Retained)
+	assert.Equal(t, int64(1), loaded.Blocks[0].MetaData.Generation)
+	assert.NoError(t, SaveBucket(dir, first))
+	assert.NoError(t, SaveBucket(dir, loaded))
+}
+
+func TestAcquireDirLock(t *testing.T) {
+	dir := t.TempDir()
+	ttl := int64(60)
+
+	lease, err := AcquireDirLock(dir, "compactor-1", ttl, theCurrentTime)
+	assert.NoError(t, err)
+	lock, _, err := readDirLock(filepath.Join(dir, lockFile))
+	assert.NoError(t, err)
+	assert.Equal(t, BucketLock{Owner: "compactor-1", ExpiresAt: theCurrentTime + ttl, RenewedAt: theCurrentTime}, lock)
+
+	// another process cannot take a live lock, the heartbeat keeps it alive
+	_, err = AcquireDirLock(dir, "compactor-2", ttl, theCurrentTime+30)
+	assert.Equal(t, &LockHeldError{Owner: "compactor-1", ExpiresAt: theCurrentTime + ttl}, err)
+	assert.NoError(t, lease.Renew(theCurrentTime+50))
+	_, err = AcquireDirLock(dir, "compactor-2", ttl, theCurrentTime+70)
+	assert.Error(t, err)
+
+	// a stale lock is taken over, the previous owner finds out before saving
+	other, err := AcquireDirLock(dir, "compactor-2", ttl, theCurrentTime+200)
+	assert.NoError(t, err)
+	assert.Equal(t, ErrLockLost, lease.Renew(theCurrentTime+201))
+	assert.Equal(t, ErrLockLost, lease.Release())
+
+	assert.NoError(t, other.Release())
+	_, err = os.Stat(filepath.Join(dir, lockFile))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -29,6 +29,15 @@ func hashPolicy(policy string) string {
 	return base64.StdEncoding.EncodeToString([]byte(policy))
 }
 
+// decodePolicy reverses hashPolicy, undecodable values are returned as they are.
+func decodePolicy(hash string) string {
+	policy, err := base64.StdEncoding.DecodeString(hash)
+	if err != nil {
+		return hash
+	}
+	return string(policy)
+}
+
 func buildKeepPolicy(policies []PerSeriesRetentionPolicy, baseRetention int64, currentTime int64, maxT int64) []string {
 	keepPolicies := []string{}
 	// When base retention is not reached, we don't need to build keep policies, only drop policy counts.
@@ -57,11 +66,11 @@ func buildDropPolicy(policies []PerSeriesRetentionPolicy, baseRetention int64, c
 	return dropPolicies
 }
 
-func isKeepPoliciesSame(keepPolicyHistory []string, keepPolicy []string) bool {
-	if len(keepPolicyHistory) == 0 {
+func isKeepPoliciesSame(currentKeepPolicy string, keepPolicy []string) bool {
+	if currentKeepPolicy == "" {
 		return len(keepPolicy) == 0
 	}
-	if keepPolicyHistory[len(keepPolicyHistory)-1] != hashPolicy(strings.Join(keepPolicy, ";")) {
+	if currentKeepPolicy != hashPolicy(strings.Join(keepPolicy, ";")) {
 		return false
 	}
 	return true
@@ -75,7 +84,7 @@ func needsRewrite(dropPolicies []string, keepPolicies []string, b Block, current
 		if len(keepPolicies) == 0 {
 			return true, false, false
 		}
-		if !isKeepPoliciesSame(b.MetaData.KeepPolicies, keepPolicies) {
+		if !isKeepPoliciesSame(currentKeepPolicy(b.MetaData), keepPolicies) {
 			rewriteKeepPolicy = true
 		}
 	}
@@ -95,3 +104,59 @@ func needsRewrite(dropPolicies []string, keepPolicies []string, b Block, current
 	}
 	return false, rewriteKeepPolicy, rewriteDropPolicy
 }
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLabels parses a series or policy written as comma separated label pairs,
+// either as `a=b,c=d` or in the selector form `{a="b", c="d"}`.
+func parseLabels(s string) map[string]string {
+	labels := map[string]string{}
+	s = strings.TrimSpace(s)
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "{"), "}")
+	for _, pair := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		labels[strings.TrimSpace(k)] = strings.Trim(strings.TrimSpace(v), `"`)
+	}
+	return labels
+}
+
+// matchesPolicy returns true if the series carries every label pair of the policy.
+func matchesPolicy(series string, policy string) bool {
+	matchers := parseLabels(policy)
+	if len(matchers) == 0 {
+		return false
+	}
+	labels := parseLabels(series)
+	for k, v := range matchers {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// cloneBlock copies the block deep enough that appending to its metadata does not
+// write through to the original.
+func cloneBlock(b Block) Block {
+	b.MetaData.KeepPolicies = append([]string(nil), b.MetaData.KeepPolicies...)
+	b.MetaData.KeepPolicyLog = append([]KeepPolicyRecord(nil), b.MetaData.KeepPolicyLog...)
+	b.MetaData.DropPolicies = append([]string(nil), b.MetaData.DropPolicies...)
+	b.MetaData.DropPolicyLog = append([]DropPolicyRecord(nil), b.MetaData.DropPolicyLog...)
+	b.MetaData.DeletionRequests = append([]string(nil), b.MetaData.DeletionRequests...)
+	b.MetaData.DeletedRanges = append([]DeletedRange(nil), b.MetaData.DeletedRanges...)
+	b.MetaData.DownsampleLog = append([]DownsampleRecord(nil), b.MetaData.DownsampleLog...)
+	b.MetaData.QuotaLog = append([]QuotaRecord(nil), b.MetaData.QuotaLog...)
+	b.MetaData.SeriesBudgetLog = append([]SeriesBudgetRecord(nil), b.MetaData.SeriesBudgetLog...)
+	b.MetaData.HoldLog = append([]HoldRecord(nil), b.MetaData.HoldLog...)
+	return b
+}
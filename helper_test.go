@@ -159,37 +159,37 @@ func TestBuildDropPolicy(t *testing.T) {
 func TestIsKeepPoliciesSame(t *testing.T) {
 	testCases := []struct {
 		testName          string
-		keepPolicyHistory []string
+		currentKeepPolicy string
 		keepPolicy        []string
 		expected          bool
 	}{
 		{
-			testName:          "Empty Keep Policy History and Empty Keep Policy",
-			keepPolicyHistory: []string{},
+			testName:          "Empty Current Keep Policy and Empty Keep Policy",
+			currentKeepPolicy: "",
 			keepPolicy:        []string{},
 			expected:          true,
 		},
 		{
-			testName:          "Empty Keep Policy History and Non-empty Keep Policy",
-			keepPolicyHistory: []string{},
+			testName:          "Empty Current Keep Policy and Non-empty Keep Policy",
+			currentKeepPolicy: "",
 			keepPolicy:        []string{"Policy1", "Policy2"},
 			expected:          false,
 		},
 		{
-			testName:          "Non-empty Keep Policy History and Empty Keep Policy",
-			keepPolicyHistory: []string{"12345"},
+			testName:          "Non-empty Current Keep Policy and Empty Keep Policy",
+			currentKeepPolicy: "12345",
 			keepPolicy:        []string{},
 			expected:          false,
 		},
 		{
 			testName:          "Keep Policies Same",
-			keepPolicyHistory: []string{hashPolicy("Policy1;Policy2")},
+			currentKeepPolicy: hashPolicy("Policy1;Policy2"),
 			keepPolicy:        []string{"Policy1", "Policy2"},
 			expected:          true,
 		},
 		{
 			testName:          "Keep Policies Different",
-			keepPolicyHistory: []string{hashPolicy("Policy1;Policy2")},
+			currentKeepPolicy: hashPolicy("Policy1;Policy2"),
 			keepPolicy:        []string{"Policy1"},
 			expected:          false,
 		},
@@ -197,7 +197,7 @@ func TestIsKeepPoliciesSame(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.testName, func(t *testing.T) {
-			result := isKeepPoliciesSame(tc.keepPolicyHistory, tc.keepPolicy)
+			result := isKeepPoliciesSame(tc.currentKeepPolicy, tc.keepPolicy)
 			assert.Equal(t, tc.expected, result)
 		})
 	}
@@ -278,3 +278,23 @@ func TestNeedsRewrite(t *testing.T) {
 		})
 	}
 }
+
+func TestMatchesPolicy(t *testing.T) {
+	testCases := []struct {
+		name     string
+		series   string
+		policy   string
+		expected bool
+	}{
+		{name: "single label matches", series: "name=ying,service=h1", policy: "service=h1", expected: true},
+		{name: "single label does not match", series: "name=ying,service=h2", policy: "service=h1", expected: false},
+		{name: "all labels of the policy must match", series: "name=ying,service=h1", policy: "service=h1,name=other", expected: false},
+		{name: "selector form", series: `{name="ying", service="h1"}`, policy: `{service="h1"}`, expected: true},
+		{name: "empty policy matches nothing", series: "name=ying", policy: "", expected: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, matchesPolicy(tc.series, tc.policy))
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package toyRetention
+
+// setKeepPolicy makes fingerprint the current keep set, moving the previous one to
+// the log.
+func setKeepPolicy(md MetaData, fingerprint string, currentTime int64, limit int) MetaData {
+	md = migrateKeepPolicies(md, limit)
+	if md.KeepPolicy != "" {
+		md.KeepPolicyLog = append(md.KeepPolicyLog, KeepPolicyRecord{Fingerprint: md.KeepPolicy, AppliedAt: md.KeepPolicyAppliedAt})
+	}
+	md.KeepPolicy = fingerprint
+	md.KeepPolicyAppliedAt = currentTime
+	md.KeepPolicyRewrites++
+	md.KeepPolicyLog = capKeepPolicyLog(md.KeepPolicyLog, limit)
+	return md
+}
+
+// migrateKeepPolicies moves the legacy unbounded KeepPolicies into KeepPolicy and
+// KeepPolicyLog. Legacy entries have no timestamp and are older than anything logged.
+func migrateKeepPolicies(md MetaData, limit int) MetaData {
+	if len(md.KeepPolicies) == 0 {
+		return md
+	}
+	legacy := md.KeepPolicies
+	log := []KeepPolicyRecord{}
+	if md.KeepPolicy == "" {
+		md.KeepPolicy = legacy[len(legacy)-1]
+		legacy = legacy[:len(legacy)-1]
+	}
+	for _, fingerprint := range legacy {
+		log = append(log, KeepPolicyRecord{Fingerprint: fingerprint})
+	}
+	md.KeepPolicyLog = capKeepPolicyLog(append(log, md.KeepPolicyLog...), limit)
+	md.KeepPolicyRewrites += len(md.KeepPolicies)
+	md.KeepPolicies = nil
+	return md
+}
+
+// currentKeepPolicy is the fingerprint of the keep set the block was last rewritten
+// with, also for blocks not migrated yet.
+func currentKeepPolicy(md MetaData) string {
+	return migrateKeepPolicies(md, 0).KeepPolicy
+}
+
+// keepPolicyFingerprints returns the remembered keep sets of the block, oldest first
+// and ending with the current one.
+func keepPolicyFingerprints(md MetaData) []string {
+	md = migrateKeepPolicies(md, FullKeepHistory)
+	fingerprints := []string{}
+	for _, r := range md.KeepPolicyLog {
+		fingerprints = append(fingerprints, r.Fingerprint)
+	}
+	if md.KeepPolicy != "" {
+		fingerprints = append(fingerprints, md.KeepPolicy)
+	}
+	return fingerprints
+}
+
+// keepPolicyRewrites counts the keep sets the block was rewritten with.
+func keepPolicyRewrites(md MetaData) int {
+	return migrateKeepPolicies(md, 0).KeepPolicyRewrites
+}
+
+func capKeepPolicyLog(log []KeepPolicyRecord, limit int) []KeepPolicyRecord {
+	if limit == FullKeepHistory || len(log) <= limit {
+		return log
+	}
+	return append([]KeepPolicyRecord(nil), log[len(log)-limit:]...)
+}
+
+// migrateBucketKeepPolicies converts the blocks still carrying the legacy unbounded
+// keep set history, keeping at most limit previous sets, and returns how many were
+// migrated.
+func migrateBucketKeepPolicies(userBucket *Bucket, limit int) (int, error) {
+	migrated := 0
+	for i := range userBucket.snapshot() {
+		for attempt := 0; ; attempt++ {
+			b := userBucket.ReadBlock(i)
+			if len(b.MetaData.KeepPolicies) == 0 {
+				break
+			}
+			generation := b.MetaData.Generation
+			b.MetaData = migrateKeepPolicies(b.MetaData, limit)
+			err := userBucket.WriteBlock(i, b, generation)
+			if err == nil {
+				migrated++
+				break
+			}
+			if err != ErrConflict || attempt+1 >= maxWriteAttempts {
+				return migrated, err
+			}
+		}
+	}
+	return migrated, nil
+}
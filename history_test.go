@@ -0,0 +1,74 @@
+package toyRetention
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetKeepPolicyCapsHistory(t *testing.T) {
+	md := MetaData{}
+	for i, fingerprint := range []string{"a", "b", "c", "d"} {
+		md = setKeepPolicy(md, fingerprint, int64(i*10), 2)
+	}
+	assert.Equal(t, "d", md.KeepPolicy)
+	assert.Equal(t, int64(30), md.KeepPolicyAppliedAt)
+	assert.Equal(t, []KeepPolicyRecord{{Fingerprint: "b", AppliedAt: 10}, {Fingerprint: "c", AppliedAt: 20}}, md.KeepPolicyLog)
+	assert.Equal(t, 4, md.KeepPolicyRewrites)
+
+	// by default only the current keep set is remembered
+	md = setKeepPolicy(md, "e", 40, 0)
+	assert.Equal(t, 0, len(md.KeepPolicyLog))
+	assert.Equal(t, 5, md.KeepPolicyRewrites)
+}
+
+func TestSetKeepPolicyFullHistory(t *testing.T) {
+	md := MetaData{}
+	for i, fingerprint := range []string{"a", "b", "c"} {
+		md = setKeepPolicy(md, fingerprint, int64(i), FullKeepHistory)
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, keepPolicyFingerprints(md))
+}
+
+func TestMigrateBucketKeepPolicies(t *testing.T) {
+	legacy := MetaData{KeepPolicies: []string{"a", "b", "c"}, Generation: 3}
+	assert.Equal(t, "c", currentKeepPolicy(legacy))
+	assert.Equal(t, 3, keepPolicyRewrites(legacy))
+	assert.Equal(t, []string{"a", "b", "c"}, keepPolicyFingerprints(legacy))
+
+	bucket := &Bucket{Blocks: []Block{{ID: 1, MetaData: legacy}, {ID: 2}}}
+	migrated, err := migrateBucketKeepPolicies(bucket, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, migrated)
+	md := bucket.Blocks[0].MetaData
+	assert.Equal(t, 0, len(md.KeepPolicies))
+	assert.Equal(t, "c", md.KeepPolicy)
+	assert.Equal(t, []KeepPolicyRecord{{Fingerprint: "b"}}, md.KeepPolicyLog)
+	assert.Equal(t, 3, md.KeepPolicyRewrites)
+	assert.Equal(t, int64(4), md.Generation)
+
+	// migrating again has nothing left to do
+	migrated, err = migrateBucketKeepPolicies(bucket, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, migrated)
+}
+
+func TestApplyBucketRetentionMigratesKeepPolicies(t *testing.T) {
+	config := UserConfig{
+		BaseRetention:    10 * secondsInADay,
+		Policies:         []PerSeriesRetentionPolicy{{RetentionPeriod: 20 * secondsInADay, Policy: "name=ying"}},
+		KeepHistoryLimit: 1,
+	}
+	bucket := &Bucket{Blocks: []Block{{
+		MaxT:     theCurrentTime - 11*secondsInADay,
+		Retained: 2,
+		MetaData: MetaData{KeepPolicies: []string{hashPolicy("name=ying;namespace=b0"), hashPolicy("name=ying;namespace=b1")}},
+	}}}
+	assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime))
+	md := bucket.Blocks[0].MetaData
+	assert.Equal(t, 0, len(md.KeepPolicies))
+	assert.Equal(t, hashPolicy("name=ying"), md.KeepPolicy)
+	assert.Equal(t, theCurrentTime, md.KeepPolicyAppliedAt)
+	assert.Equal(t, []KeepPolicyRecord{{Fingerprint: hashPolicy("name=ying;namespace=b1")}}, md.KeepPolicyLog)
+	assert.Equal(t, 3, md.KeepPolicyRewrites)
+}
@@ -0,0 +1,373 @@
+package toyRetention
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// LegalHold protects data from retention: while it is active, retention neither
+// deletes the blocks it covers nor rewrites away the series it covers. A hold covers
+//   - the blocks listed in BlockIDs, or
+//   - the series matching Selector with samples between MinT and MaxT, with no end
+//     when MaxT is not set, or
+//   - the whole tenant when neither is set.
+type LegalHold struct {
+	ID       string `json:"id"`
+	BlockIDs []int  `json:"block_ids,omitempty"`
+	Selector string `json:"selector,omitempty"`
+	MinT     int64  `json:"min_time,omitempty"`
+	MaxT     int64  `json:"max_time,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	// CreatedAt is set when the hold is placed. A hold with ExpiresAt set is released
+	// automatically at that time.
+	CreatedAt int64 `json:"created_at"`
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+// ErrHoldExists is returned when placing a hold with the ID of an existing one.
+var ErrHoldExists = errors.New("legal hold already exists")
+
+// UnknownHoldError is returned when releasing a hold the bucket does not have.
+type UnknownHoldError string
+
+func (e UnknownHoldError) Error() string {
+	return fmt.Sprintf("unknown legal hold %q", string(e))
+}
+
+// Active tells whether the hold still protects data at currentTime.
+func (h LegalHold) Active(currentTime int64) bool {
+	return h.ExpiresAt == 0 || currentTime < h.ExpiresAt
+}
+
+func (h LegalHold) validate() error {
+	if len(h.BlockIDs) > 0 && h.Selector != "" {
+		return errors.New("a legal hold covers either blocks or a series selector, not both")
+	}
+	if h.Selector != "" && len(parseLabels(h.Selector)) == 0 {
+		return fmt.Errorf("selector %q: expected label pairs like name=value", h.Selector)
+	}
+	if h.Selector != "" && h.MaxT != 0 && h.MaxT < h.MinT {
+		return errors.New("max time of a legal hold must not be before its min time")
+	}
+	if h.Selector == "" && (h.MinT != 0 || h.MaxT != 0) {
+		return errors.New("a time range needs a series selector")
+	}
+	return nil
+}
+
+// coversBlock tells whether the hold protects the whole block.
+func (h LegalHold) coversBlock(b Block) bool {
+	if h.Selector != "" {
+		return false
+	}
+	if len(h.BlockIDs) == 0 {
+		return true
+	}
+	for _, id := range h.BlockIDs {
+		if id == b.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// coversSeries tells whether the hold protects the series in the block.
+func (h LegalHold) coversSeries(b Block, series string) bool {
+	if h.Selector == "" {
+		return h.coversBlock(b)
+	}
+	return b.MinT <= h.MaxT && b.MaxT >= h.MinT && matchesPolicy(series, h.Selector)
+}
+
+// PlaceHold adds a legal hold to the bucket and returns it with its ID and creation
+// time set. An ID is generated when the hold has none.
+func (bkt *Bucket) PlaceHold(h LegalHold, currentTime int64) (LegalHold, error) {
+	if err := h.validate(); err != nil {
+		return LegalHold{}, err
+	}
+	if h.Selector != "" && h.MaxT == 0 {
+		h.MaxT = math.MaxInt64
+	}
+	bkt.mu.Lock()
+	defer bkt.mu.Unlock()
+	exists := func(id string) bool {
+		for _, existing := range bkt.Holds {
+			if existing.ID == id {
+				return true
+			}
+		}
+		return false
+	}
+	for n := len(bkt.Holds) + 1; h.ID == ""; n++ {
+		if id := "hold-" + strconv.Itoa(n); !exists(id) {
+			h.ID = id
+		}
+	}
+	if exists(h.ID) {
+		return LegalHold{}, fmt.Errorf("%q: %w", h.ID, ErrHoldExists)
+	}
+	h.CreatedAt = currentTime
+	bkt.Holds = append(bkt.Holds, h)
+	return h, nil
+}
+
+// ReleaseHold removes a legal hold from the bucket.
+func (bkt *Bucket) ReleaseHold(id string) error {
+	bkt.mu.Lock()
+	defer bkt.mu.Unlock()
+	for i, h := range bkt.Holds {
+		if h.ID == id {
+			bkt.Holds = append(bkt.Holds[:i:i], bkt.Holds[i+1:]...)
+			return nil
+		}
+	}
+	return UnknownHoldError(id)
+}
+
+// ListHolds returns every hold of the bucket, expired ones included, by ID.
+func (bkt *Bucket) ListHolds() []LegalHold {
+	bkt.mu.Lock()
+	holds := append([]LegalHold{}, bkt.Holds...)
+	bkt.mu.Unlock()
+	sort.SliceStable(holds, func(i, j int) bool {
+		return holds[i].ID < holds[j].ID
+	})
+	return holds
+}
+
+// ActiveHolds returns the holds of the bucket that have not expired at currentTime.
+func (bkt *Bucket) ActiveHolds(currentTime int64) []LegalHold {
+	holds := []LegalHold{}
+	for _, h := range bkt.ListHolds() {
+		if h.Active(currentTime) {
+			holds = append(holds, h)
+		}
+	}
+	return holds
+}
+
+// holdsBlocking returns the IDs of the holds the action would violate: a delete
+// removes any held data from the block, a rewrite the held series it drops, deletes
+// samples of or downsamples. Blocks with unknown series are assumed to hold series of every selector
+// overlapping them.
+func holdsBlocking(holds []LegalHold, b Block, a Action) []string {
+	ids := []string{}
+	for _, h := range holds {
+		if h.coversBlock(b) {
+			ids = append(ids, h.ID)
+			continue
+		}
+		if h.Selector == "" || b.MinT > h.MaxT || b.MaxT < h.MinT {
+			continue
+		}
+		if len(b.Series) == 0 {
+			ids = append(ids, h.ID)
+			continue
+		}
+		for s := range b.Series {
+			if !h.coversSeries(b, s) {
+				continue
+			}
+			_, removed := seriesRemovedBy(s, b, a)
+			_, trimmed := seriesTrimmedBy(s, a)
+			_, downsampled := seriesDownsampledTo(s, b, a)
+			if a.Kind == ActionDelete || removed || trimmed || downsampled {
+				ids = append(ids, h.ID)
+				break
+			}
+		}
+	}
+	return ids
+}
+
+func seriesHeld(holds []LegalHold, b Block, series string) bool {
+	for _, h := range holds {
+		if h.coversSeries(b, series) {
+			return true
+		}
+	}
+	return false
+}
+
+// holdsCovering returns the IDs of the holds protecting some data of the block.
+func holdsCovering(holds []LegalHold, b Block) []string {
+	ids := []string{}
+	for _, h := range holds {
+		if h.coversBlock(b) || (h.Selector != "" && b.MinT <= h.MaxT && b.MaxT >= h.MinT) {
+			ids = append(ids, h.ID)
+		}
+	}
+	return ids
+}
+
+// HeldAction is a retention action, or the part of it, withheld because of legal holds.
+type HeldAction struct {
+	Action
+	HeldBy []string `json:"held_by"`
+	// Series are the held series left in the block while the rest of the action is
+	// carried out, empty when the whole action is withheld.
+	Series []string `json:"series,omitempty"`
+}
+
+// HoldRecord is a rewrite of a block that left the series under legal holds.
+type HoldRecord struct {
+	AppliedAt  int64 `json:"applied_at"`
+	SeriesHeld int64 `json:"series_held"`
+}
+
+// withholdHeldSeries splits the action into the part the legal holds allow and the
+// part they withhold. A delete becomes a rewrite dropping every series but the held
+// ones, a rewrite leaves the held series it would remove or delete samples of and
+// does not downsample the held series or the policies matching them. The whole action is withheld when a hold
+// covers the whole block, when the series of the block are unknown or when nothing is
+// left to do without the held series.
+func withholdHeldSeries(holds []LegalHold, b Block, a Action) (Action, bool, HeldAction, bool) {
+	ids := holdsBlocking(holds, b, a)
+	if len(ids) == 0 {
+		return a, true, HeldAction{}, false
+	}
+	whole := HeldAction{Action: a, HeldBy: ids}
+	if len(b.Series) == 0 {
+		return Action{}, false, whole, true
+	}
+	for _, h := range holds {
+		if h.coversBlock(b) {
+			return Action{}, false, whole, true
+		}
+	}
+
+	allowed, series := a, []string{}
+	if a.Kind == ActionDelete {
+		allowed = Action{BlockID: b.ID, Kind: ActionRewrite, Deadline: a.Deadline, index: a.index, generation: a.generation}
+		for s := range b.Series {
+			if seriesHeld(holds, b, s) {
+				series = append(series, s)
+			} else {
+				allowed.ExpiredSeries = append(allowed.ExpiredSeries, s)
+			}
+		}
+		sort.Strings(allowed.ExpiredSeries)
+	} else {
+		allowed.HeldSeries, allowed.Downsample = nil, nil
+		for p, r := range a.Downsample {
+			if allowed.Downsample == nil {
+				allowed.Downsample = map[string]int64{}
+			}
+			allowed.Downsample[p] = r
+		}
+		for s := range b.Series {
+			if !seriesHeld(holds, b, s) {
+				continue
+			}
+			_, removed := seriesRemovedBy(s, b, a)
+			_, trimmed := seriesTrimmedBy(s, a)
+			_, downsampled := seriesDownsampledTo(s, b, a)
+			if removed || trimmed {
+				allowed.HeldSeries = append(allowed.HeldSeries, s)
+			}
+			if removed || trimmed || downsampled {
+				series = append(series, s)
+			}
+			// the resolution of the block or of a policy is recorded as a whole
+			resolution := seriesResolution(b.MetaData, s)
+			if a.Resolution > resolution {
+				allowed.Resolution = 0
+			}
+			for p, r := range a.Downsample {
+				if r > resolution && matchesPolicy(s, p) {
+					delete(allowed.Downsample, p)
+				}
+			}
+		}
+		if len(allowed.Downsample) == 0 {
+			allowed.Downsample = nil
+		}
+		sort.Strings(allowed.HeldSeries)
+	}
+	sort.Strings(series)
+	allowed.SeriesDropped = seriesDroppedByPolicy(b, allowed)
+	allowed.EstimatedBytes = estimateReclaimedBytes(b, allowed)
+	if !changesBlock(b, allowed) {
+		return Action{}, false, whole, true
+	}
+
+	withheld := HeldAction{Action: a, HeldBy: ids, Series: series}
+	withheld.EstimatedBytes = a.EstimatedBytes - allowed.EstimatedBytes
+	if a.SeriesDropped != nil {
+		withheld.SeriesDropped = map[string]int64{}
+		for p, n := range a.SeriesDropped {
+			if n > allowed.SeriesDropped[p] {
+				withheld.SeriesDropped[p] = n - allowed.SeriesDropped[p]
+			}
+		}
+	}
+	return allowed, true, withheld, true
+}
+
+// changesBlock returns true if the rewrite removes or downsamples data, or records a
+// policy or deletion request as applied.
+func changesBlock(b Block, a Action) bool {
+	if a.Kind == ActionDelete || a.Resolution > 0 || len(a.Downsample) > 0 || a.Quota || len(a.BudgetSeries) > 0 {
+		return true
+	}
+	for s := range b.Series {
+		if _, removed := seriesRemovedBy(s, b, a); removed {
+			return true
+		}
+	}
+	spared := sparedRemovals(b, a)
+	if a.RewriteKeepPolicy && !spared[defaultPolicyName] {
+		return true
+	}
+	if a.RewriteDropPolicy {
+		for _, dp := range a.DropPolicies {
+			if !spared[dp] && !containsString(b.MetaData.DropPolicies, hashPolicy(dp)) {
+				return true
+			}
+		}
+	}
+	for _, id := range a.DeletionRequests {
+		if !spared[deletionRequestName(id)] {
+			return true
+		}
+	}
+	return false
+}
+
+// sparedRemovals returns what would remove the series the rewrite leaves for legal
+// holds: drop policies, deletion requests under deletionRequestName, and
+// defaultPolicyName for the keep set.
+func sparedRemovals(b Block, a Action) map[string]bool {
+	spared := map[string]bool{}
+	unheld := a
+	unheld.HeldSeries = nil
+	for _, s := range a.HeldSeries {
+		if name, removed := seriesRemovedBy(s, b, unheld); removed {
+			spared[name] = true
+		}
+		if r, trimmed := seriesTrimmedBy(s, unheld); trimmed {
+			spared[deletionRequestName(r.ID)] = true
+		}
+	}
+	return spared
+}
+
+// logHold records that the rewrite left held series in the block, if it did.
+func logHold(md MetaData, a Action, seriesLeft int64, currentTime int64) MetaData {
+	switch {
+	case len(a.HeldSeries) > 0:
+		md.HoldLog = append(md.HoldLog, HoldRecord{AppliedAt: currentTime, SeriesHeld: int64(len(a.HeldSeries))})
+	case len(a.ExpiredSeries) > 0:
+		md.HoldLog = append(md.HoldLog, HoldRecord{AppliedAt: currentTime, SeriesHeld: seriesLeft})
+	}
+	return md
+}
+
+// PlanHeldActions returns the actions retention would take now if it were not for
+// the active legal holds of the bucket.
+func PlanHeldActions(policies UserConfig, userBucket *Bucket, currentTime int64) []HeldAction {
+	return planBucket(policies, userBucket, currentTime).held
+}
@@ -0,0 +1,190 @@
+package toyRetention
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLegalHoldValidation(t *testing.T) {
+	bucket := &Bucket{}
+	_, err := bucket.PlaceHold(LegalHold{BlockIDs: []int{1}, Selector: "a=b"}, theCurrentTime)
+	assert.Error(t, err)
+	_, err = bucket.PlaceHold(LegalHold{Selector: "oops", MaxT: 1}, theCurrentTime)
+	assert.Error(t, err)
+	_, err = bucket.PlaceHold(LegalHold{Selector: "a=b", MinT: 2, MaxT: 1}, theCurrentTime)
+	assert.Error(t, err)
+	_, err = bucket.PlaceHold(LegalHold{MinT: 1, MaxT: 2}, theCurrentTime)
+	assert.Error(t, err)
+
+	h, err := bucket.PlaceHold(LegalHold{}, theCurrentTime)
+	assert.NoError(t, err)
+	assert.Equal(t, "hold-1", h.ID)
+	h, err = bucket.PlaceHold(LegalHold{ID: "hold-2"}, theCurrentTime)
+	assert.NoError(t, err)
+	assert.NoError(t, bucket.ReleaseHold("hold-1"))
+	h, err = bucket.PlaceHold(LegalHold{}, theCurrentTime)
+	assert.NoError(t, err)
+	assert.Equal(t, "hold-3", h.ID)
+	_, err = bucket.PlaceHold(LegalHold{ID: "hold-2"}, theCurrentTime)
+	assert.ErrorIs(t, err, ErrHoldExists)
+	assert.Equal(t, UnknownHoldError("hold-1"), bucket.ReleaseHold("hold-1"))
+}
+
+func TestApplyBucketRetentionHonoursHolds(t *testing.T) {
+	config := UserConfig{
+		BaseRetention: 10 * secondsInADay,
+		Policies: []PerSeriesRetentionPolicy{
+			{RetentionPeriod: 5 * secondsInADay, Policy: "service=h1"},
+			{RetentionPeriod: 20 * secondsInADay, Policy: "name=ying"},
+		},
+	}
+	series := map[string]interface{}{"service=h1": nil, "service=h2": nil, "name=ying": nil}
+	newBucket := func() *Bucket {
+		return &Bucket{Blocks: []Block{
+			{ID: 1, MinT: theCurrentTime - 8*secondsInADay, MaxT: theCurrentTime - 7*secondsInADay, Series: series},
+			{ID: 2, MinT: theCurrentTime - 31*secondsInADay, MaxT: theCurrentTime - 30*secondsInADay, Series: series},
+			{ID: 3, MinT: theCurrentTime - 31*secondsInADay, MaxT: theCurrentTime - 30*secondsInADay},
+		}}
+	}
+
+	t.Run("tenant hold", func(t *testing.T) {
+		bucket := newBucket()
+		_, err := bucket.PlaceHold(LegalHold{}, theCurrentTime)
+		assert.NoError(t, err)
+		assert.Equal(t, []Action{}, PlanBucketRetention(config, bucket, theCurrentTime))
+		assert.Equal(t, 3, len(PlanHeldActions(config, bucket, theCurrentTime)))
+		assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime))
+		assert.Equal(t, newBucket().Blocks, bucket.Blocks)
+	})
+
+	t.Run("series hold", func(t *testing.T) {
+		bucket := newBucket()
+		_, err := bucket.PlaceHold(LegalHold{Selector: "service=h1", MinT: theCurrentTime - 40*secondsInADay, MaxT: theCurrentTime - 20*secondsInADay}, theCurrentTime)
+		assert.NoError(t, err)
+		// only the deletion of the held series is withheld from block 2, the whole
+		// deletion of block 3 whose series are unknown
+		held := PlanHeldActions(config, bucket, theCurrentTime)
+		assert.Equal(t, []int{2, 3}, []int{held[0].BlockID, held[1].BlockID})
+		assert.Equal(t, []string{"hold-1"}, held[0].HeldBy)
+		assert.Equal(t, []string{"service=h1"}, held[0].Series)
+		assert.Empty(t, held[1].Series)
+		actions := PlanBucketRetention(config, bucket, theCurrentTime)
+		assert.Equal(t, []int{1, 2}, blockIDs(actions))
+		assert.Equal(t, ActionRewrite, actions[1].Kind)
+		assert.Equal(t, []string{"name=ying", "service=h2"}, actions[1].ExpiredSeries)
+		assert.Equal(t, []string{ReasonRetentionPassed}, actions[1].Reasons())
+
+		assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime))
+		// outside of the held time range service=h1 is dropped as usual
+		assert.Equal(t, 1, bucket.Blocks[0].Retained)
+		assert.NotContains(t, bucket.Blocks[0].Series, "service=h1")
+		// block 2 only keeps the held series, block 3 may hold it
+		assert.False(t, bucket.Blocks[1].Deleted)
+		assert.Equal(t, map[string]interface{}{"service=h1": nil}, bucket.Blocks[1].Series)
+		assert.Equal(t, []HoldRecord{{AppliedAt: theCurrentTime, SeriesHeld: 1}}, bucket.Blocks[1].MetaData.HoldLog)
+		assert.False(t, bucket.Blocks[2].Deleted)
+
+		// nothing else happens to block 2 until the hold is released
+		assert.Equal(t, []int{}, blockIDs(PlanBucketRetention(config, bucket, theCurrentTime+secondsInADay)))
+		assert.NoError(t, bucket.ReleaseHold("hold-1"))
+		assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime+secondsInADay))
+		assert.True(t, bucket.Blocks[1].Deleted)
+	})
+
+	t.Run("series hold on a rewrite", func(t *testing.T) {
+		bucket := newBucket()
+		_, err := bucket.PlaceHold(LegalHold{Selector: "service=h1", MinT: theCurrentTime - 9*secondsInADay, MaxT: theCurrentTime - 7*secondsInADay}, theCurrentTime)
+		assert.NoError(t, err)
+		config := config
+		config.Policies = append(config.Policies, PerSeriesRetentionPolicy{RetentionPeriod: 6 * secondsInADay, Policy: "service=h2"})
+
+		// service=h2 is dropped, service=h1 stays and its policy is not recorded
+		actions := PlanBucketRetention(config, bucket, theCurrentTime)
+		assert.Equal(t, []string{"service=h1"}, actions[0].HeldSeries)
+		assert.Equal(t, map[string]int64{"service=h2": 1}, actions[0].SeriesDropped)
+		held := PlanHeldActions(config, bucket, theCurrentTime)
+		assert.Equal(t, []string{"service=h1"}, held[0].Series)
+		assert.Equal(t, map[string]int64{"service=h1": 1}, held[0].SeriesDropped)
+		assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime))
+		b := bucket.Blocks[0]
+		assert.Equal(t, map[string]interface{}{"service=h1": nil, "name=ying": nil}, b.Series)
+		assert.Equal(t, []string{hashPolicy("service=h2")}, b.MetaData.DropPolicies)
+		assert.Empty(t, AuditBucket(config, bucket, theCurrentTime, 0))
+
+		// the block is left alone while the hold lasts, and its held series dropped
+		// once the hold is released
+		for _, a := range PlanBucketRetention(config, bucket, theCurrentTime+secondsInADay) {
+			assert.NotEqual(t, 1, a.BlockID)
+		}
+		assert.NoError(t, bucket.ReleaseHold("hold-1"))
+		assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime+secondsInADay))
+		assert.Equal(t, map[string]interface{}{"name=ying": nil}, bucket.Blocks[0].Series)
+		assert.Equal(t, 2, bucket.Blocks[0].Retained)
+	})
+
+	t.Run("series hold without time range", func(t *testing.T) {
+		bucket := newBucket()
+		h, err := bucket.PlaceHold(LegalHold{Selector: "service=h1"}, theCurrentTime)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(math.MaxInt64), h.MaxT)
+		// the held series outlives its retention in every block
+		assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime))
+		assert.Equal(t, series, bucket.Blocks[0].Series)
+		assert.False(t, bucket.Blocks[1].Deleted)
+		assert.Equal(t, map[string]interface{}{"service=h1": nil}, bucket.Blocks[1].Series)
+		assert.False(t, bucket.Blocks[2].Deleted)
+	})
+
+	t.Run("series hold not matching the block", func(t *testing.T) {
+		bucket := newBucket()
+		_, err := bucket.PlaceHold(LegalHold{Selector: "service=h9", MinT: theCurrentTime - 40*secondsInADay, MaxT: theCurrentTime}, theCurrentTime)
+		assert.NoError(t, err)
+		assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime))
+		assert.True(t, bucket.Blocks[1].Deleted)
+		// series of block 3 are unknown, it might hold service=h9
+		assert.False(t, bucket.Blocks[2].Deleted)
+	})
+
+	t.Run("expired hold", func(t *testing.T) {
+		bucket := newBucket()
+		_, err := bucket.PlaceHold(LegalHold{BlockIDs: []int{2}, ExpiresAt: theCurrentTime + secondsInADay}, theCurrentTime)
+		assert.NoError(t, err)
+		assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime))
+		assert.False(t, bucket.Blocks[1].Deleted)
+		assert.True(t, bucket.Blocks[2].Deleted)
+		assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime+secondsInADay))
+		assert.True(t, bucket.Blocks[1].Deleted)
+	})
+
+	t.Run("hold placed after planning", func(t *testing.T) {
+		bucket := newBucket()
+		actions := PlanBucketRetention(config, bucket, theCurrentTime)
+		_, err := bucket.PlaceHold(LegalHold{BlockIDs: []int{1, 2, 3}}, theCurrentTime)
+		assert.NoError(t, err)
+		assert.NoError(t, ApplyPlan(config, bucket, actions, theCurrentTime))
+		assert.Equal(t, newBucket().Blocks, bucket.Blocks)
+	})
+}
+
+func TestAuditBucketWithSeriesHold(t *testing.T) {
+	config := UserConfig{BaseRetention: 10 * secondsInADay}
+	bucket := &Bucket{Blocks: []Block{{ID: 1, MaxT: theCurrentTime - 11*secondsInADay, Series: map[string]interface{}{"service=h1": nil, "service=h2": nil}}}}
+	_, err := bucket.PlaceHold(LegalHold{Selector: "service=h1", MaxT: theCurrentTime}, theCurrentTime)
+	assert.NoError(t, err)
+
+	// the hold on service=h1 does not keep service=h2 past its retention
+	assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime))
+	assert.Equal(t, map[string]interface{}{"service=h1": nil}, bucket.Blocks[0].Series)
+	assert.Equal(t, []Violation{}, AuditBucket(config, bucket, theCurrentTime, 0))
+}
+
+func TestAuditBucketSkipsHeldSeries(t *testing.T) {
+	config := UserConfig{BaseRetention: 10 * secondsInADay}
+	bucket := &Bucket{Blocks: []Block{{ID: 1, MaxT: theCurrentTime - 11*secondsInADay, Series: map[string]interface{}{"service=h1": nil}}}}
+	assert.Equal(t, 1, len(AuditBucket(config, bucket, theCurrentTime, 0)))
+	_, err := bucket.PlaceHold(LegalHold{BlockIDs: []int{1}}, theCurrentTime)
+	assert.NoError(t, err)
+	assert.Equal(t, []Violation{}, AuditBucket(config, bucket, theCurrentTime, 0))
+}
@@ -0,0 +1,67 @@
+package toyRetention
+
+import "fmt"
+
+// BlockEvent describes an action the engine is about to commit on a block.
+type BlockEvent struct {
+	Tenant      string
+	Config      UserConfig
+	CurrentTime int64
+	// Block is the state the action is applied on.
+	Block  Block
+	Action Action
+}
+
+// PoliciesAppliedEvent describes a finished retention run.
+type PoliciesAppliedEvent struct {
+	Tenant      string
+	Config      UserConfig
+	CurrentTime int64
+	Result      RunResult
+}
+
+// Hooks let callers react to retention removing data. They are invoked synchronously
+// by the engine, and a block hook returning an error vetoes that action: the block is
+// left untouched and the run goes on with the next one. A block hook is called once
+// per change, right before it is written, while other writes to the bucket wait; it
+// must not write to the bucket itself.
+type Hooks interface {
+	OnBlockMarkedForDeletion(BlockEvent) error
+	OnBlockRewritten(BlockEvent) error
+	// OnPoliciesApplied is called once the run is done, an error fails the run.
+	OnPoliciesApplied(PoliciesAppliedEvent) error
+}
+
+// NopHooks implements Hooks doing nothing, embed it to only implement some of them.
+type NopHooks struct{}
+
+func (NopHooks) OnBlockMarkedForDeletion(BlockEvent) error    { return nil }
+func (NopHooks) OnBlockRewritten(BlockEvent) error            { return nil }
+func (NopHooks) OnPoliciesApplied(PoliciesAppliedEvent) error { return nil }
+
+// VetoError is returned when a hook refused an action.
+type VetoError struct {
+	BlockID int
+	Err     error
+}
+
+func (e *VetoError) Error() string {
+	return fmt.Sprintf("action on block %d vetoed: %v", e.BlockID, e.Err)
+}
+
+func (e *VetoError) Unwrap() error {
+	return e.Err
+}
+
+func callBlockHook(hooks Hooks, event BlockEvent) error {
+	var err error
+	if event.Action.Kind == ActionDelete {
+		err = hooks.OnBlockMarkedForDeletion(event)
+	} else {
+		err = hooks.OnBlockRewritten(event)
+	}
+	if err != nil {
+		return &VetoError{BlockID: event.Block.ID, Err: err}
+	}
+	return nil
+}
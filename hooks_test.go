@@ -0,0 +1,124 @@
+package toyRetention
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingHooks struct {
+	NopHooks
+	deleted   []BlockEvent
+	rewritten []BlockEvent
+	runs      []PoliciesAppliedEvent
+	veto      map[int]error
+}
+
+func (h *recordingHooks) OnBlockMarkedForDeletion(e BlockEvent) error {
+	h.deleted = append(h.deleted, e)
+	return h.veto[e.Block.ID]
+}
+
+func (h *recordingHooks) OnBlockRewritten(e BlockEvent) error {
+	h.rewritten = append(h.rewritten, e)
+	return h.veto[e.Block.ID]
+}
+
+func (h *recordingHooks) OnPoliciesApplied(e PoliciesAppliedEvent) error {
+	h.runs = append(h.runs, e)
+	return nil
+}
+
+func TestEngineRunCallsHooks(t *testing.T) {
+	config := UserConfig{
+		BaseRetention: 10 * secondsInADay,
+		Policies:      []PerSeriesRetentionPolicy{{RetentionPeriod: 5 * secondsInADay, Policy: "service=h1"}},
+	}
+	bucket := &Bucket{
+		Tenant: "team-a",
+		Blocks: []Block{
+			{ID: 1, MaxT: theCurrentTime - 6*secondsInADay},
+			{ID: 2, MaxT: theCurrentTime - 20*secondsInADay},
+			{ID: 3, MaxT: theCurrentTime - 20*secondsInADay},
+		},
+	}
+	hooks := &recordingHooks{veto: map[int]error{3: errors.New("cache not invalidated yet")}}
+	engine := NewEngine("compactor-1")
+	engine.Hooks = hooks
+
+	result, err := engine.Run(config, bucket, theCurrentTime)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, len(hooks.rewritten))
+	assert.Equal(t, "team-a", hooks.rewritten[0].Tenant)
+	assert.Equal(t, 1, hooks.rewritten[0].Block.ID)
+	assert.Equal(t, 0, hooks.rewritten[0].Block.Retained)
+	assert.Equal(t, []string{"service=h1"}, hooks.rewritten[0].Action.DropPolicies)
+	assert.Equal(t, 2, len(hooks.deleted))
+
+	// the vetoed block is left alone
+	assert.Equal(t, true, bucket.Blocks[1].Deleted)
+	assert.Equal(t, false, bucket.Blocks[2].Deleted)
+	assert.Equal(t, []int{3}, blockIDs(result.Vetoed))
+	assert.ElementsMatch(t, []int{1, 2}, blockIDs(result.Applied))
+
+	assert.Equal(t, 1, len(hooks.runs))
+	assert.Equal(t, result, hooks.runs[0].Result)
+}
+
+type failingRunHooks struct {
+	NopHooks
+}
+
+func (failingRunHooks) OnPoliciesApplied(PoliciesAppliedEvent) error {
+	return errors.New("owner notification failed")
+}
+
+func TestEngineRunFailsWhenOnPoliciesAppliedFails(t *testing.T) {
+	reg := NewRegistry()
+	engine := NewEngine("compactor-1")
+	engine.Hooks = failingRunHooks{}
+	engine.Metrics = NewRetentionMetrics(reg)
+
+	_, err := engine.Run(UserConfig{BaseRetention: secondsInADay}, &Bucket{Tenant: "team-a"}, theCurrentTime)
+	assert.EqualError(t, err, "owner notification failed")
+	assert.Equal(t, float64(0), reg.Value("toyretention_last_successful_run_timestamp_seconds", "team-a"))
+}
+
+// racingHooks writes to the block while its rewrite is being approved.
+type racingHooks struct {
+	NopHooks
+	bucket    *Bucket
+	rewritten []BlockEvent
+	raced     chan error
+}
+
+func (h *racingHooks) OnBlockRewritten(e BlockEvent) error {
+	h.rewritten = append(h.rewritten, e)
+	go func() {
+		b := e.Block
+		b.Retained = 10
+		h.raced <- h.bucket.WriteBlock(0, b, e.Block.MetaData.Generation)
+	}()
+	return nil
+}
+
+func TestEngineRunApprovesTheWrittenAction(t *testing.T) {
+	config := UserConfig{
+		BaseRetention: 10 * secondsInADay,
+		Policies:      []PerSeriesRetentionPolicy{{RetentionPeriod: 5 * secondsInADay, Policy: "service=h1"}},
+	}
+	bucket := &Bucket{Tenant: "team-a", Blocks: []Block{{ID: 1, MaxT: theCurrentTime - 6*secondsInADay}}}
+	hooks := &racingHooks{bucket: bucket, raced: make(chan error, 1)}
+	engine := NewEngine("compactor-1")
+	engine.Hooks = hooks
+
+	// the approved rewrite is written before anything else, and the hook is asked once
+	result, err := engine.Run(config, bucket, theCurrentTime)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1}, blockIDs(result.Applied))
+	assert.Equal(t, ErrConflict, <-hooks.raced)
+	assert.Equal(t, 1, len(hooks.rewritten))
+	assert.Equal(t, 1, bucket.Blocks[0].Retained)
+}
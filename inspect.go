@@ -0,0 +1,144 @@
+package toyRetention
+
+import (
+	"sort"
+	"strings"
+)
+
+// Ways a block can be out of sync with a configured policy.
+const (
+	SyncDropPending  = "expired but not dropped yet"
+	SyncKeepMissing  = "kept but not in the recorded keep set"
+	SyncKeepExpired  = "expired but still in the recorded keep set"
+	SyncBlockExpired = "expired along with the whole block"
+)
+
+// BlockInspection is a human readable view of a block's retention state.
+type BlockInspection struct {
+	ID       int   `json:"id"`
+	MinT     int64 `json:"min_time"`
+	MaxT     int64 `json:"max_time"`
+	Retained int   `json:"retained"`
+	Deleted  bool  `json:"deleted"`
+	// DropPolicies are the decoded drop policies applied to the block.
+	DropPolicies []string `json:"drop_policies"`
+	// DropPolicyStates tells for each of DropPolicies when it was applied and whether
+	// it is still configured.
+	DropPolicyStates []DropPolicyStatus `json:"drop_policy_states"`
+	// KeepPolicyHistory is every keep set the block metadata remembers, oldest first
+	// and ending with the current one.
+	KeepPolicyHistory [][]string `json:"keep_policy_history"`
+	// KeepPolicyRewrites counts every keep set the block was rewritten with, including
+	// those dropped from the history.
+	KeepPolicyRewrites int `json:"keep_policy_rewrites"`
+	// DeletionRequests are the IDs of the deletion requests carried out on the block.
+	DeletionRequests []string `json:"deletion_requests"`
+	// Resolution is the resolution of the whole block in seconds, 0 for raw data.
+	Resolution int64 `json:"resolution"`
+	// Downsampled maps the policies whose series were downsampled further than the
+	// whole block to their resolution in seconds.
+	Downsampled map[string]int64 `json:"downsampled"`
+	// Holds are the IDs of the active legal holds protecting data of the block.
+	Holds []string `json:"holds"`
+	// OutOfSync lists the configured policies the block does not reflect yet.
+	OutOfSync []PolicySync `json:"out_of_sync"`
+}
+
+type PolicySync struct {
+	Policy string `json:"policy"`
+	State  string `json:"state"`
+}
+
+// InspectBlock decodes the retention metadata of a block and compares it with the
+// configured policies at currentTime.
+func InspectBlock(b Block, config UserConfig, currentTime int64) BlockInspection {
+	return inspectBlock(b, config, nil, currentTime)
+}
+
+// InspectBucketBlock is InspectBlock for a block of the bucket, also listing the
+// legal holds covering it.
+func InspectBucketBlock(userBucket *Bucket, i int, config UserConfig, currentTime int64) BlockInspection {
+	return inspectBlock(userBucket.ReadBlock(i), config, userBucket.ActiveHolds(currentTime), currentTime)
+}
+
+func inspectBlock(b Block, config UserConfig, holds []LegalHold, currentTime int64) BlockInspection {
+	in := BlockInspection{
+		ID:                b.ID,
+		MinT:              b.MinT,
+		MaxT:              b.MaxT,
+		Retained:          b.Retained,
+		Deleted:           b.Deleted,
+		DropPolicies:      []string{},
+		KeepPolicyHistory: [][]string{},
+		DeletionRequests:  append([]string{}, b.MetaData.DeletionRequests...),
+		Resolution:        b.MetaData.Resolution,
+		Downsampled:       map[string]int64{},
+		Holds:             holdsCovering(holds, b),
+		OutOfSync:         []PolicySync{},
+	}
+	in.KeepPolicyRewrites = keepPolicyRewrites(b.MetaData)
+	in.DropPolicyStates = dropPolicyStatuses(b, config)
+	for _, dp := range b.MetaData.DropPolicies {
+		in.DropPolicies = append(in.DropPolicies, decodePolicy(dp))
+	}
+	for _, r := range b.MetaData.DownsampleLog {
+		if r.Fingerprint != "" && r.Resolution > b.MetaData.Resolution {
+			policy := decodePolicy(r.Fingerprint)
+			in.Downsampled[policy] = policyResolution(b.MetaData, policy)
+		}
+	}
+	for _, kp := range keepPolicyFingerprints(b.MetaData) {
+		in.KeepPolicyHistory = append(in.KeepPolicyHistory, splitKeepSet(decodePolicy(kp)))
+	}
+	if !b.Deleted {
+		in.OutOfSync = outOfSyncPolicies(b, in, config, currentTime)
+	}
+	return in
+}
+
+func outOfSyncPolicies(b Block, in BlockInspection, config UserConfig, currentTime int64) []PolicySync {
+	out := []PolicySync{}
+	config = config.atResolution(b.MetaData.Resolution)
+	_, maxRetention := getRetentionPeriodRange(config.Policies, config.BaseRetention)
+	if isBlockRetentionPassed(b.MaxT, currentTime, maxRetention) {
+		for _, p := range config.Policies {
+			out = append(out, PolicySync{Policy: p.Policy, State: SyncBlockExpired})
+		}
+		return out
+	}
+
+	dropPolicies, keepPolicies := buildPolicy(b, config, currentTime)
+	for _, dp := range dropPolicies {
+		if !containsString(b.MetaData.DropPolicies, hashPolicy(dp)) {
+			out = append(out, PolicySync{Policy: dp, State: SyncDropPending})
+		}
+	}
+	if !isBlockRetentionPassed(b.MaxT, currentTime, config.BaseRetention) {
+		return out
+	}
+	recorded := []string{}
+	if len(in.KeepPolicyHistory) > 0 {
+		recorded = in.KeepPolicyHistory[len(in.KeepPolicyHistory)-1]
+	}
+	for _, kp := range keepPolicies {
+		if !containsString(recorded, kp) {
+			out = append(out, PolicySync{Policy: kp, State: SyncKeepMissing})
+		}
+	}
+	for _, p := range config.Policies {
+		if containsString(recorded, p.Policy) && !containsString(keepPolicies, p.Policy) {
+			out = append(out, PolicySync{Policy: p.Policy, State: SyncKeepExpired})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Policy < out[j].Policy
+	})
+	return out
+}
+
+func splitKeepSet(keepSet string) []string {
+	if keepSet == "" {
+		return []string{}
+	}
+	return strings.Split(keepSet, ";")
+}
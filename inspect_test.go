@@ -0,0 +1,68 @@
+package toyRetention
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInspectBlock(t *testing.T) {
+	config := UserConfig{
+		BaseRetention: 10 * secondsInADay,
+		Policies: []PerSeriesRetentionPolicy{
+			{RetentionPeriod: 5 * secondsInADay, Policy: "service=h1"},
+			{RetentionPeriod: 6 * secondsInADay, Policy: "service=h2"},
+			{RetentionPeriod: 15 * secondsInADay, Policy: "namespace=b1"},
+			{RetentionPeriod: 20 * secondsInADay, Policy: "name=ying"},
+		},
+	}
+	b := Block{
+		ID:       1,
+		MinT:     theCurrentTime - 17*secondsInADay,
+		MaxT:     theCurrentTime - 16*secondsInADay,
+		Retained: 3,
+		MetaData: MetaData{
+			DropPolicies: []string{hashPolicy("service=h1"), hashPolicy("service=h0")},
+			KeepPolicies: []string{hashPolicy("name=ying;namespace=b0"), hashPolicy("name=ying;namespace=b1")},
+			DropPolicyLog: []DropPolicyRecord{
+				{Fingerprint: hashPolicy("service=h0"), AppliedAt: theCurrentTime - 10*secondsInADay, DataDropped: true, SeriesDropped: 4},
+			},
+		},
+	}
+
+	in := InspectBlock(b, config, theCurrentTime)
+	assert.Equal(t, BlockInspection{
+		ID:           1,
+		MinT:         b.MinT,
+		MaxT:         b.MaxT,
+		Retained:     3,
+		DropPolicies: []string{"service=h1", "service=h0"},
+		DropPolicyStates: []DropPolicyStatus{
+			{BlockID: 1, Policy: "service=h1", State: DropStateApplied},
+			{BlockID: 1, Policy: "service=h0", AppliedAt: theCurrentTime - 10*secondsInADay, DataDropped: true, SeriesDropped: 4, State: DropStateRemovedAfterDrop},
+		},
+		KeepPolicyHistory:  [][]string{{"name=ying", "namespace=b0"}, {"name=ying", "namespace=b1"}},
+		KeepPolicyRewrites: 2,
+		DeletionRequests:   []string{},
+		Downsampled:        map[string]int64{},
+		Holds:              []string{},
+		OutOfSync: []PolicySync{
+			{Policy: "namespace=b1", State: SyncKeepExpired},
+			{Policy: "service=h2", State: SyncDropPending},
+		},
+	}, in)
+
+	// once every policy expired the whole block is due
+	in = InspectBlock(b, config, theCurrentTime+10*secondsInADay)
+	assert.Equal(t, 4, len(in.OutOfSync))
+	assert.Equal(t, SyncBlockExpired, in.OutOfSync[0].State)
+
+	// a block past base retention without a recorded keep set misses its keep policies
+	in = InspectBlock(Block{MaxT: theCurrentTime - 11*secondsInADay}, config, theCurrentTime)
+	assert.Equal(t, []PolicySync{
+		{Policy: "name=ying", State: SyncKeepMissing},
+		{Policy: "namespace=b1", State: SyncKeepMissing},
+		{Policy: "service=h1", State: SyncDropPending},
+		{Policy: "service=h2", State: SyncDropPending},
+	}, in.OutOfSync)
+}
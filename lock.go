@@ -0,0 +1,73 @@
+package toyRetention
+
+import (
+	"errors"
+	"fmt"
+)
+
+// BucketLock is the lease object stored in a bucket while a retention run owns it.
+type BucketLock struct {
+	Owner     string
+	ExpiresAt int64
+	RenewedAt int64
+}
+
+// LockHeldError is returned when another owner holds a lock that has not expired yet.
+type LockHeldError struct {
+	Owner     string
+	ExpiresAt int64
+}
+
+func (e *LockHeldError) Error() string {
+	return fmt.Sprintf("bucket is locked by %q until %d", e.Owner, e.ExpiresAt)
+}
+
+// ErrLockLost is returned when a lease was taken over or released behind our back.
+var ErrLockLost = errors.New("bucket lock is no longer held by this owner")
+
+// Lease is a held bucket lock. It must be renewed before it expires and released
+// once the run is over.
+type Lease struct {
+	bucket *Bucket
+	owner  string
+	ttl    int64
+}
+
+// AcquireBucketLock takes the bucket lock for owner. A lock that expired without being
+// renewed is considered stale and is taken over; re-acquiring our own lock renews it.
+func AcquireBucketLock(userBucket *Bucket, owner string, ttl int64, currentTime int64) (*Lease, error) {
+	userBucket.mu.Lock()
+	defer userBucket.mu.Unlock()
+
+	if l := userBucket.Lock; l != nil && l.Owner != owner && l.ExpiresAt > currentTime {
+		return nil, &LockHeldError{Owner: l.Owner, ExpiresAt: l.ExpiresAt}
+	}
+	userBucket.Lock = &BucketLock{Owner: owner, ExpiresAt: currentTime + ttl, RenewedAt: currentTime}
+	return &Lease{bucket: userBucket, owner: owner, ttl: ttl}, nil
+}
+
+// Renew is the lease heartbeat, it pushes the expiry ttl past currentTime.
+func (l *Lease) Renew(currentTime int64) error {
+	l.bucket.mu.Lock()
+	defer l.bucket.mu.Unlock()
+
+	lock := l.bucket.Lock
+	if lock == nil || lock.Owner != l.owner {
+		return ErrLockLost
+	}
+	lock.ExpiresAt = currentTime + l.ttl
+	lock.RenewedAt = currentTime
+	return nil
+}
+
+// Release removes the lock from the bucket if we still own it.
+func (l *Lease) Release() error {
+	l.bucket.mu.Lock()
+	defer l.bucket.mu.Unlock()
+
+	if l.bucket.Lock == nil || l.bucket.Lock.Owner != l.owner {
+		return ErrLockLost
+	}
+	l.bucket.Lock = nil
+	return nil
+}
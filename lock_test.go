@@ -0,0 +1,35 @@
+package toyRetention
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcquireBucketLock(t *testing.T) {
+	bucket := &Bucket{}
+	ttl := int64(60)
+
+	lease, err := AcquireBucketLock(bucket, "compactor-1", ttl, theCurrentTime)
+	assert.NoError(t, err)
+	assert.Equal(t, &BucketLock{Owner: "compactor-1", ExpiresAt: theCurrentTime + ttl, RenewedAt: theCurrentTime}, bucket.Lock)
+
+	// another owner cannot take a live lock
+	_, err = AcquireBucketLock(bucket, "compactor-2", ttl, theCurrentTime+30)
+	assert.Equal(t, &LockHeldError{Owner: "compactor-1", ExpiresAt: theCurrentTime + ttl}, err)
+
+	// heartbeat keeps it alive
+	assert.NoError(t, lease.Renew(theCurrentTime+50))
+	_, err = AcquireBucketLock(bucket, "compactor-2", ttl, theCurrentTime+70)
+	assert.Error(t, err)
+
+	// a stale lock is taken over, the previous owner finds out on its next heartbeat
+	other, err := AcquireBucketLock(bucket, "compactor-2", ttl, theCurrentTime+200)
+	assert.NoError(t, err)
+	assert.Equal(t, "compactor-2", bucket.Lock.Owner)
+	assert.Equal(t, ErrLockLost, lease.Renew(theCurrentTime+201))
+	assert.Equal(t, ErrLockLost, lease.Release())
+
+	assert.NoError(t, other.Release())
+	assert.Nil(t, bucket.Lock)
+}
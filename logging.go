@@ -0,0 +1,49 @@
+package toyRetention
+
+// Logger receives structured records as a message followed by alternating keys and
+// values. It is satisfied by *slog.Logger.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any) {}
+func (nopLogger) Info(string, ...any)  {}
+
+// Actions reported in decision records besides ActionKind's.
+const (
+	decisionNone    = "none"
+	decisionDefer   = "defer"
+	decisionVetoed  = "vetoed"
+	decisionHeld    = "held"
+	decisionPending = "pending"
+)
+
+const decisionMessage = "retention decision"
+
+// decisionArgs builds the key/values describing a decision on a block, along with
+// the cutoffs it was taken against at the resolution of the block: a block whose max
+// time is at or before a cutoff has passed the matching retention.
+func decisionArgs(tenant string, config UserConfig, b Block, action string, a Action, currentTime int64) []any {
+	config = config.atResolution(b.MetaData.Resolution)
+	minRetention, maxRetention := getRetentionPeriodRange(config.Policies, config.BaseRetention)
+	args := []any{
+		"tenant", tenant,
+		"block", b.ID,
+		"action", action,
+		"block_max_time", b.MaxT,
+		"base_cutoff", currentTime - config.BaseRetention,
+		"min_cutoff", currentTime - minRetention,
+		"max_cutoff", currentTime - maxRetention,
+	}
+	if action == decisionNone {
+		return args
+	}
+	args = append(args, "reasons", a.Reasons())
+	if a.Kind == ActionRewrite {
+		args = append(args, "drop_policies", a.DropPolicies, "keep_policies", a.KeepPolicies, "estimated_bytes", a.EstimatedBytes)
+	}
+	return args
+}
@@ -0,0 +1,117 @@
+package toyRetention
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type logRecord struct {
+	level string
+	msg   string
+	args  map[string]any
+}
+
+type recordingLogger struct {
+	records []logRecord
+}
+
+func (l *recordingLogger) Debug(msg string, args ...any) { l.record("debug", msg, args) }
+func (l *recordingLogger) Info(msg string, args ...any)  { l.record("info", msg, args) }
+
+func (l *recordingLogger) record(level string, msg string, args []any) {
+	r := logRecord{level: level, msg: msg, args: map[string]any{}}
+	for i := 0; i+1 < len(args); i += 2 {
+		r.args[args[i].(string)] = args[i+1]
+	}
+	l.records = append(l.records, r)
+}
+
+func TestEngineRunLogsDecisions(t *testing.T) {
+	config := UserConfig{
+		BaseRetention: 10 * secondsInADay,
+		Policies: []PerSeriesRetentionPolicy{
+			{RetentionPeriod: 5 * secondsInADay, Policy: "service=h1"},
+			{RetentionPeriod: 20 * secondsInADay, Policy: "name=ying"},
+		},
+	}
+	bucket := &Bucket{
+		Tenant: "team-a",
+		Blocks: []Block{
+			{ID: 1, MaxT: theCurrentTime - 2*secondsInADay},
+			{ID: 2, MaxT: theCurrentTime - 12*secondsInADay},
+			{ID: 3, MaxT: theCurrentTime - 30*secondsInADay},
+			{ID: 4, MaxT: theCurrentTime - 7*secondsInADay},
+		},
+	}
+	logger := &recordingLogger{}
+	engine := NewEngine("compactor-1")
+	engine.Logger = logger
+	engine.RewriteBudget = 1
+
+	_, err := engine.Run(config, bucket, theCurrentTime)
+	assert.NoError(t, err)
+
+	byBlock := map[int]logRecord{}
+	for _, r := range logger.records {
+		assert.Equal(t, decisionMessage, r.msg)
+		assert.Equal(t, "team-a", r.args["tenant"])
+		byBlock[r.args["block"].(int)] = r
+	}
+	assert.Equal(t, 4, len(byBlock))
+
+	assert.Equal(t, "debug", byBlock[1].level)
+	assert.Equal(t, decisionNone, byBlock[1].args["action"])
+
+	// both rewrites have the same estimated savings, the most overdue goes first
+	assert.Equal(t, "info", byBlock[2].level)
+	assert.Equal(t, "rewrite", byBlock[2].args["action"])
+	assert.Equal(t, []string{ReasonDropPoliciesChanged, ReasonKeepPoliciesChanged}, byBlock[2].args["reasons"])
+	assert.Equal(t, []string{"service=h1"}, byBlock[2].args["drop_policies"])
+	assert.Equal(t, []string{"name=ying"}, byBlock[2].args["keep_policies"])
+	assert.Equal(t, theCurrentTime-10*secondsInADay, byBlock[2].args["base_cutoff"])
+
+	assert.Equal(t, "delete", byBlock[3].args["action"])
+	assert.Equal(t, []string{ReasonRetentionPassed}, byBlock[3].args["reasons"])
+	assert.Equal(t, theCurrentTime-20*secondsInADay, byBlock[3].args["max_cutoff"])
+
+	assert.Equal(t, decisionDefer, byBlock[4].args["action"])
+}
+
+func TestEngineRunLogsCutoffsAtBlockResolution(t *testing.T) {
+	config := UserConfig{
+		BaseRetention:             10 * secondsInADay,
+		BaseRetentionByResolution: map[int64]int64{300: 30 * secondsInADay},
+		Policies: []PerSeriesRetentionPolicy{
+			{RetentionPeriod: 5 * secondsInADay, Policy: "service=h1", RetentionByResolution: map[int64]int64{300: 15 * secondsInADay}},
+		},
+		Downsample: []DownsampleRule{{After: 2 * secondsInADay, Resolution: 300}},
+	}
+	bucket := &Bucket{
+		Tenant: "team-a",
+		Blocks: []Block{
+			{ID: 1, MaxT: theCurrentTime - secondsInADay},
+			{ID: 2, MaxT: theCurrentTime - secondsInADay, MetaData: MetaData{Resolution: 300}},
+			// downsampled by the run, the decision is still taken at raw resolution
+			{ID: 3, MaxT: theCurrentTime - 3*secondsInADay},
+		},
+	}
+	logger := &recordingLogger{}
+	engine := NewEngine("compactor-1")
+	engine.Logger = logger
+
+	_, err := engine.Run(config, bucket, theCurrentTime)
+	assert.NoError(t, err)
+
+	byBlock := map[int]logRecord{}
+	for _, r := range logger.records {
+		byBlock[r.args["block"].(int)] = r
+	}
+	assert.Equal(t, theCurrentTime-10*secondsInADay, byBlock[1].args["base_cutoff"])
+	assert.Equal(t, theCurrentTime-5*secondsInADay, byBlock[1].args["min_cutoff"])
+	assert.Equal(t, theCurrentTime-30*secondsInADay, byBlock[2].args["base_cutoff"])
+	assert.Equal(t, theCurrentTime-15*secondsInADay, byBlock[2].args["min_cutoff"])
+	assert.Equal(t, theCurrentTime-30*secondsInADay, byBlock[2].args["max_cutoff"])
+	assert.Equal(t, "rewrite", byBlock[3].args["action"])
+	assert.Equal(t, theCurrentTime-10*secondsInADay, byBlock[3].args["base_cutoff"])
+}
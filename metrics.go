@@ -0,0 +1,178 @@
+package toyRetention
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type metricType string
+
+const (
+	counterType metricType = "counter"
+	gaugeType   metricType = "gauge"
+)
+
+// Registry holds metrics and renders them in the Prometheus text exposition format.
+type Registry struct {
+	mu       sync.Mutex
+	families []*metricFamily
+}
+
+type metricFamily struct {
+	name       string
+	help       string
+	typ        metricType
+	labelNames []string
+	// values are keyed by the label values joined with labelSeparator.
+	values map[string]float64
+}
+
+const labelSeparator = "\xff"
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Counter is a monotonically increasing metric, partitioned by label values.
+type Counter struct {
+	registry *Registry
+	family   *metricFamily
+}
+
+// Gauge is a metric that can be set to any value, partitioned by label values.
+type Gauge struct {
+	registry *Registry
+	family   *metricFamily
+}
+
+func (r *Registry) NewCounter(name string, help string, labelNames ...string) *Counter {
+	return &Counter{registry: r, family: r.register(name, help, counterType, labelNames)}
+}
+
+func (r *Registry) NewGauge(name string, help string, labelNames ...string) *Gauge {
+	return &Gauge{registry: r, family: r.register(name, help, gaugeType, labelNames)}
+}
+
+func (r *Registry) register(name string, help string, typ metricType, labelNames []string) *metricFamily {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, f := range r.families {
+		if f.name == name {
+			panic(fmt.Sprintf("metric %q registered twice", name))
+		}
+	}
+	f := &metricFamily{name: name, help: help, typ: typ, labelNames: labelNames, values: map[string]float64{}}
+	r.families = append(r.families, f)
+	return f
+}
+
+func (f *metricFamily) key(labelValues []string) string {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metric %q expects %d label values, got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+	return strings.Join(labelValues, labelSeparator)
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases the counter, negative values are ignored.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+	c.registry.mu.Lock()
+	defer c.registry.mu.Unlock()
+	c.family.values[c.family.key(labelValues)] += v
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.registry.mu.Lock()
+	defer g.registry.mu.Unlock()
+	g.family.values[g.family.key(labelValues)] = v
+}
+
+// Value returns the current value for the given label values, mostly useful in tests.
+func (r *Registry) Value(name string, labelValues ...string) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, f := range r.families {
+		if f.name == name {
+			return f.values[f.key(labelValues)]
+		}
+	}
+	return 0
+}
+
+// WriteText renders all metrics in the Prometheus text exposition format, families
+// in registration order and series sorted by label values.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, f := range r.families {
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.name, f.typ)
+		keys := make([]string, 0, len(f.values))
+		for k := range f.values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			bw.WriteString(f.name)
+			if len(f.labelNames) > 0 {
+				pairs := []string{}
+				for i, v := range strings.Split(k, labelSeparator) {
+					pairs = append(pairs, fmt.Sprintf(`%s="%s"`, f.labelNames[i], escapeLabelValue(v)))
+				}
+				bw.WriteString("{" + strings.Join(pairs, ",") + "}")
+			}
+			bw.WriteString(" " + strconv.FormatFloat(f.values[k], 'f', -1, 64) + "\n")
+		}
+	}
+	return bw.Flush()
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := r.WriteText(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+// RetentionMetrics are the metrics the retention engine reports per tenant.
+type RetentionMetrics struct {
+	BlocksEvaluated   *Counter
+	BlocksDeleted     *Counter
+	BlocksRewritten   *Counter
+	SeriesDropped     *Counter
+	RunDuration       *Gauge
+	LastSuccessfulRun *Gauge
+}
+
+func NewRetentionMetrics(r *Registry) *RetentionMetrics {
+	return &RetentionMetrics{
+		BlocksEvaluated:   r.NewCounter("toyretention_blocks_evaluated_total", "Number of blocks evaluated by retention.", "tenant"),
+		BlocksDeleted:     r.NewCounter("toyretention_blocks_deleted_total", "Number of blocks marked for deletion by retention.", "tenant"),
+		BlocksRewritten:   r.NewCounter("toyretention_blocks_rewritten_total", "Number of blocks rewritten by retention, by reason.", "tenant", "reason"),
+		SeriesDropped:     r.NewCounter("toyretention_series_dropped_total", "Number of series dropped from blocks, by the policy that dropped them.", "tenant", "policy"),
+		RunDuration:       r.NewGauge("toyretention_run_duration_seconds", "Duration of the last retention run.", "tenant"),
+		LastSuccessfulRun: r.NewGauge("toyretention_last_successful_run_timestamp_seconds", "Time of the last successful retention run.", "tenant"),
+	}
+}
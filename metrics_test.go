@@ -0,0 +1,78 @@
+package toyRetention
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryWriteText(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.NewCounter("requests_total", "Number of requests.", "tenant", "code")
+	g := reg.NewGauge("temperature", "Current temperature.\nIn celsius.")
+	c.Inc("b", "200")
+	c.Add(2, "a", "500")
+	c.Add(-1, "a", "500")
+	c.Inc("a", `quo"te`)
+	g.Set(21.5)
+
+	buf := bytes.Buffer{}
+	assert.NoError(t, reg.WriteText(&buf))
+	assert.Equal(t, `# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{tenant="a",code="500"} 2
+requests_total{tenant="a",code="quo\"te"} 1
+requests_total{tenant="b",code="200"} 1
+# HELP temperature Current temperature.\nIn celsius.
+# TYPE temperature gauge
+temperature 21.5
+`, buf.String())
+
+	rec := httptest.NewRecorder()
+	reg.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, buf.String(), rec.Body.String())
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+}
+
+func TestEngineRunReportsMetrics(t *testing.T) {
+	config := UserConfig{
+		BaseRetention: 10 * secondsInADay,
+		Policies: []PerSeriesRetentionPolicy{
+			{RetentionPeriod: 5 * secondsInADay, Policy: "service=h1"},
+			{RetentionPeriod: 20 * secondsInADay, Policy: "name=ying"},
+		},
+	}
+	series := map[string]interface{}{"service=h1": nil, "service=h2": nil, "name=ying": nil}
+	bucket := &Bucket{
+		Tenant: "team-a",
+		Blocks: []Block{
+			{ID: 1, MaxT: theCurrentTime - 6*secondsInADay, Series: series},
+			{ID: 2, MaxT: theCurrentTime - 12*secondsInADay, Series: series},
+			{ID: 3, MaxT: theCurrentTime - 30*secondsInADay},
+			{ID: 4, MaxT: theCurrentTime - 30*secondsInADay, Deleted: true},
+		},
+	}
+	reg := NewRegistry()
+	engine := NewEngine("compactor-1")
+	engine.Metrics = NewRetentionMetrics(reg)
+
+	_, err := engine.Run(config, bucket, theCurrentTime)
+	assert.NoError(t, err)
+
+	assert.Equal(t, float64(3), reg.Value("toyretention_blocks_evaluated_total", "team-a"))
+	assert.Equal(t, float64(1), reg.Value("toyretention_blocks_deleted_total", "team-a"))
+	assert.Equal(t, float64(2), reg.Value("toyretention_blocks_rewritten_total", "team-a", ReasonDropPoliciesChanged))
+	assert.Equal(t, float64(1), reg.Value("toyretention_blocks_rewritten_total", "team-a", ReasonKeepPoliciesChanged))
+	assert.Equal(t, float64(2), reg.Value("toyretention_series_dropped_total", "team-a", "service=h1"))
+	assert.Equal(t, float64(1), reg.Value("toyretention_series_dropped_total", "team-a", "default"))
+	assert.Equal(t, float64(theCurrentTime), reg.Value("toyretention_last_successful_run_timestamp_seconds", "team-a"))
+
+	// a failed run does not move the last successful run
+	_, err = AcquireBucketLock(bucket, "compactor-2", defaultLockTTL, theCurrentTime)
+	assert.NoError(t, err)
+	_, err = engine.Run(config, bucket, theCurrentTime+1)
+	assert.Error(t, err)
+	assert.Equal(t, float64(theCurrentTime), reg.Value("toyretention_last_successful_run_timestamp_seconds", "team-a"))
+}
@@ -0,0 +1,390 @@
+package toyRetention
+
+import "fmt"
+
+type ActionKind int
+
+const (
+	ActionRewrite ActionKind = iota
+	ActionDelete
+)
+
+func (k ActionKind) String() string {
+	switch k {
+	case ActionDelete:
+		return "delete"
+	default:
+		return "rewrite"
+	}
+}
+
+func (k ActionKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+func (k *ActionKind) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "delete":
+		*k = ActionDelete
+	case "rewrite":
+		*k = ActionRewrite
+	default:
+		return fmt.Errorf("unknown action kind %q", text)
+	}
+	return nil
+}
+
+// Reasons a block is deleted or rewritten.
+const (
+	ReasonRetentionPassed     = "retention_passed"
+	ReasonDropPoliciesChanged = "drop_policies_changed"
+	ReasonKeepPoliciesChanged = "keep_policies_changed"
+	ReasonDeletionRequested   = "deletion_requested"
+	ReasonDownsampleDue       = "downsample_due"
+	ReasonQuotaExceeded       = "quota_exceeded"
+	ReasonSeriesBudget        = "series_budget_exceeded"
+)
+
+// defaultPolicyName stands for the base retention where a policy name is expected.
+const defaultPolicyName = "default"
+
+// Action is a pending retention change for a single block.
+type Action struct {
+	BlockID           int        `json:"block_id"`
+	Kind              ActionKind `json:"kind"`
+	DropPolicies      []string   `json:"drop_policies,omitempty"`
+	KeepPolicies      []string   `json:"keep_policies,omitempty"`
+	RewriteKeepPolicy bool       `json:"rewrite_keep_policy"`
+	RewriteDropPolicy bool       `json:"rewrite_drop_policy"`
+	// Deadline is the time at which the block became due for this action.
+	Deadline int64 `json:"deadline"`
+	// EstimatedBytes is how many bytes the action is expected to reclaim.
+	EstimatedBytes int64 `json:"estimated_bytes"`
+	// SeriesDropped counts the known series a rewrite removes, by the policy removing
+	// them. Series only kept by base retention are counted under "default".
+	SeriesDropped map[string]int64 `json:"series_dropped,omitempty"`
+	// DeletionRequests are the IDs of the deletion requests the rewrite carries out.
+	DeletionRequests []string `json:"deletion_requests,omitempty"`
+	// PartialDeletions are the DeletionRequests the block lies only partly in: the
+	// rewrite removes the samples of the matching series within their time range and
+	// keeps the series.
+	PartialDeletions []string `json:"partial_deletions,omitempty"`
+	// Resolution is the resolution in seconds the rewrite downsamples the whole block
+	// to, 0 if it does not.
+	Resolution int64 `json:"resolution,omitempty"`
+	// Downsample maps the policies whose series the rewrite downsamples to their new
+	// resolution in seconds.
+	Downsample map[string]int64 `json:"downsample,omitempty"`
+	// Quota is set when the action brings the bucket under UserConfig.MaxBytes, the
+	// rewrite then also dropping QuotaSeries.
+	Quota       bool     `json:"quota,omitempty"`
+	QuotaSeries []string `json:"quota_series,omitempty"`
+	// BudgetSeries are the series the rewrite drops to meet UserConfig.SeriesBudget.
+	BudgetSeries []string `json:"budget_series,omitempty"`
+	// ExpiredSeries are the series the rewrite drops from a block past retention that
+	// legal holds keep from being deleted, leaving the held ones.
+	ExpiredSeries []string `json:"expired_series,omitempty"`
+	// HeldSeries are the series the rewrite leaves although it should remove them,
+	// because legal holds protect them, see withholdHeldSeries.
+	HeldSeries []string `json:"held_series,omitempty"`
+
+	// index of the block in the bucket the action was planned against, and the
+	// block generation it was planned from.
+	index      int
+	generation int64
+	// deletions are the DeletionRequests, by ID.
+	deletions map[string]DeletionRequest
+}
+
+// Reasons explains why the action is needed.
+func (a Action) Reasons() []string {
+	if a.Kind == ActionDelete {
+		if a.Quota {
+			return []string{ReasonQuotaExceeded}
+		}
+		return []string{ReasonRetentionPassed}
+	}
+	reasons := []string{}
+	if len(a.ExpiredSeries) > 0 {
+		reasons = append(reasons, ReasonRetentionPassed)
+	}
+	if a.RewriteDropPolicy {
+		reasons = append(reasons, ReasonDropPoliciesChanged)
+	}
+	if a.RewriteKeepPolicy {
+		reasons = append(reasons, ReasonKeepPoliciesChanged)
+	}
+	if len(a.DeletionRequests) > 0 {
+		reasons = append(reasons, ReasonDeletionRequested)
+	}
+	if a.Resolution > 0 || len(a.Downsample) > 0 {
+		reasons = append(reasons, ReasonDownsampleDue)
+	}
+	if len(a.BudgetSeries) > 0 {
+		reasons = append(reasons, ReasonSeriesBudget)
+	}
+	if a.Quota {
+		reasons = append(reasons, ReasonQuotaExceeded)
+	}
+	return reasons
+}
+
+// Overdue returns how long past its deadline the action is at the given time.
+func (a Action) Overdue(currentTime int64) int64 {
+	return currentTime - a.Deadline
+}
+
+// PlanBucketRetention returns the actions ApplyBucketRetention would take, without
+// touching the bucket, including those enforcing the size quota. Actions that would
+// remove data under a legal hold are left out, see PlanHeldActions.
+func PlanBucketRetention(policies UserConfig, userBucket *Bucket, currentTime int64) []Action {
+	return planBucket(policies, userBucket, currentTime).actions
+}
+
+// RetentionPlan is everything planning a bucket yields, for callers reporting on more
+// than its actions.
+type RetentionPlan struct {
+	Actions      []Action
+	Held         []HeldAction
+	Pending      []PendingRewrite
+	Quota        QuotaReport
+	SeriesBudget SeriesBudgetReport
+}
+
+// PlanRetention plans the bucket once and returns what PlanBucketRetention,
+// PlanHeldActions, PlanPendingRewrites, PlanQuota and PlanSeriesBudget would.
+func PlanRetention(policies UserConfig, userBucket *Bucket, currentTime int64) RetentionPlan {
+	plan := planBucket(policies, userBucket, currentTime)
+	return RetentionPlan{Actions: plan.actions, Held: plan.held, Pending: plan.pending, Quota: plan.quota, SeriesBudget: plan.seriesBudget}
+}
+
+// bucketPlan is what planning a bucket yields besides its actions.
+type bucketPlan struct {
+	actions      []Action
+	held         []HeldAction
+	seriesBudget SeriesBudgetReport
+	quota        QuotaReport
+	// pending are the rewrites coalescing holds back.
+	pending []PendingRewrite
+}
+
+func planBucket(policies UserConfig, userBucket *Bucket, currentTime int64) bucketPlan {
+	actions, held, pending := []Action{}, []HeldAction{}, []PendingRewrite{}
+	holds := userBucket.ActiveHolds(currentTime)
+	requests := userBucket.deletionRequests(currentTime)
+	blocks := userBucket.snapshot()
+	for i, b := range blocks {
+		a, ok := planBlock(policies, requests, b, currentTime)
+		if !ok {
+			continue
+		}
+		a.index = i
+		a.generation = b.MetaData.Generation
+		a, ok, h, withheld := withholdHeldSeries(holds, b, a)
+		if withheld {
+			held = append(held, h)
+		}
+		if !ok {
+			continue
+		}
+		if policies.Coalescing.holdsBack(b, a, currentTime) {
+			pending = append(pending, PendingRewrite{Action: a, FlushAt: pendingSince(b.MetaData, currentTime) + policies.Coalescing.MaxDelay})
+			continue
+		}
+		actions = append(actions, a)
+	}
+	plan := bucketPlan{held: held}
+	actions, plan.seriesBudget = enforceSeriesBudget(policies, blocks, actions, holds, currentTime)
+	actions, plan.quota = enforceQuota(policies, blocks, actions, holds, currentTime)
+	plan.actions, plan.pending = flushPending(blocks, actions, pending)
+	return plan
+}
+
+// deletions keeps the actions of the plan that carry out deletion requests, and the
+// held ones that would have.
+func (p bucketPlan) deletions() bucketPlan {
+	deletions := bucketPlan{actions: []Action{}, held: []HeldAction{}, pending: []PendingRewrite{}, seriesBudget: p.seriesBudget, quota: p.quota}
+	for _, a := range p.actions {
+		if len(a.DeletionRequests) > 0 {
+			deletions.actions = append(deletions.actions, a)
+		}
+	}
+	for _, h := range p.held {
+		if len(h.DeletionRequests) > 0 {
+			deletions.held = append(deletions.held, h)
+		}
+	}
+	return deletions
+}
+
+// planBlock plans retention for the block, with the retention tiers of its resolution,
+// along with the downsampling due for it and the deletion requests it has not carried
+// out yet.
+func planBlock(policies UserConfig, requests []DeletionRequest, b Block, currentTime int64) (Action, bool) {
+	if b.Deleted {
+		return Action{}, false
+	}
+	policies = policies.atResolution(b.MetaData.Resolution)
+	a, ok := planRetention(policies, b, currentTime)
+	if ok && a.Kind == ActionDelete {
+		return a, true
+	}
+	a, ok = planDownsampling(policies, b, currentTime, a, ok)
+	pending := pendingDeletionRequests(requests, b)
+	if len(pending) == 0 {
+		return a, ok
+	}
+	if !ok {
+		a = Action{BlockID: b.ID, Kind: ActionRewrite, Deadline: currentTime}
+	}
+	a.deletions = map[string]DeletionRequest{}
+	for _, r := range pending {
+		a.DeletionRequests = append(a.DeletionRequests, r.ID)
+		a.deletions[r.ID] = r
+		if !r.covers(b) {
+			a.PartialDeletions = append(a.PartialDeletions, r.ID)
+		}
+		if r.RequestedAt < a.Deadline {
+			a.Deadline = r.RequestedAt
+		}
+	}
+	a.SeriesDropped = seriesDroppedByPolicy(b, a)
+	a.EstimatedBytes = estimateReclaimedBytes(b, a)
+	return a, true
+}
+
+func planRetention(policies UserConfig, b Block, currentTime int64) (Action, bool) {
+	minRetention, maxRetention := getRetentionPeriodRange(policies.Policies, policies.BaseRetention)
+	if !isBlockRetentionPassed(b.MaxT, currentTime, minRetention) {
+		return Action{}, false
+	}
+	deleteAction := Action{BlockID: b.ID, Kind: ActionDelete, Deadline: b.MaxT + maxRetention, EstimatedBytes: b.Stats.Bytes}
+	if isBlockRetentionPassed(b.MaxT, currentTime, maxRetention) {
+		return deleteAction, true
+	}
+
+	dropPolicies, keepPolicies := buildPolicy(b, policies, currentTime)
+	toBeDeleted, rewriteKeepPolicy, rewriteDropPolicy := needsRewrite(dropPolicies, keepPolicies, b, currentTime, policies.BaseRetention)
+	if toBeDeleted {
+		return deleteAction, true
+	}
+	if !rewriteKeepPolicy && !rewriteDropPolicy {
+		return Action{}, false
+	}
+	a := Action{
+		BlockID:           b.ID,
+		Kind:              ActionRewrite,
+		DropPolicies:      dropPolicies,
+		KeepPolicies:      keepPolicies,
+		RewriteKeepPolicy: rewriteKeepPolicy,
+		RewriteDropPolicy: rewriteDropPolicy,
+	}
+	a.Deadline = rewriteDeadline(policies, b, a, currentTime)
+	a.SeriesDropped = seriesDroppedByPolicy(b, a)
+	a.EstimatedBytes = estimateReclaimedBytes(b, a)
+	return a, true
+}
+
+// rewriteDeadline is the earliest time at which one of the changes carried by the
+// rewrite became due.
+func rewriteDeadline(policies UserConfig, b Block, a Action, currentTime int64) int64 {
+	deadline := currentTime
+	if a.RewriteDropPolicy {
+		for _, p := range policies.Policies {
+			if containsString(a.DropPolicies, p.Policy) && !containsString(b.MetaData.DropPolicies, hashPolicy(p.Policy)) && b.MaxT+p.RetentionPeriod < deadline {
+				deadline = b.MaxT + p.RetentionPeriod
+			}
+		}
+	}
+	if a.RewriteKeepPolicy {
+		// the keep set last changed either when base retention passed or when the most
+		// recent longer policy expired
+		keepChanged := b.MaxT + policies.BaseRetention
+		for _, p := range policies.Policies {
+			if p.RetentionPeriod > policies.BaseRetention && isBlockRetentionPassed(b.MaxT, currentTime, p.RetentionPeriod) && b.MaxT+p.RetentionPeriod > keepChanged {
+				keepChanged = b.MaxT + p.RetentionPeriod
+			}
+		}
+		if keepChanged < deadline {
+			deadline = keepChanged
+		}
+	}
+	return deadline
+}
+
+// estimateReclaimedBytes scales the block size by the fraction of its series the
+// rewrite would remove, and adds what deleting samples of and downsampling the others
+// saves. Blocks without known series are estimated to reclaim nothing unless
+// downsampled as a whole.
+func estimateReclaimedBytes(b Block, a Action) int64 {
+	if a.Kind == ActionDelete {
+		return b.Stats.Bytes
+	}
+	numSeries := seriesCount(b)
+	if numSeries == 0 {
+		return 0
+	}
+	removed := int64(0)
+	for _, n := range a.SeriesDropped {
+		removed += n
+	}
+	trimmed, _ := trimmedSamples(b, a)
+	downsampled, _ := downsampleSavings(b, a)
+	return b.Stats.Bytes*removed/numSeries + trimmed + downsampled
+}
+
+// seriesCount is the number of series of the block, taken from its stats when known.
+func seriesCount(b Block) int64 {
+	if b.Stats.NumSeries > 0 {
+		return b.Stats.NumSeries
+	}
+	return int64(len(b.Series))
+}
+
+func seriesDroppedByPolicy(b Block, a Action) map[string]int64 {
+	dropped := map[string]int64{}
+	for s := range b.Series {
+		if p, ok := seriesRemovedBy(s, b, a); ok {
+			dropped[p]++
+		}
+	}
+	return dropped
+}
+
+// seriesRemovedBy returns the policy that removes the series in this rewrite, if any.
+// Series removed by a deletion request are reported under deletionRequestName.
+func seriesRemovedBy(series string, b Block, a Action) (string, bool) {
+	if containsString(a.HeldSeries, series) {
+		return "", false
+	}
+	if containsString(a.ExpiredSeries, series) {
+		return defaultPolicyName, true
+	}
+	for _, id := range a.DeletionRequests {
+		if r, ok := a.deletions[id]; ok && !containsString(a.PartialDeletions, id) && matchesPolicy(series, r.Selector) {
+			return deletionRequestName(id), true
+		}
+	}
+	if containsString(a.BudgetSeries, series) {
+		return seriesBudgetPolicyName, true
+	}
+	if containsString(a.QuotaSeries, series) {
+		return quotaPolicyName, true
+	}
+	if a.RewriteDropPolicy {
+		for _, dp := range a.DropPolicies {
+			if !containsString(b.MetaData.DropPolicies, hashPolicy(dp)) && matchesPolicy(series, dp) {
+				return dp, true
+			}
+		}
+	}
+	if a.RewriteKeepPolicy {
+		for _, kp := range a.KeepPolicies {
+			if matchesPolicy(series, kp) {
+				return "", false
+			}
+		}
+		return defaultPolicyName, true
+	}
+	return "", false
+}
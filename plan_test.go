@@ -0,0 +1,57 @@
+package toyRetention
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanBucketRetention(t *testing.T) {
+	config := UserConfig{
+		BaseRetention: 10 * secondsInADay,
+		Policies: []PerSeriesRetentionPolicy{
+			{RetentionPeriod: 5 * secondsInADay, Policy: "service=h1"},
+			{RetentionPeriod: 20 * secondsInADay, Policy: "name=ying"},
+		},
+	}
+	series := map[string]interface{}{
+		"service=h1,name=a": nil,
+		"service=h2,name=a": nil,
+		"service=h2,name=b": nil,
+		"name=ying":         nil,
+	}
+	bucket := &Bucket{
+		Blocks: []Block{
+			{ID: 1, MaxT: theCurrentTime - 2*secondsInADay, Series: series, Stats: BlockStats{Bytes: 400}},
+			{ID: 2, MaxT: theCurrentTime - 6*secondsInADay, Series: series, Stats: BlockStats{Bytes: 400}},
+			{ID: 3, MaxT: theCurrentTime - 12*secondsInADay, Series: series, Stats: BlockStats{Bytes: 400}, MetaData: MetaData{DropPolicies: []string{hashPolicy("service=h1")}}},
+			{ID: 4, MaxT: theCurrentTime - 25*secondsInADay, Series: series, Stats: BlockStats{Bytes: 400}},
+			{ID: 5, MaxT: theCurrentTime - 25*secondsInADay, Deleted: true},
+		},
+	}
+
+	actions := PlanBucketRetention(config, bucket, theCurrentTime)
+
+	assert.Equal(t, 3, len(actions))
+	assert.Equal(t, 2, actions[0].BlockID)
+	assert.Equal(t, ActionRewrite, actions[0].Kind)
+	assert.Equal(t, true, actions[0].RewriteDropPolicy)
+	assert.Equal(t, int64(100), actions[0].EstimatedBytes)
+	assert.Equal(t, theCurrentTime-secondsInADay, actions[0].Deadline)
+	assert.Equal(t, secondsInADay, actions[0].Overdue(theCurrentTime))
+
+	assert.Equal(t, 3, actions[1].BlockID)
+	assert.Equal(t, true, actions[1].RewriteKeepPolicy)
+	assert.Equal(t, false, actions[1].RewriteDropPolicy)
+	assert.Equal(t, []string{"name=ying"}, actions[1].KeepPolicies)
+	assert.Equal(t, int64(300), actions[1].EstimatedBytes)
+	assert.Equal(t, theCurrentTime-2*secondsInADay, actions[1].Deadline)
+
+	assert.Equal(t, 4, actions[2].BlockID)
+	assert.Equal(t, ActionDelete, actions[2].Kind)
+	assert.Equal(t, int64(400), actions[2].EstimatedBytes)
+
+	// planning does not touch the bucket
+	assert.Equal(t, 0, bucket.Blocks[1].Retained)
+	assert.Equal(t, false, bucket.Blocks[3].Deleted)
+}
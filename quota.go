@@ -0,0 +1,176 @@
+package toyRetention
+
+import "sort"
+
+// quotaPolicyName stands for the size quota where a policy name is expected.
+const quotaPolicyName = "quota"
+
+// QuotaStep is a block deleted or rewritten to bring the bucket under its quota,
+// oldest first, or that would have been if it were not for legal holds.
+type QuotaStep struct {
+	BlockID int        `json:"block_id"`
+	Kind    ActionKind `json:"kind"`
+	// BytesReclaimed is what the step reclaims on top of time-based retention.
+	BytesReclaimed int64 `json:"bytes_reclaimed"`
+	// BytesAfter is the projected size of the bucket after the step.
+	BytesAfter int64    `json:"bytes_after"`
+	HeldBy     []string `json:"held_by,omitempty"`
+}
+
+// QuotaReport tells how the bucket is brought under UserConfig.MaxBytes.
+type QuotaReport struct {
+	MaxBytes int64 `json:"max_bytes"`
+	// BytesAfterRetention is the projected size of the bucket once time-based
+	// retention ran, BytesAfterQuota once the quota was enforced as well.
+	BytesAfterRetention int64       `json:"bytes_after_retention"`
+	BytesAfterQuota     int64       `json:"bytes_after_quota"`
+	Steps               []QuotaStep `json:"steps"`
+}
+
+// Fits returns true if the bucket is within its quota once the plan is applied.
+func (r QuotaReport) Fits() bool {
+	return r.MaxBytes <= 0 || r.BytesAfterQuota <= r.MaxBytes
+}
+
+// QuotaRecord is a quota enforcement step applied to a block.
+type QuotaRecord struct {
+	AppliedAt     int64 `json:"applied_at"`
+	Deleted       bool  `json:"deleted"`
+	SeriesDropped int64 `json:"series_dropped"`
+}
+
+// PlanQuota returns how the plan of PlanBucketRetention brings the bucket under the
+// configured quota.
+func PlanQuota(policies UserConfig, userBucket *Bucket, currentTime int64) QuotaReport {
+	return planBucket(policies, userBucket, currentTime).quota
+}
+
+// enforceQuota adds the steps needed to bring the blocks under the quota once the
+// retention actions are applied. The oldest blocks are deleted first, or stripped of
+// the series legal holds leave when a hold protects them, until the bucket fits.
+// Blocks younger than UserConfig.MinRetention are left alone.
+func enforceQuota(policies UserConfig, blocks []Block, actions []Action, holds []LegalHold, currentTime int64) ([]Action, QuotaReport) {
+	report := QuotaReport{MaxBytes: policies.MaxBytes, Steps: []QuotaStep{}}
+	size := int64(0)
+	for _, b := range blocks {
+		if !b.Deleted {
+			size += b.Stats.Bytes
+		}
+	}
+	planned := map[int]int{}
+	for i, a := range actions {
+		planned[a.index] = i
+		size -= a.EstimatedBytes
+	}
+	report.BytesAfterRetention = size
+
+	order := make([]int, 0, len(blocks))
+	for i := range blocks {
+		order = append(order, i)
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return blocks[order[i]].MaxT < blocks[order[j]].MaxT
+	})
+	for _, i := range order {
+		if policies.MaxBytes <= 0 || size <= policies.MaxBytes {
+			break
+		}
+		b := blocks[i]
+		if b.Deleted || !isBlockRetentionPassed(b.MaxT, currentTime, policies.MinRetention) {
+			continue
+		}
+		prev, ok := Action{}, false
+		if j, found := planned[i]; found {
+			prev, ok = actions[j], true
+		}
+		if ok && prev.Kind == ActionDelete {
+			continue
+		}
+
+		a, held := quotaAction(b, prev, ok, holds, currentTime)
+		if len(held) > 0 {
+			report.Steps = append(report.Steps, QuotaStep{BlockID: b.ID, Kind: a.Kind, BytesAfter: size, HeldBy: held})
+			continue
+		}
+		a.index, a.generation = i, b.MetaData.Generation
+		reclaimed := a.EstimatedBytes - prev.EstimatedBytes
+		size -= reclaimed
+		report.Steps = append(report.Steps, QuotaStep{BlockID: b.ID, Kind: a.Kind, BytesReclaimed: reclaimed, BytesAfter: size})
+		if ok {
+			actions[planned[i]] = a
+		} else {
+			planned[i] = len(actions)
+			actions = append(actions, a)
+		}
+	}
+	report.BytesAfterQuota = size
+	sort.SliceStable(actions, func(i, j int) bool {
+		return actions[i].index < actions[j].index
+	})
+	return actions, report
+}
+
+// quotaAction deletes the block for the quota or, when holds protect some of its
+// series, extends the action planned for it, if any, to drop every series they leave.
+// It returns the holds blocking the deletion if nothing can be dropped.
+func quotaAction(b Block, prev Action, ok bool, holds []LegalHold, currentTime int64) (Action, []string) {
+	del := Action{BlockID: b.ID, Kind: ActionDelete, Quota: true, Deadline: currentTime, EstimatedBytes: b.Stats.Bytes}
+	held := holdsBlocking(holds, b, del)
+	if len(held) == 0 {
+		return del, nil
+	}
+
+	a := prev
+	if !ok {
+		a = Action{BlockID: b.ID, Kind: ActionRewrite, Deadline: currentTime}
+	}
+	a.QuotaSeries = nil
+	series := make([]string, 0, len(b.Series))
+	for s := range b.Series {
+		series = append(series, s)
+	}
+	sort.Strings(series)
+	for _, s := range series {
+		if _, removed := seriesRemovedBy(s, b, a); !removed && !seriesHeld(holds, b, s) {
+			a.QuotaSeries = append(a.QuotaSeries, s)
+		}
+	}
+	if len(a.QuotaSeries) == 0 {
+		return del, held
+	}
+	a.Quota = true
+	a.SeriesDropped = seriesDroppedByPolicy(b, a)
+	a.EstimatedBytes = estimateReclaimedBytes(b, a)
+	return a, nil
+}
+
+// logQuota records the quota enforcement step the action carries out, if any.
+func logQuota(md MetaData, a Action, currentTime int64) MetaData {
+	if !a.Quota {
+		return md
+	}
+	md.QuotaLog = append(md.QuotaLog, QuotaRecord{AppliedAt: currentTime, Deleted: a.Kind == ActionDelete, SeriesDropped: int64(len(a.QuotaSeries))})
+	return md
+}
+
+// deletedForQuota returns true if the block was deleted to meet the quota rather
+// than because its retention passed.
+func deletedForQuota(md MetaData) bool {
+	for _, r := range md.QuotaLog {
+		if r.Deleted {
+			return true
+		}
+	}
+	return false
+}
+
+// quotaRewrites counts the quota enforcement rewrites of the block.
+func quotaRewrites(md MetaData) int {
+	n := 0
+	for _, r := range md.QuotaLog {
+		if !r.Deleted {
+			n++
+		}
+	}
+	return n
+}
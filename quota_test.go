@@ -0,0 +1,57 @@
+package toyRetention
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuota(t *testing.T) {
+	config := UserConfig{BaseRetention: 30 * secondsInADay, MaxBytes: 250, MinRetention: 2 * secondsInADay}
+	assert.Nil(t, ValidateConfig(config))
+	bucket := &Bucket{Blocks: []Block{
+		{ID: 1, MaxT: theCurrentTime - 40*secondsInADay, Stats: BlockStats{Bytes: 100}},
+		{ID: 2, MaxT: theCurrentTime - 20*secondsInADay, Stats: BlockStats{Bytes: 100}},
+		{ID: 3, MinT: theCurrentTime - 11*secondsInADay, MaxT: theCurrentTime - 10*secondsInADay, Series: map[string]interface{}{"service=h1": nil, "service=h2": nil}, Stats: BlockStats{Bytes: 100}},
+		{ID: 4, MinT: theCurrentTime - 6*secondsInADay, MaxT: theCurrentTime - 5*secondsInADay, Stats: BlockStats{Bytes: 100}},
+		{ID: 5, MaxT: theCurrentTime - secondsInADay, Stats: BlockStats{Bytes: 100}},
+	}}
+	_, err := bucket.PlaceHold(LegalHold{ID: "case-1", Selector: "service=h1", MinT: theCurrentTime - 12*secondsInADay, MaxT: theCurrentTime - 9*secondsInADay}, theCurrentTime)
+	assert.NoError(t, err)
+
+	// retention deletes block 1, the quota the oldest blocks after it, only dropping
+	// what the hold leaves of block 3
+	actions := PlanBucketRetention(config, bucket, theCurrentTime)
+	assert.Equal(t, []int{1, 2, 3}, blockIDs(actions))
+	assert.Equal(t, []string{ReasonRetentionPassed}, actions[0].Reasons())
+	assert.Equal(t, []string{ReasonQuotaExceeded}, actions[1].Reasons())
+	assert.Equal(t, ActionRewrite, actions[2].Kind)
+	assert.Equal(t, []string{"service=h2"}, actions[2].QuotaSeries)
+	assert.Equal(t, map[string]int64{quotaPolicyName: 1}, actions[2].SeriesDropped)
+	assert.Equal(t, QuotaReport{
+		MaxBytes:            250,
+		BytesAfterRetention: 400,
+		BytesAfterQuota:     250,
+		Steps: []QuotaStep{
+			{BlockID: 2, Kind: ActionDelete, BytesReclaimed: 100, BytesAfter: 300},
+			{BlockID: 3, Kind: ActionRewrite, BytesReclaimed: 50, BytesAfter: 250},
+		},
+	}, PlanQuota(config, bucket, theCurrentTime))
+
+	assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime))
+	assert.Equal(t, true, bucket.Blocks[1].Deleted)
+	assert.Equal(t, map[string]interface{}{"service=h1": nil}, bucket.Blocks[2].Series)
+	assert.Equal(t, []QuotaRecord{{AppliedAt: theCurrentTime, SeriesDropped: 1}}, bucket.Blocks[2].MetaData.QuotaLog)
+	assert.Empty(t, PlanBucketRetention(config, bucket, theCurrentTime))
+	assert.Equal(t, []Violation{}, AuditBucket(config, bucket, theCurrentTime, 0))
+
+	// blocks within the minimum retention are kept even if the bucket does not fit,
+	// and the held series stay
+	config.MaxBytes = 50
+	report := PlanQuota(config, bucket, theCurrentTime)
+	assert.Equal(t, []QuotaStep{
+		{BlockID: 3, Kind: ActionDelete, BytesAfter: 250, HeldBy: []string{"case-1"}},
+		{BlockID: 4, Kind: ActionDelete, BytesReclaimed: 100, BytesAfter: 150},
+	}, report.Steps)
+	assert.False(t, report.Fits())
+}
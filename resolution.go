@@ -0,0 +1,42 @@
+package toyRetention
+
+// Resolutions blocks are commonly downsampled to, in seconds, besides raw data.
+const (
+	ResolutionRaw = int64(0)
+	Resolution5m  = int64(5 * 60)
+	Resolution1h  = int64(60 * 60)
+)
+
+// tierRetention returns the retention configured for the resolution, or retention
+// when the resolution has no tier of its own.
+func tierRetention(retention int64, tiers map[int64]int64, resolution int64) int64 {
+	if r, ok := tiers[resolution]; ok {
+		return r
+	}
+	return retention
+}
+
+// atResolution returns the config retaining blocks of the given resolution: base
+// retention and every policy take the retention of their tier for it.
+func (config UserConfig) atResolution(resolution int64) UserConfig {
+	if len(config.BaseRetentionByResolution) == 0 && !hasRetentionTiers(config.Policies) {
+		return config
+	}
+	config.BaseRetention = tierRetention(config.BaseRetention, config.BaseRetentionByResolution, resolution)
+	policies := make([]PerSeriesRetentionPolicy, 0, len(config.Policies))
+	for _, p := range config.Policies {
+		p.RetentionPeriod = tierRetention(p.RetentionPeriod, p.RetentionByResolution, resolution)
+		policies = append(policies, p)
+	}
+	config.Policies = policies
+	return config
+}
+
+func hasRetentionTiers(policies []PerSeriesRetentionPolicy) bool {
+	for _, p := range policies {
+		if len(p.RetentionByResolution) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package toyRetention
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetentionPerResolution(t *testing.T) {
+	config := UserConfig{
+		BaseRetention:             10 * secondsInADay,
+		BaseRetentionByResolution: map[int64]int64{Resolution5m: 30 * secondsInADay, Resolution1h: 90 * secondsInADay},
+		Policies: []PerSeriesRetentionPolicy{
+			{RetentionPeriod: 5 * secondsInADay, RetentionByResolution: map[int64]int64{Resolution5m: 20 * secondsInADay}, Policy: "service=h1"},
+		},
+	}
+	assert.Nil(t, ValidateConfig(config))
+	series := func() map[string]interface{} {
+		return map[string]interface{}{"service=h1": nil, "service=h2": nil}
+	}
+	bucket := &Bucket{Blocks: []Block{
+		{ID: 1, MaxT: theCurrentTime - 15*secondsInADay, Series: series()},
+		{ID: 2, MaxT: theCurrentTime - 15*secondsInADay, Series: series(), MetaData: MetaData{Resolution: Resolution5m}},
+		{ID: 3, MaxT: theCurrentTime - 25*secondsInADay, Series: series(), MetaData: MetaData{Resolution: Resolution5m}},
+		{ID: 4, MaxT: theCurrentTime - 40*secondsInADay, Series: series(), MetaData: MetaData{Resolution: Resolution1h}},
+	}}
+
+	// raw data only lives for the base retention, service=h1 falls back to its
+	// retention period at 1h
+	actions := PlanBucketRetention(config, bucket, theCurrentTime)
+	assert.Equal(t, []int{1, 3, 4}, blockIDs(actions))
+	assert.Equal(t, ActionDelete, actions[0].Kind)
+	assert.Equal(t, theCurrentTime-5*secondsInADay, actions[0].Deadline)
+	assert.Equal(t, map[string]int64{"service=h1": 1}, actions[1].SeriesDropped)
+	assert.Equal(t, theCurrentTime-5*secondsInADay, actions[1].Deadline)
+	assert.Equal(t, map[string]int64{"service=h1": 1}, actions[2].SeriesDropped)
+
+	assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime))
+	assert.Equal(t, true, bucket.Blocks[0].Deleted)
+	assert.Equal(t, 0, bucket.Blocks[1].Retained)
+	assert.Equal(t, []Violation{}, AuditBucket(config, bucket, theCurrentTime, 0))
+
+	// the 5m blocks go once their own base retention passed
+	actions = PlanBucketRetention(config, bucket, theCurrentTime+16*secondsInADay)
+	assert.Equal(t, []int{2, 3}, blockIDs(actions))
+	assert.Equal(t, ActionDelete, actions[0].Kind)
+	assert.Equal(t, ActionDelete, actions[1].Kind)
+}
@@ -1,9 +1,18 @@
 package toyRetention
 
 import (
+	"errors"
+	"fmt"
 	"strings"
+	"sync"
 )
 
+// maxWriteAttempts bounds how often a block is re-evaluated after write conflicts.
+const maxWriteAttempts = 5
+
+// ErrConflict is returned when a block changed since the version it was read at.
+var ErrConflict = errors.New("block metadata was modified concurrently")
+
 type Block struct {
 	ID       int
 	Series   map[string]interface{}
@@ -12,45 +21,341 @@ type Block struct {
 	Retained int
 	MetaData MetaData
 	Deleted  bool
+	Stats    BlockStats
+}
+
+type BlockStats struct {
+	Bytes      int64 `json:"bytes"`
+	NumSeries  int64 `json:"num_series"`
+	NumSamples int64 `json:"num_samples"`
 }
 
 type Bucket struct {
+	Tenant string
 	Blocks []Block
+	// Lock is set while a retention run owns the bucket, see AcquireBucketLock.
+	Lock *BucketLock
+	// Holds are the legal holds placed on the tenant, see PlaceHold.
+	Holds []LegalHold
+	// Deletions are the deletion requests of the tenant, see RequestDeletion.
+	Deletions []DeletionRequest
+
+	mu sync.Mutex
+	// writeMu serializes block writes, so that a write approved by commitBlock cannot
+	// be beaten by another one.
+	writeMu sync.Mutex
+	// loaded are the generations LoadBucket read the blocks at, by block ID, so that
+	// SaveBucket only replaces metadata nobody else wrote since.
+	loaded map[int]int64
+}
+
+// ReadBlock returns a copy of the block at index i, safe to modify and write back
+// with WriteBlock.
+func (bkt *Bucket) ReadBlock(i int) Block {
+	bkt.mu.Lock()
+	defer bkt.mu.Unlock()
+	return cloneBlock(bkt.Blocks[i])
+}
+
+// WriteBlock replaces the block at index i, provided it is still at the generation
+// it was read at. Otherwise it returns ErrConflict and the caller should read again.
+func (bkt *Bucket) WriteBlock(i int, b Block, generation int64) error {
+	bkt.writeMu.Lock()
+	defer bkt.writeMu.Unlock()
+	return bkt.writeBlock(i, b, generation)
+}
+
+// commitBlock is WriteBlock asking approve first, only once the write is sure to
+// succeed. approve must not write to the bucket.
+func (bkt *Bucket) commitBlock(i int, b Block, generation int64, approve func() error) error {
+	bkt.writeMu.Lock()
+	defer bkt.writeMu.Unlock()
+	if bkt.ReadBlock(i).MetaData.Generation != generation {
+		return ErrConflict
+	}
+	if approve != nil {
+		if err := approve(); err != nil {
+			return err
+		}
+	}
+	return bkt.writeBlock(i, b, generation)
+}
+
+func (bkt *Bucket) writeBlock(i int, b Block, generation int64) error {
+	bkt.mu.Lock()
+	defer bkt.mu.Unlock()
+	if bkt.Blocks[i].MetaData.Generation != generation {
+		return ErrConflict
+	}
+	b.MetaData.Generation = generation + 1
+	bkt.Blocks[i] = b
+	return nil
+}
+
+func (bkt *Bucket) snapshot() []Block {
+	bkt.mu.Lock()
+	defer bkt.mu.Unlock()
+	blocks := make([]Block, 0, len(bkt.Blocks))
+	for _, b := range bkt.Blocks {
+		blocks = append(blocks, cloneBlock(b))
+	}
+	return blocks
 }
 
 type PerSeriesRetentionPolicy struct {
 	RetentionPeriod int64
 	Policy          string
+	// Downsample lowers the resolution of the matching series as they age, see
+	// DownsampleRule.
+	Downsample []DownsampleRule
+	// RetentionByResolution overrides RetentionPeriod for blocks of the given
+	// resolutions in seconds, 0 being raw data.
+	RetentionByResolution map[int64]int64
 }
 
 type UserConfig struct {
 	BaseRetention int64
-	Policies      []PerSeriesRetentionPolicy
+	// BaseRetentionByResolution overrides BaseRetention for blocks of the given
+	// resolutions in seconds, 0 being raw data.
+	BaseRetentionByResolution map[int64]int64
+	Policies                  []PerSeriesRetentionPolicy
+	// KeepHistoryLimit is how many previous keep sets block metadata remembers besides
+	// the current one, FullKeepHistory keeps them all.
+	KeepHistoryLimit int
+	// Downsample lowers the resolution of whole blocks as they age, see DownsampleRule.
+	Downsample []DownsampleRule
+	// MaxBytes is the size quota of the bucket, 0 for none. Once time-based retention
+	// ran, the oldest blocks are deleted or rewritten until the bucket fits, except
+	// those younger than MinRetention.
+	MaxBytes     int64
+	MinRetention int64
+	// SeriesBudget caps the distinct series of the blocks overlapping the last
+	// SeriesBudgetWindow seconds, 0 for no cap. The blocks past MinRetention lose
+	// their lowest priority series until the window fits, see enforceSeriesBudget.
+	SeriesBudget       int64
+	SeriesBudgetWindow int64
+	// Coalescing holds back drop and keep policy rewrites to apply several changes
+	// at once, see RewriteCoalescing.
+	Coalescing RewriteCoalescing
 }
 
+// FullKeepHistory as UserConfig.KeepHistoryLimit keeps every keep set, for auditing.
+const FullKeepHistory = -1
+
 type MetaData struct {
+	// KeepPolicies is the unbounded keep set history written by older versions. It is
+	// only read to migrate blocks to KeepPolicy and KeepPolicyLog.
 	KeepPolicies []string
-	DropPolicies []string
-}
-
-func ApplyBucketRetention(policies UserConfig, userBucket *Bucket, currentTime int64) {
-	for i, b := range userBucket.Blocks {
-		minRetention, maxRetention := getRetentionPeriodRange(policies.Policies, policies.BaseRetention)
-		if !isBlockRetentionPassed(b.MaxT, currentTime, minRetention) {
-			continue
-		} else if isBlockRetentionPassed(b.MaxT, currentTime, maxRetention) {
-			userBucket.Blocks[i].Deleted = true
-		} else {
-			dropPolicies, keepPolicies := buildPolicy(b, policies, currentTime)
-			toBeDeleted, rewriteKeepPolicy, rewriteDropPolicy := needsRewrite(dropPolicies, keepPolicies, b, currentTime, policies.BaseRetention)
-			if toBeDeleted {
-				userBucket.Blocks[i].Deleted = true
+	// KeepPolicy is the fingerprint of the keep set the block was last rewritten with,
+	// at KeepPolicyAppliedAt.
+	KeepPolicy          string
+	KeepPolicyAppliedAt int64
+	// KeepPolicyLog holds the previous keep sets, oldest first, capped by
+	// UserConfig.KeepHistoryLimit.
+	KeepPolicyLog []KeepPolicyRecord
+	// KeepPolicyRewrites counts the keep sets the block was rewritten with, including
+	// those no longer in the log.
+	KeepPolicyRewrites int
+	DropPolicies       []string
+	// DropPolicyLog records when each of DropPolicies was applied and what it removed.
+	// Drop policies applied by older versions have no record until the next rewrite
+	// backfills a legacy one.
+	DropPolicyLog []DropPolicyRecord
+	// DeletionRequests are the IDs of the deletion requests carried out on the block.
+	DeletionRequests []string
+	// DeletedRanges are the samples removed by the deletion requests the block lies
+	// only partly in.
+	DeletedRanges []DeletedRange
+	// Resolution is the resolution of the whole block in seconds, 0 for raw data.
+	Resolution int64
+	// DownsampleLog records every downsampling the block was rewritten with, the
+	// fingerprint being empty when the whole block was downsampled.
+	DownsampleLog []DownsampleRecord
+	// QuotaLog records the deletion or rewrites of the block enforcing the size quota.
+	QuotaLog []QuotaRecord
+	// SeriesBudgetLog records the rewrites of the block enforcing the series budget.
+	SeriesBudgetLog []SeriesBudgetRecord
+	// HoldLog records the rewrites that left series under legal holds in the block.
+	HoldLog []HoldRecord
+	// PendingSince is when coalescing started holding back a rewrite of the block, 0
+	// when none is pending, see RewriteCoalescing.
+	PendingSince int64
+	// Generation is bumped on every block write, see Bucket.WriteBlock.
+	Generation int64
+}
+
+type KeepPolicyRecord struct {
+	Fingerprint string `json:"fingerprint"`
+	AppliedAt   int64  `json:"applied_at"`
+}
+
+type DropPolicyRecord struct {
+	Fingerprint string `json:"fingerprint"`
+	AppliedAt   int64  `json:"applied_at"`
+	// DataDropped is set once a rewrite physically removed the matching series.
+	DataDropped   bool  `json:"data_dropped"`
+	SeriesDropped int64 `json:"series_dropped"`
+	// Legacy records a policy applied by an older version, neither when nor what it
+	// dropped being known.
+	Legacy bool `json:"legacy,omitempty"`
+}
+
+type DownsampleRecord struct {
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Resolution  int64  `json:"resolution"`
+	AppliedAt   int64  `json:"applied_at"`
+}
+
+// ApplyBucketRetention applies the retention planned at currentTime to the bucket.
+// Blocks whose rewrite coalescing holds back are not rewritten, but their metadata
+// is still written to record since when they have been pending, bumping their
+// generation.
+func ApplyBucketRetention(policies UserConfig, userBucket *Bucket, currentTime int64) error {
+	plan := planBucket(policies, userBucket, currentTime)
+	if err := ApplyPlan(policies, userBucket, plan.actions, currentTime); err != nil {
+		return err
+	}
+	return markPending(userBucket, plan, currentTime)
+}
+
+// ApplyBucketRetentionWithBudget applies at most rewriteBudget rewrites, picking the most
+// valuable ones first, and returns the actions that were deferred to a later run. As
+// with ApplyBucketRetention, the metadata of the blocks pending a rewrite is written.
+func ApplyBucketRetentionWithBudget(policies UserConfig, userBucket *Bucket, currentTime int64, rewriteBudget int) ([]Action, error) {
+	plan := planBucket(policies, userBucket, currentTime)
+	scheduled, deferred := ScheduleActions(plan.actions, rewriteBudget)
+	if err := ApplyPlan(policies, userBucket, scheduled, currentTime); err != nil {
+		return deferred, err
+	}
+	return deferred, markPending(userBucket, plan, currentTime)
+}
+
+// ApplyPlan executes the given actions against the bucket they were planned for.
+// When a block was written by someone else since it was planned, it is evaluated
+// again from its current state.
+func ApplyPlan(policies UserConfig, userBucket *Bucket, actions []Action, currentTime int64) error {
+	for _, a := range actions {
+		if _, _, err := applyBlockAction(policies, userBucket, a, currentTime, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyBlockAction applies the planned action and returns the action that was really
+// applied, which differs from the planned one when the block had to be re-evaluated.
+// When set, approve is asked once, about the action finally written, right before the
+// write, and can refuse it with an error.
+func applyBlockAction(policies UserConfig, userBucket *Bucket, planned Action, currentTime int64, approve func(Block, Action) error) (Action, bool, error) {
+	for attempt := 0; attempt < maxWriteAttempts; attempt++ {
+		b := userBucket.ReadBlock(planned.index)
+		a, ok := planned, true
+		if b.MetaData.Generation != planned.generation {
+			a, ok = planBlock(policies, userBucket.deletionRequests(currentTime), b, currentTime)
+			// the series budget and the quota still need their series and space, whatever
+			// changed in the block
+			if len(planned.BudgetSeries) > 0 && !b.Deleted && !(ok && a.Kind == ActionDelete) {
+				cut := map[string]bool{}
+				for _, s := range planned.BudgetSeries {
+					cut[s] = true
+				}
+				if a = withBudgetSeries(b, a, ok, cut, currentTime); len(a.BudgetSeries) > 0 {
+					ok = true
+				}
 			}
-			if rewriteKeepPolicy || rewriteDropPolicy {
-				userBucket.Blocks[i] = applyPolicy(dropPolicies, keepPolicies, rewriteKeepPolicy, rewriteDropPolicy, b)
+			if planned.Quota && !b.Deleted && !(ok && a.Kind == ActionDelete) {
+				a, _ = quotaAction(b, a, ok, userBucket.ActiveHolds(currentTime), currentTime)
+				ok = true
 			}
+			if !ok {
+				return Action{}, false, nil
+			}
+			a.index, a.generation = planned.index, b.MetaData.Generation
+		}
+		// a hold may have been placed since the action was planned
+		a, ok, _, _ = withholdHeldSeries(userBucket.ActiveHolds(currentTime), b, a)
+		if !ok {
+			return a, false, nil
+		}
+		var ask func() error
+		if approve != nil {
+			ask = func() error { return approve(b, a) }
+		}
+		err := userBucket.commitBlock(planned.index, applyAction(b, a, currentTime, policies.KeepHistoryLimit), b.MetaData.Generation, ask)
+		if err == nil {
+			return a, true, nil
+		}
+		if err != ErrConflict {
+			return a, false, err
 		}
 	}
+	return Action{}, false, fmt.Errorf("block %d: giving up after %d attempts: %w", planned.BlockID, maxWriteAttempts, ErrConflict)
+}
+
+func applyAction(b Block, a Action, currentTime int64, keepHistoryLimit int) Block {
+	b.MetaData = logQuota(b.MetaData, a, currentTime)
+	if a.Kind == ActionDelete {
+		b.Deleted = true
+		return b
+	}
+	// what spares held series is not recorded as applied, to be carried out again once
+	// the holds are released
+	spared := sparedRemovals(b, a)
+	recorded := a
+	recorded.DropPolicies, recorded.DeletionRequests = nil, nil
+	for _, dp := range a.DropPolicies {
+		if !spared[dp] {
+			recorded.DropPolicies = append(recorded.DropPolicies, dp)
+		}
+	}
+	for _, id := range a.DeletionRequests {
+		if !spared[deletionRequestName(id)] {
+			recorded.DeletionRequests = append(recorded.DeletionRequests, id)
+		}
+	}
+	recorded.RewriteKeepPolicy = a.RewriteKeepPolicy && !spared[defaultPolicyName]
+
+	dropPolicyLog := b.MetaData.DropPolicyLog
+	if a.RewriteDropPolicy {
+		dropPolicyLog = logDropPolicies(b.MetaData, recorded, currentTime)
+	}
+	trimmedBytes, trimmedSamples, deletedRanges := deleteSamples(b, a, recorded.DeletionRequests)
+	b = applyPolicy(recorded.DropPolicies, a.KeepPolicies, recorded.RewriteKeepPolicy, a.RewriteDropPolicy, dropSeries(b, a), currentTime, keepHistoryLimit)
+	b.Stats.Bytes -= trimmedBytes
+	b.Stats.NumSamples -= trimmedSamples
+	b.MetaData.DeletedRanges = append(b.MetaData.DeletedRanges, deletedRanges...)
+	b.MetaData.DropPolicyLog = dropPolicyLog
+	// the rewrite carries out whatever coalescing held back
+	b.MetaData.PendingSince = 0
+	b.MetaData.DeletionRequests = append(b.MetaData.DeletionRequests, recorded.DeletionRequests...)
+	b.MetaData = logSeriesBudget(b.MetaData, a, currentTime)
+	b.MetaData = logHold(b.MetaData, a, int64(len(b.Series)), currentTime)
+	return downsampleSeries(b, a, currentTime)
+}
+
+// dropSeries removes the series a rewrite drops from the block, shrinking its stats
+// by their share of its bytes and samples. Recording the policies alone would leave
+// the stats of a rewritten block as they were: the quota, the series budget, deletion
+// requests and cost projections all go by what a block still holds after its rewrites.
+func dropSeries(b Block, a Action) Block {
+	kept := map[string]interface{}{}
+	for s, v := range b.Series {
+		if _, removed := seriesRemovedBy(s, b, a); !removed {
+			kept[s] = v
+		}
+	}
+	removed := int64(len(b.Series) - len(kept))
+	if removed == 0 {
+		return b
+	}
+	numSeries := seriesCount(b)
+	b.Stats.Bytes -= b.Stats.Bytes * removed / numSeries
+	b.Stats.NumSamples -= b.Stats.NumSamples * removed / numSeries
+	if b.Stats.NumSeries > 0 {
+		b.Stats.NumSeries -= removed
+	}
+	b.Series = kept
+	return b
 }
 
 func buildPolicy(b Block, config UserConfig, currentTime int64) ([]string, []string) {
@@ -59,7 +364,7 @@ func buildPolicy(b Block, config UserConfig, currentTime int64) ([]string, []str
 	return dropPolicies, keepPolicy
 }
 
-func applyPolicy(dropPolicies []string, keepPolicies []string, rewriteKeepPolicy bool, rewriteDropPolicy bool, b Block) Block {
+func applyPolicy(dropPolicies []string, keepPolicies []string, rewriteKeepPolicy bool, rewriteDropPolicy bool, b Block, currentTime int64, keepHistoryLimit int) Block {
 	if rewriteDropPolicy {
 		for _, dp := range dropPolicies {
 			exist := false
@@ -76,7 +381,7 @@ func applyPolicy(dropPolicies []string, keepPolicies []string, rewriteKeepPolicy
 	}
 
 	if rewriteKeepPolicy {
-		b.MetaData.KeepPolicies = append(b.MetaData.KeepPolicies, hashPolicy(strings.Join(keepPolicies, ";")))
+		b.MetaData = setKeepPolicy(b.MetaData, hashPolicy(strings.Join(keepPolicies, ";")), currentTime, keepHistoryLimit)
 	}
 
 	b.Retained++
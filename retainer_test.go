@@ -37,7 +37,7 @@ func TestApplyBucketRetention(t *testing.T) {
 		assert.Equal(t, 1, len(bucket.Blocks))
 		assert.Equal(t, false, bucket.Blocks[0].Deleted)
 		assert.Equal(t, 0, len(bucket.Blocks[0].MetaData.DropPolicies))
-		assert.Equal(t, 0, len(bucket.Blocks[0].MetaData.KeepPolicies))
+		assert.Equal(t, 0, bucket.Blocks[0].MetaData.KeepPolicyRewrites)
 
 		// no rewrite
 		assert.Equal(t, 0, bucket.Blocks[0].Retained)
@@ -63,7 +63,7 @@ func TestApplyBucketRetention(t *testing.T) {
 		assert.Equal(t, 1, len(bucket.Blocks))
 		assert.Equal(t, false, bucket.Blocks[0].Deleted)
 		assert.Equal(t, 0, len(bucket.Blocks[0].MetaData.DropPolicies))
-		assert.Equal(t, 0, len(bucket.Blocks[0].MetaData.KeepPolicies))
+		assert.Equal(t, 0, bucket.Blocks[0].MetaData.KeepPolicyRewrites)
 
 		// no rewrite
 		assert.Equal(t, 0, bucket.Blocks[0].Retained)
@@ -87,7 +87,7 @@ func TestApplyBucketRetention(t *testing.T) {
 		assert.Equal(t, 1, len(bucket.Blocks))
 		assert.Equal(t, false, bucket.Blocks[0].Deleted)
 		assert.Equal(t, 0, len(bucket.Blocks[0].MetaData.DropPolicies))
-		assert.Equal(t, 0, len(bucket.Blocks[0].MetaData.KeepPolicies))
+		assert.Equal(t, 0, bucket.Blocks[0].MetaData.KeepPolicyRewrites)
 
 		// no rewrite
 		assert.Equal(t, 0, bucket.Blocks[0].Retained)
@@ -113,7 +113,7 @@ func TestApplyBucketRetention(t *testing.T) {
 		assert.Equal(t, 1, len(bucket.Blocks))
 		assert.Equal(t, false, bucket.Blocks[0].Deleted)
 		assert.Equal(t, 0, len(bucket.Blocks[0].MetaData.DropPolicies))
-		assert.Equal(t, 0, len(bucket.Blocks[0].MetaData.KeepPolicies))
+		assert.Equal(t, 0, bucket.Blocks[0].MetaData.KeepPolicyRewrites)
 
 		// no rewrite
 		assert.Equal(t, 0, bucket.Blocks[0].Retained)
@@ -139,7 +139,7 @@ func TestApplyBucketRetention(t *testing.T) {
 		assert.Equal(t, 1, len(bucket.Blocks))
 		assert.Equal(t, false, bucket.Blocks[0].Deleted)
 		assert.Equal(t, 1, len(bucket.Blocks[0].MetaData.DropPolicies))
-		assert.Equal(t, 0, len(bucket.Blocks[0].MetaData.KeepPolicies))
+		assert.Equal(t, 0, bucket.Blocks[0].MetaData.KeepPolicyRewrites)
 		assert.Equal(t, hashPolicy("service=h1"), bucket.Blocks[0].MetaData.DropPolicies[0])
 
 		// rewrite
@@ -166,7 +166,7 @@ func TestApplyBucketRetention(t *testing.T) {
 		assert.Equal(t, 1, len(bucket.Blocks))
 		assert.Equal(t, false, bucket.Blocks[0].Deleted)
 		assert.Equal(t, 2, len(bucket.Blocks[0].MetaData.DropPolicies))
-		assert.Equal(t, 0, len(bucket.Blocks[0].MetaData.KeepPolicies))
+		assert.Equal(t, 0, bucket.Blocks[0].MetaData.KeepPolicyRewrites)
 		assert.Equal(t, hashPolicy("service=h1"), bucket.Blocks[0].MetaData.DropPolicies[0])
 		assert.Equal(t, hashPolicy("service=h2"), bucket.Blocks[0].MetaData.DropPolicies[1])
 
@@ -192,7 +192,7 @@ func TestApplyBucketRetention(t *testing.T) {
 		assert.Equal(t, 1, len(bucket.Blocks))
 		assert.Equal(t, false, bucket.Blocks[0].Deleted)
 		assert.Equal(t, 2, len(bucket.Blocks[0].MetaData.DropPolicies))
-		assert.Equal(t, 0, len(bucket.Blocks[0].MetaData.KeepPolicies))
+		assert.Equal(t, 0, bucket.Blocks[0].MetaData.KeepPolicyRewrites)
 		assert.Equal(t, hashPolicy("service=h1"), bucket.Blocks[0].MetaData.DropPolicies[0])
 		assert.Equal(t, hashPolicy("service=h2"), bucket.Blocks[0].MetaData.DropPolicies[1])
 		// no rewrite
@@ -219,10 +219,10 @@ func TestApplyBucketRetention(t *testing.T) {
 		assert.Equal(t, 1, len(bucket.Blocks))
 		assert.Equal(t, false, bucket.Blocks[0].Deleted)
 		assert.Equal(t, 2, len(bucket.Blocks[0].MetaData.DropPolicies))
-		assert.Equal(t, 1, len(bucket.Blocks[0].MetaData.KeepPolicies))
+		assert.Equal(t, 1, bucket.Blocks[0].MetaData.KeepPolicyRewrites)
 		assert.Equal(t, hashPolicy("service=h1"), bucket.Blocks[0].MetaData.DropPolicies[0])
 		assert.Equal(t, hashPolicy("service=h2"), bucket.Blocks[0].MetaData.DropPolicies[1])
-		assert.Equal(t, hashPolicy("name=ying;namespace=b1"), bucket.Blocks[0].MetaData.KeepPolicies[0])
+		assert.Equal(t, hashPolicy("name=ying;namespace=b1"), bucket.Blocks[0].MetaData.KeepPolicy)
 
 		// rewrite
 		assert.Equal(t, 3, bucket.Blocks[0].Retained)
@@ -248,11 +248,11 @@ func TestApplyBucketRetention(t *testing.T) {
 		assert.Equal(t, 1, len(bucket.Blocks))
 		assert.Equal(t, false, bucket.Blocks[0].Deleted)
 		assert.Equal(t, 3, len(bucket.Blocks[0].MetaData.DropPolicies))
-		assert.Equal(t, 1, len(bucket.Blocks[0].MetaData.KeepPolicies))
+		assert.Equal(t, 1, bucket.Blocks[0].MetaData.KeepPolicyRewrites)
 		assert.Equal(t, hashPolicy("service=h1"), bucket.Blocks[0].MetaData.DropPolicies[0])
 		assert.Equal(t, hashPolicy("service=h2"), bucket.Blocks[0].MetaData.DropPolicies[1])
 		assert.Equal(t, hashPolicy("service=h3"), bucket.Blocks[0].MetaData.DropPolicies[2])
-		assert.Equal(t, hashPolicy("name=ying;namespace=b1"), bucket.Blocks[0].MetaData.KeepPolicies[0])
+		assert.Equal(t, hashPolicy("name=ying;namespace=b1"), bucket.Blocks[0].MetaData.KeepPolicy)
 
 		// rewrite
 		assert.Equal(t, 4, bucket.Blocks[0].Retained)
@@ -278,12 +278,12 @@ func TestApplyBucketRetention(t *testing.T) {
 		assert.Equal(t, 1, len(bucket.Blocks))
 		assert.Equal(t, false, bucket.Blocks[0].Deleted)
 		assert.Equal(t, 3, len(bucket.Blocks[0].MetaData.DropPolicies))
-		assert.Equal(t, 2, len(bucket.Blocks[0].MetaData.KeepPolicies))
+		assert.Equal(t, 2, bucket.Blocks[0].MetaData.KeepPolicyRewrites)
 		assert.Equal(t, hashPolicy("service=h1"), bucket.Blocks[0].MetaData.DropPolicies[0])
 		assert.Equal(t, hashPolicy("service=h2"), bucket.Blocks[0].MetaData.DropPolicies[1])
 		assert.Equal(t, hashPolicy("service=h3"), bucket.Blocks[0].MetaData.DropPolicies[2])
-		assert.Equal(t, hashPolicy("name=ying;namespace=b1"), bucket.Blocks[0].MetaData.KeepPolicies[0])
-		assert.Equal(t, hashPolicy("name=ying;namespace=b2"), bucket.Blocks[0].MetaData.KeepPolicies[1])
+		assert.Equal(t, 0, len(bucket.Blocks[0].MetaData.KeepPolicyLog))
+		assert.Equal(t, hashPolicy("name=ying;namespace=b2"), bucket.Blocks[0].MetaData.KeepPolicy)
 
 		// rewrite
 		assert.Equal(t, 5, bucket.Blocks[0].Retained)
@@ -307,13 +307,12 @@ func TestApplyBucketRetention(t *testing.T) {
 		assert.Equal(t, 1, len(bucket.Blocks))
 		assert.Equal(t, false, bucket.Blocks[0].Deleted)
 		assert.Equal(t, 3, len(bucket.Blocks[0].MetaData.DropPolicies))
-		assert.Equal(t, 3, len(bucket.Blocks[0].MetaData.KeepPolicies))
+		assert.Equal(t, 3, bucket.Blocks[0].MetaData.KeepPolicyRewrites)
 		assert.Equal(t, hashPolicy("service=h1"), bucket.Blocks[0].MetaData.DropPolicies[0])
 		assert.Equal(t, hashPolicy("service=h2"), bucket.Blocks[0].MetaData.DropPolicies[1])
 		assert.Equal(t, hashPolicy("service=h3"), bucket.Blocks[0].MetaData.DropPolicies[2])
-		assert.Equal(t, hashPolicy("name=ying;namespace=b1"), bucket.Blocks[0].MetaData.KeepPolicies[0])
-		assert.Equal(t, hashPolicy("name=ying;namespace=b2"), bucket.Blocks[0].MetaData.KeepPolicies[1])
-		assert.Equal(t, hashPolicy("name=ying"), bucket.Blocks[0].MetaData.KeepPolicies[2])
+		assert.Equal(t, 0, len(bucket.Blocks[0].MetaData.KeepPolicyLog))
+		assert.Equal(t, hashPolicy("name=ying"), bucket.Blocks[0].MetaData.KeepPolicy)
 
 		// rewrite
 		assert.Equal(t, 6, bucket.Blocks[0].Retained)
@@ -339,7 +338,7 @@ func TestApplyBucketRetention(t *testing.T) {
 		assert.Equal(t, 1, len(bucket.Blocks))
 		assert.Equal(t, false, bucket.Blocks[0].Deleted)
 		assert.Equal(t, 4, len(bucket.Blocks[0].MetaData.DropPolicies))
-		assert.Equal(t, 3, len(bucket.Blocks[0].MetaData.KeepPolicies))
+		assert.Equal(t, 3, bucket.Blocks[0].MetaData.KeepPolicyRewrites)
 		assert.Equal(t, hashPolicy("namespace=b2"), bucket.Blocks[0].MetaData.DropPolicies[3])
 
 		// rewrite
@@ -366,7 +365,7 @@ func TestApplyBucketRetention(t *testing.T) {
 		assert.Equal(t, 1, len(bucket.Blocks))
 		assert.Equal(t, false, bucket.Blocks[0].Deleted)
 		assert.Equal(t, 4, len(bucket.Blocks[0].MetaData.DropPolicies))
-		assert.Equal(t, 3, len(bucket.Blocks[0].MetaData.KeepPolicies))
+		assert.Equal(t, 3, bucket.Blocks[0].MetaData.KeepPolicyRewrites)
 
 		// no rewrite
 		assert.Equal(t, 7, bucket.Blocks[0].Retained)
@@ -387,3 +386,88 @@ func TestApplyBucketRetention(t *testing.T) {
 	})
 
 }
+
+func TestApplyBucketRetentionDropsSeries(t *testing.T) {
+	config := UserConfig{
+		BaseRetention: 10 * secondsInADay,
+		Policies:      []PerSeriesRetentionPolicy{{RetentionPeriod: 5 * secondsInADay, Policy: "service=h1"}},
+	}
+	series := func() map[string]interface{} {
+		return map[string]interface{}{"service=h1": nil, "service=h2": nil, "service=h3": nil, "service=h4": nil}
+	}
+
+	t.Run("dropped series leave the block and its stats", func(t *testing.T) {
+		bucket := &Bucket{Blocks: []Block{{ID: 1, MaxT: theCurrentTime - 6*secondsInADay, Series: series(), Stats: BlockStats{Bytes: 1000, NumSamples: 400, NumSeries: 4}}}}
+		assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime))
+		b := bucket.Blocks[0]
+		assert.Equal(t, 1, b.Retained)
+		assert.Equal(t, map[string]interface{}{"service=h2": nil, "service=h3": nil, "service=h4": nil}, b.Series)
+		assert.Equal(t, BlockStats{Bytes: 750, NumSamples: 300, NumSeries: 3}, b.Stats)
+		assert.True(t, b.MetaData.DropPolicyLog[0].DataDropped)
+	})
+
+	t.Run("series count taken from the series without stats", func(t *testing.T) {
+		bucket := &Bucket{Blocks: []Block{{ID: 1, MaxT: theCurrentTime - 6*secondsInADay, Series: series(), Stats: BlockStats{Bytes: 1000}}}}
+		assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime))
+		assert.Equal(t, BlockStats{Bytes: 750}, bucket.Blocks[0].Stats)
+	})
+
+	t.Run("held series stay", func(t *testing.T) {
+		bucket := &Bucket{Blocks: []Block{{ID: 1, MaxT: theCurrentTime - 6*secondsInADay, Series: series(), Stats: BlockStats{Bytes: 1000, NumSeries: 4}}}}
+		_, err := bucket.PlaceHold(LegalHold{ID: "case-1", Selector: "service=h1"}, theCurrentTime)
+		assert.NoError(t, err)
+		assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime))
+		assert.Equal(t, series(), bucket.Blocks[0].Series)
+		assert.Equal(t, BlockStats{Bytes: 1000, NumSeries: 4}, bucket.Blocks[0].Stats)
+	})
+
+	t.Run("nothing to drop leaves the stats", func(t *testing.T) {
+		kept := map[string]interface{}{"service=h2": nil}
+		bucket := &Bucket{Blocks: []Block{{ID: 1, MaxT: theCurrentTime - 6*secondsInADay, Series: kept, Stats: BlockStats{Bytes: 1000, NumSeries: 1}}}}
+		assert.NoError(t, ApplyBucketRetention(config, bucket, theCurrentTime))
+		assert.Equal(t, kept, bucket.Blocks[0].Series)
+		assert.Equal(t, BlockStats{Bytes: 1000, NumSeries: 1}, bucket.Blocks[0].Stats)
+	})
+}
+
+func TestWriteBlock(t *testing.T) {
+	bucket := &Bucket{Blocks: []Block{{ID: 1}}}
+
+	b := bucket.ReadBlock(0)
+	b.MetaData.DropPolicies = append(b.MetaData.DropPolicies, hashPolicy("service=h1"))
+	assert.NoError(t, bucket.WriteBlock(0, b, b.MetaData.Generation))
+	assert.Equal(t, int64(1), bucket.Blocks[0].MetaData.Generation)
+
+	// writing from the stale copy is rejected
+	b.Retained++
+	assert.Equal(t, ErrConflict, bucket.WriteBlock(0, b, 0))
+	assert.Equal(t, 0, bucket.Blocks[0].Retained)
+}
+
+func TestApplyPlanReevaluatesOnConflict(t *testing.T) {
+	config := UserConfig{
+		BaseRetention: 10 * secondsInADay,
+		Policies: []PerSeriesRetentionPolicy{
+			{RetentionPeriod: 5 * secondsInADay, Policy: "service=h1"},
+			{RetentionPeriod: 6 * secondsInADay, Policy: "service=h2"},
+		},
+	}
+	bucket := &Bucket{Blocks: []Block{{MaxT: theCurrentTime - 7*secondsInADay}}}
+	actions := PlanBucketRetention(config, bucket, theCurrentTime)
+	assert.Equal(t, 1, len(actions))
+
+	// a compactor applies one of the drop policies between planning and applying
+	b := bucket.ReadBlock(0)
+	b.MetaData.DropPolicies = []string{hashPolicy("service=h1")}
+	b.Retained++
+	assert.NoError(t, bucket.WriteBlock(0, b, b.MetaData.Generation))
+
+	assert.NoError(t, ApplyPlan(config, bucket, actions, theCurrentTime))
+	assert.Equal(t, []string{hashPolicy("service=h1"), hashPolicy("service=h2")}, bucket.Blocks[0].MetaData.DropPolicies)
+	assert.Equal(t, 2, bucket.Blocks[0].Retained)
+	assert.Equal(t, int64(2), bucket.Blocks[0].MetaData.Generation)
+
+	// the block is now up to date, applying the stale plan again is a noop
+	assert.NoError(t, ApplyPlan(config, bucket, actions, theCurrentTime))
+	assert.Equal(t, 2, bucket.Blocks[0].Retained)
+}
@@ -0,0 +1,193 @@
+package toyRetention
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// Scenario is a declarative retention test: initial blocks, then steps each optionally
+// changing the config, running retention and checking the resulting blocks. Times are
+// periods relative to the scenario start, e.g.
+//
+//	{
+//	  "name": "6m policy expires",
+//	  "blocks": [{"id": 1, "max_time": "0d"}],
+//	  "steps": [
+//	    {"at": "181d", "config": {"base_retention": "390d", "policies": [{"retention_period": "180d", "policy": "service=h1"}]},
+//	     "expect": [{"id": 1, "retained": 1, "drop_policies": ["service=h1"]}]}
+//	  ]
+//	}
+type Scenario struct {
+	Name   string          `json:"name"`
+	Tenant string          `json:"tenant"`
+	Start  int64           `json:"start"`
+	Blocks []ScenarioBlock `json:"blocks"`
+	Steps  []ScenarioStep  `json:"steps"`
+}
+
+type ScenarioBlock struct {
+	ID      int        `json:"id"`
+	MinTime string     `json:"min_time"`
+	MaxTime string     `json:"max_time"`
+	Series  []string   `json:"series"`
+	Stats   BlockStats `json:"stats"`
+}
+
+type ScenarioStep struct {
+	At string `json:"at"`
+	// Config replaces the config from this step on, the previous one is kept when nil.
+	Config *ConfigFile     `json:"config"`
+	Expect []ExpectedBlock `json:"expect"`
+}
+
+// ExpectedBlock only checks the fields that are set. Policies are given decoded.
+type ExpectedBlock struct {
+	ID           int         `json:"id"`
+	Deleted      *bool       `json:"deleted"`
+	Retained     *int        `json:"retained"`
+	DropPolicies *[]string   `json:"drop_policies"`
+	KeepPolicies *[][]string `json:"keep_policies"`
+}
+
+// Mismatch is an expectation a scenario step did not meet.
+type Mismatch struct {
+	Step     int
+	At       string
+	BlockID  int
+	Field    string
+	Expected interface{}
+	Actual   interface{}
+}
+
+type ScenarioResult struct {
+	Name       string
+	Mismatches []Mismatch
+}
+
+func (r ScenarioResult) Passed() bool {
+	return len(r.Mismatches) == 0
+}
+
+// Diff renders the mismatches one per expected and actual line pair.
+func (r ScenarioResult) Diff() string {
+	sb := strings.Builder{}
+	for _, m := range r.Mismatches {
+		fmt.Fprintf(&sb, "step %d (at %s), block %d, %s:\n", m.Step, m.At, m.BlockID, m.Field)
+		fmt.Fprintf(&sb, "- expected: %v\n", m.Expected)
+		fmt.Fprintf(&sb, "+ actual:   %v\n", m.Actual)
+	}
+	return sb.String()
+}
+
+func ParseScenario(r io.Reader) (Scenario, error) {
+	s := Scenario{}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&s); err != nil {
+		return s, fmt.Errorf("decoding scenario: %w", err)
+	}
+	return s, nil
+}
+
+func LoadScenario(path string) (Scenario, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Scenario{}, err
+	}
+	defer f.Close()
+	return ParseScenario(f)
+}
+
+// RunScenario plays the scenario steps in order. Errors are reserved for scenarios
+// that cannot be run, unmet expectations are reported in the result.
+func RunScenario(s Scenario) (ScenarioResult, error) {
+	result := ScenarioResult{Name: s.Name, Mismatches: []Mismatch{}}
+	userBucket := &Bucket{Tenant: s.Tenant, Blocks: []Block{}}
+	index := map[int]int{}
+	for _, sb := range s.Blocks {
+		b, err := sb.toBlock(s.Start)
+		if err != nil {
+			return result, fmt.Errorf("block %d: %w", sb.ID, err)
+		}
+		if _, ok := index[b.ID]; ok {
+			return result, fmt.Errorf("block %d: duplicate block id", sb.ID)
+		}
+		index[b.ID] = len(userBucket.Blocks)
+		userBucket.Blocks = append(userBucket.Blocks, b)
+	}
+
+	engine := NewEngine("scenario")
+	var config *UserConfig
+	for i, step := range s.Steps {
+		at, err := ParseRetentionPeriod(step.At)
+		if err != nil {
+			return result, fmt.Errorf("step %d: %w", i, err)
+		}
+		if step.Config != nil {
+			c, err := step.Config.ToUserConfig()
+			if err != nil {
+				return result, fmt.Errorf("step %d: %w", i, err)
+			}
+			config = &c
+		}
+		if config == nil {
+			return result, fmt.Errorf("step %d: no config set yet", i)
+		}
+		if _, err := engine.Run(*config, userBucket, s.Start+at); err != nil {
+			return result, fmt.Errorf("step %d: %w", i, err)
+		}
+
+		for _, e := range step.Expect {
+			idx, ok := index[e.ID]
+			if !ok {
+				return result, fmt.Errorf("step %d: unknown block %d", i, e.ID)
+			}
+			in := InspectBucketBlock(userBucket, idx, *config, s.Start+at)
+			mismatch := func(field string, expected interface{}, actual interface{}) {
+				if !reflect.DeepEqual(expected, actual) {
+					result.Mismatches = append(result.Mismatches, Mismatch{Step: i, At: step.At, BlockID: e.ID, Field: field, Expected: expected, Actual: actual})
+				}
+			}
+			if e.Deleted != nil {
+				mismatch("deleted", *e.Deleted, in.Deleted)
+			}
+			if e.Retained != nil {
+				mismatch("retained", *e.Retained, in.Retained)
+			}
+			if e.DropPolicies != nil {
+				mismatch("drop_policies", *e.DropPolicies, in.DropPolicies)
+			}
+			if e.KeepPolicies != nil {
+				mismatch("keep_policies", *e.KeepPolicies, in.KeepPolicyHistory)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (sb ScenarioBlock) toBlock(start int64) (Block, error) {
+	b := Block{ID: sb.ID, Stats: sb.Stats}
+	maxT, err := ParseRetentionPeriod(sb.MaxTime)
+	if err != nil {
+		return b, fmt.Errorf("max_time: %w", err)
+	}
+	b.MaxT = start + maxT
+	if sb.MinTime != "" {
+		minT, err := ParseRetentionPeriod(sb.MinTime)
+		if err != nil {
+			return b, fmt.Errorf("min_time: %w", err)
+		}
+		b.MinT = start + minT
+	}
+	if len(sb.Series) > 0 {
+		b.Series = map[string]interface{}{}
+		for _, s := range sb.Series {
+			b.Series[s] = nil
+		}
+	}
+	return b, nil
+}
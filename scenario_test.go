@@ -0,0 +1,61 @@
+package toyRetention
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScenarioFiles(t *testing.T) {
+	files, err := filepath.Glob("testdata/scenarios/*.json")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, files)
+	for _, f := range files {
+		t.Run(filepath.Base(f), func(t *testing.T) {
+			s, err := LoadScenario(f)
+			assert.NoError(t, err)
+			result, err := RunScenario(s)
+			assert.NoError(t, err)
+			assert.True(t, result.Passed(), result.Diff())
+		})
+	}
+}
+
+func TestRunScenarioReportsMismatches(t *testing.T) {
+	s, err := ParseScenario(strings.NewReader(`{
+		"name": "wrong expectations",
+		"blocks": [{"id": 1, "max_time": "0d"}],
+		"steps": [
+			{
+				"at": "6d",
+				"config": {"base_retention": "10d", "policies": [{"retention_period": "5d", "policy": "service=h1"}]},
+				"expect": [{"id": 1, "retained": 0, "deleted": false, "drop_policies": ["service=h2"]}]
+			}
+		]
+	}`))
+	assert.NoError(t, err)
+
+	result, err := RunScenario(s)
+	assert.NoError(t, err)
+	assert.False(t, result.Passed())
+	assert.Equal(t, `step 0 (at 6d), block 1, retained:
+- expected: 0
++ actual:   1
+step 0 (at 6d), block 1, drop_policies:
+- expected: [service=h2]
++ actual:   [service=h1]
+`, result.Diff())
+}
+
+func TestRunScenarioErrors(t *testing.T) {
+	_, err := RunScenario(Scenario{Blocks: []ScenarioBlock{{ID: 1, MaxTime: "0d"}}, Steps: []ScenarioStep{{At: "1d"}}})
+	assert.EqualError(t, err, "step 0: no config set yet")
+
+	_, err = RunScenario(Scenario{Blocks: []ScenarioBlock{{ID: 1, MaxTime: "0d"}, {ID: 1, MaxTime: "1d"}}})
+	assert.EqualError(t, err, "block 1: duplicate block id")
+
+	_, err = ParseScenario(strings.NewReader(`{"blocks": [], "stpes": []}`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,32 @@
+package toyRetention
+
+import "sort"
+
+// ScheduleActions orders actions so that the most valuable work comes first: the
+// biggest estimated reclaim, then the most overdue. Rewrites beyond rewriteBudget are
+// returned as deferred; deletions are cheap and never count against the budget.
+// A rewriteBudget of 0 means unlimited.
+func ScheduleActions(actions []Action, rewriteBudget int) ([]Action, []Action) {
+	ordered := make([]Action, len(actions))
+	copy(ordered, actions)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if ordered[i].EstimatedBytes != ordered[j].EstimatedBytes {
+			return ordered[i].EstimatedBytes > ordered[j].EstimatedBytes
+		}
+		return ordered[i].Deadline < ordered[j].Deadline
+	})
+
+	scheduled, deferred := []Action{}, []Action{}
+	rewrites := 0
+	for _, a := range ordered {
+		if a.Kind == ActionRewrite {
+			if rewriteBudget > 0 && rewrites >= rewriteBudget {
+				deferred = append(deferred, a)
+				continue
+			}
+			rewrites++
+		}
+		scheduled = append(scheduled, a)
+	}
+	return scheduled, deferred
+}
@@ -0,0 +1,76 @@
+package toyRetention
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleActions(t *testing.T) {
+	actions := []Action{
+		{BlockID: 1, Kind: ActionRewrite, EstimatedBytes: 100, Deadline: theCurrentTime - secondsInADay},
+		{BlockID: 2, Kind: ActionRewrite, EstimatedBytes: 300, Deadline: theCurrentTime - secondsInADay},
+		{BlockID: 3, Kind: ActionRewrite, EstimatedBytes: 100, Deadline: theCurrentTime - 5*secondsInADay},
+		{BlockID: 4, Kind: ActionDelete, EstimatedBytes: 50, Deadline: theCurrentTime},
+	}
+
+	testCases := []struct {
+		name             string
+		budget           int
+		expectedOrder    []int
+		expectedDeferred []int
+	}{
+		{
+			name:             "unlimited budget schedules everything, biggest reclaim first, then most overdue",
+			budget:           0,
+			expectedOrder:    []int{2, 3, 1, 4},
+			expectedDeferred: []int{},
+		},
+		{
+			name:             "limited budget defers the least valuable rewrites, deletions are never deferred",
+			budget:           1,
+			expectedOrder:    []int{2, 4},
+			expectedDeferred: []int{3, 1},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			scheduled, deferred := ScheduleActions(actions, tc.budget)
+			assert.Equal(t, tc.expectedOrder, blockIDs(scheduled))
+			assert.Equal(t, tc.expectedDeferred, blockIDs(deferred))
+		})
+	}
+}
+
+func TestApplyBucketRetentionWithBudget(t *testing.T) {
+	config := UserConfig{
+		BaseRetention: 10 * secondsInADay,
+		Policies:      []PerSeriesRetentionPolicy{{RetentionPeriod: 5 * secondsInADay, Policy: "service=h1"}},
+	}
+	bucket := &Bucket{
+		Blocks: []Block{
+			{ID: 1, MaxT: theCurrentTime - 6*secondsInADay, Series: map[string]interface{}{"service=h1": nil, "service=h2": nil}, Stats: BlockStats{Bytes: 100}},
+			{ID: 2, MaxT: theCurrentTime - 7*secondsInADay, Series: map[string]interface{}{"service=h1": nil}, Stats: BlockStats{Bytes: 100}},
+		},
+	}
+
+	deferred, err := ApplyBucketRetentionWithBudget(config, bucket, theCurrentTime, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1}, blockIDs(deferred))
+	assert.Equal(t, 0, bucket.Blocks[0].Retained)
+	assert.Equal(t, 1, bucket.Blocks[1].Retained)
+
+	deferred, err = ApplyBucketRetentionWithBudget(config, bucket, theCurrentTime, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{}, blockIDs(deferred))
+	assert.Equal(t, 1, bucket.Blocks[0].Retained)
+	assert.Equal(t, 1, bucket.Blocks[1].Retained)
+}
+
+func blockIDs(actions []Action) []int {
+	ids := []int{}
+	for _, a := range actions {
+		ids = append(ids, a.BlockID)
+	}
+	return ids
+}
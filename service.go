@@ -0,0 +1,207 @@
+package toyRetention
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RunRecord is the outcome of a retention run for a tenant.
+type RunRecord struct {
+	At     int64     `json:"at"`
+	Result RunResult `json:"result"`
+	Error  string    `json:"error,omitempty"`
+}
+
+type tenant struct {
+	config  UserConfig
+	bucket  *Bucket
+	lastRun *RunRecord
+}
+
+// Service keeps the config, bucket and last run of every tenant and runs retention
+// for them with its engine.
+type Service struct {
+	Engine *Engine
+	// Now returns the current unix time, overridden in tests.
+	Now func() int64
+
+	mu      sync.Mutex
+	tenants map[string]*tenant
+}
+
+// UnknownTenantError is returned for tenants that were never added to the service.
+type UnknownTenantError string
+
+func (e UnknownTenantError) Error() string {
+	return fmt.Sprintf("unknown tenant %q", string(e))
+}
+
+func NewService(engine *Engine) *Service {
+	return &Service{
+		Engine:  engine,
+		Now:     func() int64 { return time.Now().Unix() },
+		tenants: map[string]*tenant{},
+	}
+}
+
+// SetTenant adds a tenant or replaces its config and bucket.
+func (s *Service) SetTenant(name string, config UserConfig, userBucket *Bucket) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	userBucket.Tenant = name
+	if t, ok := s.tenants[name]; ok {
+		t.config, t.bucket = config, userBucket
+		return
+	}
+	s.tenants[name] = &tenant{config: config, bucket: userBucket}
+}
+
+// Tenants returns the tenant names in order.
+func (s *Service) Tenants() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.tenants))
+	for name := range s.tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *Service) tenant(name string) (tenant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tenants[name]
+	if !ok {
+		return tenant{}, UnknownTenantError(name)
+	}
+	return *t, nil
+}
+
+func (s *Service) Config(name string) (UserConfig, error) {
+	t, err := s.tenant(name)
+	return t.config, err
+}
+
+// PlanReport is the plan of a tenant now, scheduled within the engine's rewrite
+// budget, with the projected cost of its config.
+type PlanReport struct {
+	RetentionPlan
+	Scheduled []Action
+	Deferred  []Action
+	Cost      CostEstimate
+	// RemovedDropPolicies are drop policies applied to blocks but not configured anymore.
+	RemovedDropPolicies []DropPolicyStatus
+}
+
+// PlanReport plans the tenant once and projects the cost of its config over the
+// given months.
+func (s *Service) PlanReport(name string, months int) (PlanReport, error) {
+	t, err := s.tenant(name)
+	if err != nil {
+		return PlanReport{}, err
+	}
+	now := s.Now()
+	report := PlanReport{RetentionPlan: PlanRetention(t.config, t.bucket, now)}
+	report.Scheduled, report.Deferred = ScheduleActions(report.Actions, s.Engine.RewriteBudget)
+	report.Cost = EstimateCost(t.config, t.bucket, now, months)
+	report.RemovedDropPolicies = RemovedDropPolicies(t.config, t.bucket)
+	return report, nil
+}
+
+// Holds returns the legal holds of the tenant, including expired ones.
+func (s *Service) Holds(name string) ([]LegalHold, error) {
+	t, err := s.tenant(name)
+	if err != nil {
+		return nil, err
+	}
+	return t.bucket.ListHolds(), nil
+}
+
+// PlaceHold places a legal hold on the tenant, effective for the next plan or run.
+func (s *Service) PlaceHold(name string, h LegalHold) (LegalHold, error) {
+	t, err := s.tenant(name)
+	if err != nil {
+		return LegalHold{}, err
+	}
+	return t.bucket.PlaceHold(h, s.Now())
+}
+
+func (s *Service) ReleaseHold(name string, id string) error {
+	t, err := s.tenant(name)
+	if err != nil {
+		return err
+	}
+	return t.bucket.ReleaseHold(id)
+}
+
+// RequestDeletion stores a deletion request for the tenant, carried out by the next runs.
+func (s *Service) RequestDeletion(name string, r DeletionRequest) (DeletionRequest, error) {
+	t, err := s.tenant(name)
+	if err != nil {
+		return DeletionRequest{}, err
+	}
+	return t.bucket.RequestDeletion(r, s.Now())
+}
+
+// Deletions returns the deletion requests of the tenant and their status.
+func (s *Service) Deletions(name string) ([]DeletionStatus, error) {
+	t, err := s.tenant(name)
+	if err != nil {
+		return nil, err
+	}
+	return DeletionStatuses(t.bucket, s.Now()), nil
+}
+
+// LastRun returns the last run of the tenant, nil if it never ran.
+func (s *Service) LastRun(name string) (*RunRecord, error) {
+	t, err := s.tenant(name)
+	return t.lastRun, err
+}
+
+// Blocks inspects every block of the tenant.
+func (s *Service) Blocks(name string) ([]BlockInspection, error) {
+	t, err := s.tenant(name)
+	if err != nil {
+		return nil, err
+	}
+	now := s.Now()
+	blocks := []BlockInspection{}
+	for i := range t.bucket.snapshot() {
+		blocks = append(blocks, InspectBucketBlock(t.bucket, i, t.config, now))
+	}
+	return blocks, nil
+}
+
+// Run applies retention to the tenant now and records the outcome.
+func (s *Service) Run(name string) (RunRecord, error) {
+	return s.run(name, s.Engine.Run)
+}
+
+// CleanTombstones carries out the pending deletion requests of the tenant now and
+// records the outcome.
+func (s *Service) CleanTombstones(name string) (RunRecord, error) {
+	return s.run(name, s.Engine.CleanTombstones)
+}
+
+func (s *Service) run(name string, run func(UserConfig, *Bucket, int64) (RunResult, error)) (RunRecord, error) {
+	t, err := s.tenant(name)
+	if err != nil {
+		return RunRecord{}, err
+	}
+	now := s.Now()
+	result, err := run(t.config, t.bucket, now)
+	record := RunRecord{At: now, Result: result}
+	if err != nil {
+		record.Error = err.Error()
+	}
+
+	s.mu.Lock()
+	if t, ok := s.tenants[name]; ok {
+		t.lastRun = &record
+	}
+	s.mu.Unlock()
+	return record, err
+}
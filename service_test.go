@@ -0,0 +1,63 @@
+package toyRetention
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testConfig() UserConfig {
+	return UserConfig{
+		BaseRetention: 10 * secondsInADay,
+		Policies: []PerSeriesRetentionPolicy{
+			{RetentionPeriod: 5 * secondsInADay, Policy: "service=h1"},
+			{RetentionPeriod: 20 * secondsInADay, Policy: "name=ying"},
+		},
+	}
+}
+
+func newTestService() *Service {
+	service := NewService(NewEngine("compactor-1"))
+	service.Now = func() int64 { return theCurrentTime }
+	service.SetTenant("team-b", testConfig(), &Bucket{})
+	service.SetTenant("team-a", testConfig(), &Bucket{
+		Blocks: []Block{
+			{ID: 1, MaxT: theCurrentTime - 2*secondsInADay},
+			{ID: 2, MaxT: theCurrentTime - 6*secondsInADay},
+			{ID: 3, MaxT: theCurrentTime - 30*secondsInADay},
+		},
+	})
+	return service
+}
+
+func TestService(t *testing.T) {
+	service := newTestService()
+	assert.Equal(t, []string{"team-a", "team-b"}, service.Tenants())
+
+	_, err := service.Config("team-c")
+	assert.Equal(t, UnknownTenantError("team-c"), err)
+
+	report, err := service.PlanReport("team-a", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{3, 2}, blockIDs(report.Scheduled))
+	assert.Equal(t, []int{}, blockIDs(report.Deferred))
+
+	lastRun, err := service.LastRun("team-a")
+	assert.NoError(t, err)
+	assert.Nil(t, lastRun)
+
+	record, err := service.Run("team-a")
+	assert.NoError(t, err)
+	assert.Equal(t, theCurrentTime, record.At)
+	assert.ElementsMatch(t, []int{2, 3}, blockIDs(record.Result.Applied))
+
+	lastRun, err = service.LastRun("team-a")
+	assert.NoError(t, err)
+	assert.Equal(t, &record, lastRun)
+
+	blocks, err := service.Blocks("team-a")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(blocks))
+	assert.Equal(t, []string{"service=h1"}, blocks[1].DropPolicies)
+	assert.Equal(t, true, blocks[2].Deleted)
+}
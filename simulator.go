@@ -0,0 +1,116 @@
+package toyRetention
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ConfigChange is a config taking effect at a given time.
+type ConfigChange struct {
+	At     int64      `json:"at"`
+	Config UserConfig `json:"config"`
+}
+
+// Simulation replays retention runs over simulated time.
+type Simulation struct {
+	// Bucket is the initial state, it is copied and left untouched.
+	Bucket *Bucket
+	// Changes are the configs in effect over time, the first one must apply at Start.
+	Changes []ConfigChange
+	// Runs happen every Interval seconds from Start up to and including End.
+	Start    int64
+	End      int64
+	Interval int64
+	// RewriteBudget is passed to the engine, 0 means unlimited.
+	RewriteBudget int
+}
+
+// BlockReport is what happened to a block over a simulation.
+type BlockReport struct {
+	BlockID      int     `json:"block_id"`
+	Rewrites     int     `json:"rewrites"`
+	RewriteTimes []int64 `json:"rewrite_times"`
+	// DeletedAt is the time of the run that deleted the block, 0 if it survived.
+	DeletedAt int64 `json:"deleted_at"`
+}
+
+type SimulationReport struct {
+	Runs   int           `json:"runs"`
+	Blocks []BlockReport `json:"blocks"`
+	// TotalRewrites sums the rewrites of every block.
+	TotalRewrites int `json:"total_rewrites"`
+	// BytesRewritten sums the size of every rewritten block before its rewrite, as
+	// each rewrite reads the whole block.
+	BytesRewritten int64 `json:"bytes_rewritten"`
+	// RewriteAmplification is the average number of times each block was rewritten.
+	RewriteAmplification float64 `json:"rewrite_amplification"`
+	// Final is the bucket at the end of the simulation.
+	Final *Bucket `json:"-"`
+}
+
+// Simulate runs the retention engine every interval with the config in effect
+// at that time, and reports per block how often it was rewritten and when it was deleted.
+func Simulate(s Simulation) (SimulationReport, error) {
+	if s.Interval <= 0 {
+		return SimulationReport{}, errors.New("interval must be positive")
+	}
+	if len(s.Changes) == 0 {
+		return SimulationReport{}, errors.New("at least one config is required")
+	}
+	changes := make([]ConfigChange, len(s.Changes))
+	copy(changes, s.Changes)
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].At < changes[j].At
+	})
+	if changes[0].At > s.Start {
+		return SimulationReport{}, fmt.Errorf("no config in effect at the start of the simulation, first change is at %d", changes[0].At)
+	}
+
+	userBucket := &Bucket{Tenant: s.Bucket.Tenant, Blocks: s.Bucket.snapshot(), Holds: s.Bucket.ListHolds(), Deletions: s.Bucket.ListDeletionRequests()}
+	reports := map[int]*BlockReport{}
+	for _, b := range userBucket.Blocks {
+		if _, ok := reports[b.ID]; ok {
+			return SimulationReport{}, fmt.Errorf("duplicate block id %d", b.ID)
+		}
+		reports[b.ID] = &BlockReport{BlockID: b.ID, RewriteTimes: []int64{}}
+	}
+
+	engine := NewEngine("simulator")
+	engine.RewriteBudget = s.RewriteBudget
+	report := SimulationReport{Blocks: []BlockReport{}, Final: userBucket}
+	next := 0
+	var config UserConfig
+	for t := s.Start; t <= s.End; t += s.Interval {
+		for next < len(changes) && changes[next].At <= t {
+			config = changes[next].Config
+			next++
+		}
+		before := userBucket.snapshot()
+		result, err := engine.Run(config, userBucket, t)
+		if err != nil {
+			return report, fmt.Errorf("run at %d: %w", t, err)
+		}
+		report.Runs++
+		for _, a := range result.Applied {
+			r := reports[a.BlockID]
+			if a.Kind == ActionDelete {
+				r.DeletedAt = t
+				continue
+			}
+			r.Rewrites++
+			r.RewriteTimes = append(r.RewriteTimes, t)
+			report.BytesRewritten += before[a.index].Stats.Bytes
+		}
+	}
+
+	for _, b := range userBucket.Blocks {
+		r := reports[b.ID]
+		report.TotalRewrites += r.Rewrites
+		report.Blocks = append(report.Blocks, *r)
+	}
+	if len(report.Blocks) > 0 {
+		report.RewriteAmplification = float64(report.TotalRewrites) / float64(len(report.Blocks))
+	}
+	return report, nil
+}
@@ -0,0 +1,113 @@
+package toyRetention
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulate(t *testing.T) {
+	start := blockCreationTime
+	initial := &Bucket{
+		Tenant: "team-a",
+		Blocks: []Block{
+			{ID: 1, MaxT: start, Stats: BlockStats{Bytes: 100}},
+			{ID: 2, MaxT: start + 3*secondsInADay, Stats: BlockStats{Bytes: 50}},
+		},
+	}
+	config := func(shortPolicy string) UserConfig {
+		return UserConfig{
+			BaseRetention: 10 * secondsInADay,
+			Policies: []PerSeriesRetentionPolicy{
+				{RetentionPeriod: 5 * secondsInADay, Policy: shortPolicy},
+				{RetentionPeriod: 20 * secondsInADay, Policy: "name=ying"},
+			},
+		}
+	}
+
+	report, err := Simulate(Simulation{
+		Bucket: initial,
+		Changes: []ConfigChange{
+			{At: start + 7*secondsInADay, Config: config("service=h2")},
+			{At: start, Config: config("service=h1")},
+		},
+		Start:    start,
+		End:      start + 30*secondsInADay,
+		Interval: secondsInADay,
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 31, report.Runs)
+	assert.Equal(t, []BlockReport{
+		{
+			BlockID:      1,
+			Rewrites:     3,
+			RewriteTimes: []int64{start + 5*secondsInADay, start + 7*secondsInADay, start + 10*secondsInADay},
+			DeletedAt:    start + 20*secondsInADay,
+		},
+		{
+			BlockID:      2,
+			Rewrites:     2,
+			RewriteTimes: []int64{start + 8*secondsInADay, start + 13*secondsInADay},
+			DeletedAt:    start + 23*secondsInADay,
+		},
+	}, report.Blocks)
+	assert.Equal(t, 5, report.TotalRewrites)
+	assert.Equal(t, int64(400), report.BytesRewritten)
+	assert.Equal(t, 2.5, report.RewriteAmplification)
+
+	// the initial bucket is left alone
+	assert.Equal(t, 0, initial.Blocks[0].Retained)
+	assert.Equal(t, 3, report.Final.Blocks[0].Retained)
+}
+
+func TestSimulateValidation(t *testing.T) {
+	bucket := &Bucket{Blocks: []Block{{ID: 1}, {ID: 1}}}
+	changes := []ConfigChange{{At: 0, Config: UserConfig{BaseRetention: secondsInADay}}}
+
+	_, err := Simulate(Simulation{Bucket: bucket, Changes: changes, Interval: 0})
+	assert.EqualError(t, err, "interval must be positive")
+	_, err = Simulate(Simulation{Bucket: bucket, Changes: changes, Start: -1, Interval: 1})
+	assert.EqualError(t, err, "no config in effect at the start of the simulation, first change is at 0")
+	_, err = Simulate(Simulation{Bucket: bucket, Changes: changes, Interval: 1})
+	assert.EqualError(t, err, "duplicate block id 1")
+}
+
+func TestSimulateHoldsAndDeletions(t *testing.T) {
+	start := blockCreationTime
+	series := func() map[string]interface{} {
+		return map[string]interface{}{"service=h1": nil, "service=h2": nil}
+	}
+	initial := &Bucket{
+		Tenant: "team-a",
+		Blocks: []Block{
+			{ID: 1, MinT: start - secondsInADay, MaxT: start, Series: series(), Stats: BlockStats{Bytes: 200, NumSeries: 2}},
+			{ID: 2, MinT: start - secondsInADay, MaxT: start, Series: series(), Stats: BlockStats{Bytes: 200, NumSeries: 2}},
+		},
+	}
+	_, err := initial.PlaceHold(LegalHold{ID: "case-1", BlockIDs: []int{1}}, start)
+	assert.NoError(t, err)
+	_, err = initial.RequestDeletion(DeletionRequest{Selector: "service=h2", MinT: start - secondsInADay, MaxT: start}, start)
+	assert.NoError(t, err)
+	config := UserConfig{
+		BaseRetention: 60 * secondsInADay,
+		Policies:      []PerSeriesRetentionPolicy{{RetentionPeriod: 5 * secondsInADay, Policy: "service=h1"}},
+	}
+
+	report, err := Simulate(Simulation{
+		Bucket:   initial,
+		Changes:  []ConfigChange{{At: start, Config: config}},
+		Start:    start,
+		End:      start + 10*secondsInADay,
+		Interval: secondsInADay,
+	})
+	assert.NoError(t, err)
+
+	// the held block is left alone, the other one carries out the deletion request and
+	// then the drop policy, each rewrite reading the block as it was
+	assert.Equal(t, []BlockReport{
+		{BlockID: 1, RewriteTimes: []int64{}},
+		{BlockID: 2, Rewrites: 2, RewriteTimes: []int64{start, start + 5*secondsInADay}},
+	}, report.Blocks)
+	assert.Equal(t, int64(300), report.BytesRewritten)
+}
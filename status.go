@@ -0,0 +1,156 @@
+package toyRetention
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// StatusHandler renders a server side HTML page with the retention state of every
+// tenant of the service.
+type StatusHandler struct {
+	service *Service
+}
+
+func NewStatusHandler(service *Service) *StatusHandler {
+	return &StatusHandler{service: service}
+}
+
+type statusPage struct {
+	Now     int64
+	Tenants []tenantStatus
+}
+
+type tenantStatus struct {
+	Name     string
+	Policies []policyStatus
+	LastRun  *RunRecord
+	// PendingDeletion are the ids of the blocks the next run deletes.
+	PendingDeletion []int
+	Blocks          []blockTimeline
+}
+
+type policyStatus struct {
+	Policy    string
+	Retention string
+}
+
+type blockTimeline struct {
+	ID       int
+	MaxT     int64
+	Deleted  bool
+	Expiries []policyExpiry
+}
+
+// policyExpiry is when a policy stops retaining the data of a block.
+type policyExpiry struct {
+	Policy  string
+	At      int64
+	Expired bool
+}
+
+func (h *StatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	page, err := buildStatusPage(h.service)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusTemplate.Execute(w, page); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func buildStatusPage(service *Service) (statusPage, error) {
+	now := service.Now()
+	page := statusPage{Now: now, Tenants: []tenantStatus{}}
+	for _, name := range service.Tenants() {
+		t, err := service.tenant(name)
+		if err != nil {
+			return page, err
+		}
+		page.Tenants = append(page.Tenants, newTenantStatus(name, t, now))
+	}
+	return page, nil
+}
+
+func newTenantStatus(name string, t tenant, now int64) tenantStatus {
+	ts := tenantStatus{Name: name, LastRun: t.lastRun, PendingDeletion: []int{}, Blocks: []blockTimeline{}}
+
+	policies := sortedPolicies(t.config)
+	for _, p := range policies {
+		ts.Policies = append(ts.Policies, policyStatus{Policy: p.Policy, Retention: FormatRetentionPeriod(p.RetentionPeriod)})
+	}
+	for _, a := range PlanBucketRetention(t.config, t.bucket, now) {
+		if a.Kind == ActionDelete {
+			ts.PendingDeletion = append(ts.PendingDeletion, a.BlockID)
+		}
+	}
+	for _, b := range t.bucket.snapshot() {
+		bt := blockTimeline{ID: b.ID, MaxT: b.MaxT, Deleted: b.Deleted}
+		config := t.config.atResolution(b.MetaData.Resolution)
+		for _, p := range policies {
+			retention := policyRetention(config, p.Policy)
+			bt.Expiries = append(bt.Expiries, policyExpiry{Policy: p.Policy, At: b.MaxT + retention, Expired: isBlockRetentionPassed(b.MaxT, now, retention)})
+		}
+		ts.Blocks = append(ts.Blocks, bt)
+	}
+	return ts
+}
+
+// sortedPolicies returns the policies and the base retention, named "default",
+// sorted by retention tier.
+func sortedPolicies(config UserConfig) []PerSeriesRetentionPolicy {
+	policies := append([]PerSeriesRetentionPolicy{{RetentionPeriod: config.BaseRetention, Policy: defaultPolicyName}}, config.Policies...)
+	sort.SliceStable(policies, func(i, j int) bool {
+		return policies[i].RetentionPeriod < policies[j].RetentionPeriod
+	})
+	return policies
+}
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"time": func(unix int64) string { return time.Unix(unix, 0).UTC().Format(time.RFC3339) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Retention status</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 2px 8px; text-align: left; }
+.expired { color: #999; text-decoration: line-through; }
+.error { color: #c00; }
+</style>
+</head>
+<body>
+<h1>Retention status</h1>
+<p>Generated at {{time .Now}}.</p>
+{{range .Tenants}}
+<h2 id="{{.Name}}">{{.Name}}</h2>
+<h3>Policies</h3>
+<table>
+<tr><th>Policy</th><th>Retention</th></tr>
+{{range .Policies}}<tr><td>{{.Policy}}</td><td>{{.Retention}}</td></tr>
+{{end}}</table>
+<h3>Last run</h3>
+{{with .LastRun}}<p>At {{time .At}}: {{if .Error}}<span class="error">failed: {{.Error}}</span>{{else}}{{len .Result.Applied}} applied, {{len .Result.Deferred}} deferred, {{len .Result.Vetoed}} vetoed{{end}}.</p>
+{{else}}<p>Never ran.</p>
+{{end}}<h3>Pending deletion</h3>
+{{if .PendingDeletion}}<p>Blocks {{range $i, $id := .PendingDeletion}}{{if $i}}, {{end}}{{$id}}{{end}}.</p>
+{{else}}<p>None.</p>
+{{end}}<h3>Blocks</h3>
+<table>
+<tr><th>Block</th><th>Max time</th><th>Deleted</th><th>Expiry per policy</th></tr>
+{{range .Blocks}}<tr><td>{{.ID}}</td><td>{{time .MaxT}}</td><td>{{.Deleted}}</td><td>{{range .Expiries}}<div{{if .Expired}} class="expired"{{end}}>{{.Policy}}: {{time .At}}</div>{{end}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,51 @@
+package toyRetention
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusHandler(t *testing.T) {
+	service := newTestService()
+	_, err := service.Run("team-a")
+	assert.NoError(t, err)
+	service.SetTenant("team-c", UserConfig{BaseRetention: secondsInADay, Policies: []PerSeriesRetentionPolicy{{RetentionPeriod: 2 * secondsInADay, Policy: "<script>"}}}, &Bucket{
+		Blocks: []Block{{ID: 7, MaxT: theCurrentTime - 3*secondsInADay}},
+	})
+
+	rec := httptest.NewRecorder()
+	NewStatusHandler(service).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	body := rec.Body.String()
+
+	// tenants in order, policies sorted by tier
+	assert.True(t, strings.Index(body, `<h2 id="team-a">`) < strings.Index(body, `<h2 id="team-b">`))
+	teamA := body[strings.Index(body, `<h2 id="team-a">`):strings.Index(body, `<h2 id="team-b">`)]
+	assert.Contains(t, teamA, "<tr><td>service=h1</td><td>5d</td></tr>\n<tr><td>default</td><td>10d</td></tr>\n<tr><td>name=ying</td><td>20d</td></tr>")
+	assert.Contains(t, teamA, "2 applied, 0 deferred, 0 vetoed")
+	assert.Contains(t, teamA, "<p>None.</p>")
+
+	// block 2 lost its service=h1 series a day ago and keeps the rest for longer
+	expiry := func(days int64) string {
+		return time.Unix(theCurrentTime-6*secondsInADay+days*secondsInADay, 0).UTC().Format(time.RFC3339)
+	}
+	assert.Contains(t, teamA, `<div class="expired">service=h1: `+expiry(5)+`</div><div>default: `+expiry(10)+`</div><div>name=ying: `+expiry(20)+`</div>`)
+
+	teamB := body[strings.Index(body, `<h2 id="team-b">`):strings.Index(body, `<h2 id="team-c">`)]
+	assert.Contains(t, teamB, "Never ran.")
+
+	teamC := body[strings.Index(body, `<h2 id="team-c">`):]
+	assert.Contains(t, teamC, "<p>Blocks 7.</p>")
+	assert.Contains(t, teamC, "&lt;script&gt;")
+	assert.NotContains(t, body, "<script>")
+
+	rec = httptest.NewRecorder()
+	NewStatusHandler(service).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
@@ -0,0 +1,239 @@
+package toyRetention
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tsdbAdminPrefix is where the Prometheus compatible TSDB admin routes live.
+const tsdbAdminPrefix = "/api/v1/admin/tsdb/"
+
+// tenantHeader names the tenant of Prometheus compatible requests, as in Cortex and
+// Mimir.
+const tenantHeader = "X-Scope-OrgID"
+
+// serveTSDBAdmin implements the Prometheus TSDB admin API for the tenant given in
+// tenantHeader:
+//
+//	POST|PUT /api/v1/admin/tsdb/delete_series?match[]=<selector>&start=<time>&end=<time>
+//	POST|PUT /api/v1/admin/tsdb/clean_tombstones
+//
+// Every match[] becomes a deletion request, carried out by the next run. Cleaning
+// tombstones carries out the pending deletion requests right away, whatever the
+// rewrite budget, and fails when a legal hold or a hook withholds one of them.
+func (h *APIHandler) serveTSDBAdmin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", http.MethodPost+", "+http.MethodPut)
+		writePromError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	tenant := r.Header.Get(tenantHeader)
+	if tenant == "" {
+		writePromError(w, http.StatusBadRequest, fmt.Errorf("no tenant, the %s header is required", tenantHeader))
+		return
+	}
+
+	var err error
+	switch strings.TrimPrefix(r.URL.Path, tsdbAdminPrefix) {
+	case "delete_series":
+		err = h.deleteSeries(r, tenant)
+	case "clean_tombstones":
+		_, err = h.service.CleanTombstones(tenant)
+	default:
+		writePromError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	var badData badDataError
+	var unknownTenant UnknownTenantError
+	var lockHeld *LockHeldError
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.As(err, &badData):
+		writePromError(w, http.StatusBadRequest, err)
+	case errors.As(err, &unknownTenant):
+		writePromError(w, http.StatusNotFound, err)
+	case errors.As(err, &lockHeld), errors.Is(err, ErrDeletionsWithheld):
+		writePromError(w, http.StatusConflict, err)
+	default:
+		writePromError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// badDataError is a request Prometheus would reject as bad_data.
+type badDataError struct {
+	err error
+}
+
+func (e badDataError) Error() string {
+	return e.err.Error()
+}
+
+func (h *APIHandler) deleteSeries(r *http.Request, tenant string) error {
+	if err := r.ParseForm(); err != nil {
+		return badDataError{err}
+	}
+	matches := r.Form["match[]"]
+	if len(matches) == 0 {
+		return badDataError{errors.New("no match[] parameter provided")}
+	}
+	start, err := parsePromTime(r.FormValue("start"), math.MinInt64)
+	if err != nil {
+		return badDataError{fmt.Errorf("invalid start: %w", err)}
+	}
+	end, err := parsePromTime(r.FormValue("end"), math.MaxInt64)
+	if err != nil {
+		return badDataError{fmt.Errorf("invalid end: %w", err)}
+	}
+
+	requests := []DeletionRequest{}
+	for _, m := range matches {
+		selector, err := parseSeriesSelector(m)
+		if err != nil {
+			return badDataError{err}
+		}
+		request := DeletionRequest{Selector: selector, MinT: start, MaxT: end}
+		if err := request.validate(); err != nil {
+			return badDataError{err}
+		}
+		requests = append(requests, request)
+	}
+	// a bad selector fails the request before anything is stored
+	for _, request := range requests {
+		if _, err := h.service.RequestDeletion(tenant, request); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parsePromTime parses a time as Prometheus does, in unix seconds or RFC 3339, and
+// defaults to def when empty.
+func parsePromTime(s string, def int64) (int64, error) {
+	if s == "" {
+		return def, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return int64(math.Floor(f)), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse %q to a valid timestamp", s)
+	}
+	return t.Unix(), nil
+}
+
+// parseSeriesSelector converts a Prometheus series selector such as
+// up{job="api",instance="a"} into the name=value pairs of a policy. The metric name
+// is the __name__ label; only equality matchers are supported.
+func parseSeriesSelector(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	labels := map[string]string{}
+	name, rest, hasMatchers := strings.Cut(s, "{")
+	if name = strings.TrimSpace(name); name != "" {
+		labels["__name__"] = name
+	}
+	if hasMatchers {
+		if !strings.HasSuffix(rest, "}") {
+			return "", fmt.Errorf("invalid selector %q: missing closing brace", s)
+		}
+		rest = strings.TrimSuffix(rest, "}")
+		for strings.TrimSpace(rest) != "" {
+			label, op, value, remaining, err := nextMatcher(rest)
+			if err != nil {
+				return "", fmt.Errorf("invalid selector %q: %w", s, err)
+			}
+			if op != "=" {
+				return "", fmt.Errorf("invalid selector %q: only = matchers are supported, got %s", s, op)
+			}
+			labels[label] = value
+			rest = remaining
+		}
+	}
+	if len(labels) == 0 {
+		return "", fmt.Errorf("invalid selector %q: no matchers", s)
+	}
+
+	pairs := []string{}
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ","), nil
+}
+
+// nextMatcher reads a label="value" matcher and the comma after it.
+func nextMatcher(s string) (label string, op string, value string, rest string, err error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexAny(s, "=!")
+	if i <= 0 {
+		return "", "", "", "", fmt.Errorf("expected a label matcher at %q", s)
+	}
+	label = strings.TrimSpace(s[:i])
+	s = s[i:]
+	for _, candidate := range []string{"=~", "!~", "!=", "="} {
+		if strings.HasPrefix(s, candidate) {
+			op = candidate
+			break
+		}
+	}
+	if op == "" {
+		return "", "", "", "", fmt.Errorf("unknown matcher at %q", s)
+	}
+	s = strings.TrimSpace(s[len(op):])
+	if !strings.HasPrefix(s, `"`) {
+		return "", "", "", "", fmt.Errorf("expected a quoted value at %q", s)
+	}
+	value, s, err = unquoteValue(s)
+	if err != nil {
+		return "", "", "", "", err
+	}
+	s = strings.TrimSpace(s)
+	if s != "" {
+		if !strings.HasPrefix(s, ",") {
+			return "", "", "", "", fmt.Errorf("expected a comma at %q", s)
+		}
+		s = s[1:]
+	}
+	return label, op, value, s, nil
+}
+
+// unquoteValue reads the double quoted string s starts with and returns it with what
+// follows it.
+func unquoteValue(s string) (string, string, error) {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			value, err := strconv.Unquote(s[:i+1])
+			return value, s[i+1:], err
+		}
+	}
+	return "", "", fmt.Errorf("unterminated value %q", s)
+}
+
+// promErrorResponse is the error body of the Prometheus HTTP API.
+type promErrorResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+}
+
+func writePromError(w http.ResponseWriter, status int, err error) {
+	errorType := "internal"
+	switch status {
+	case http.StatusBadRequest:
+		errorType = "bad_data"
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusConflict:
+		errorType = "unavailable"
+	}
+	writeAPIResponse(w, status, promErrorResponse{Status: "error", ErrorType: errorType, Error: err.Error()})
+}
@@ -0,0 +1,133 @@
+package toyRetention
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSeriesSelector(t *testing.T) {
+	testCases := []struct {
+		selector string
+		expected string
+		err      bool
+	}{
+		{selector: `up`, expected: "__name__=up"},
+		{selector: `{service="h1"}`, expected: "service=h1"},
+		{selector: `up{job="api", instance="a,b"}`, expected: "__name__=up,instance=a,b,job=api"},
+		{selector: `{name="y\"ing"}`, expected: `name=y"ing`},
+		{selector: `{job=~"api.*"}`, err: true},
+		{selector: `{job!="api"}`, err: true},
+		{selector: `{job="api"`, err: true},
+		{selector: `{job=api}`, err: true},
+		{selector: `{}`, err: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.selector, func(t *testing.T) {
+			selector, err := parseSeriesSelector(tc.selector)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, selector)
+		})
+	}
+}
+
+func TestTSDBAdminAPI(t *testing.T) {
+	service := newTestService()
+	config := testConfig()
+	config.Coalescing = RewriteCoalescing{MaxDelay: 3 * secondsInADay}
+	service.SetTenant("team-c", config, &Bucket{Blocks: []Block{
+		{ID: 1, MinT: theCurrentTime - 3*secondsInADay, MaxT: theCurrentTime - 2*secondsInADay, Series: map[string]interface{}{"service=h2": nil, "service=h3": nil}},
+		{ID: 2, MinT: theCurrentTime - secondsInADay, MaxT: theCurrentTime, Series: map[string]interface{}{"service=h2": nil, "service=h3": nil}},
+		{ID: 3, MinT: theCurrentTime - 7*secondsInADay, MaxT: theCurrentTime - 6*secondsInADay, Series: map[string]interface{}{"service=h1": nil, "service=h4": nil}},
+	}})
+	// a rewrite of block 3 is pending, held back by coalescing
+	_, err := service.Run("team-c")
+	assert.NoError(t, err)
+	report, err := service.PlanReport("team-c", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{3}, blockIDs(actionsOf(report.Pending)))
+	server := httptest.NewServer(NewAPIHandler(service))
+	defer server.Close()
+
+	post := func(path string, tenant string, form url.Values) (int, promErrorResponse) {
+		req, err := http.NewRequest(http.MethodPost, server.URL+path, strings.NewReader(form.Encode()))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tenant != "" {
+			req.Header.Set(tenantHeader, tenant)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		body := promErrorResponse{}
+		if resp.StatusCode != http.StatusNoContent {
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		}
+		return resp.StatusCode, body
+	}
+
+	code, body := post("/api/v1/admin/tsdb/delete_series", "", url.Values{"match[]": {`{service="h2"}`}})
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "bad_data", body.ErrorType)
+	code, _ = post("/api/v1/admin/tsdb/delete_series", "team-c", url.Values{"match[]": {`{service="h2"}`, `{service=~"h.*"}`}})
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = post("/api/v1/admin/tsdb/delete_series", "team-c", url.Values{})
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = post("/api/v1/admin/tsdb/delete_series", "team-c", url.Values{"match[]": {`{service="h2"}`}, "start": {"yesterday"}})
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = post("/api/v1/admin/tsdb/delete_series", "team-x", url.Values{"match[]": {`{service="h2"}`}})
+	assert.Equal(t, http.StatusNotFound, code)
+	statuses, err := service.Deletions("team-c")
+	assert.NoError(t, err)
+	assert.Equal(t, []DeletionStatus{}, statuses)
+
+	code, _ = post("/api/v1/admin/tsdb/delete_series", "team-c", url.Values{"match[]": {`{service="h2"}`}, "start": {time.Unix(theCurrentTime-secondsInADay, 0).UTC().Format(time.RFC3339)}, "end": {strconv.FormatInt(theCurrentTime, 10) + ".5"}})
+	assert.Equal(t, http.StatusNoContent, code)
+	statuses, err = service.Deletions("team-c")
+	assert.NoError(t, err)
+	assert.Equal(t, []DeletionStatus{{
+		DeletionRequest: DeletionRequest{ID: "deletion-1", Selector: "service=h2", MinT: theCurrentTime - secondsInADay, MaxT: theCurrentTime, RequestedAt: theCurrentTime},
+		Status:          DeletionPending,
+		BlocksPending:   1,
+	}}, statuses)
+
+	// a legal hold on the series keeps the request from being carried out
+	_, err = service.PlaceHold("team-c", LegalHold{ID: "case-1", Selector: "service=h2", MinT: theCurrentTime - secondsInADay, MaxT: theCurrentTime})
+	assert.NoError(t, err)
+	code, body = post("/api/v1/admin/tsdb/clean_tombstones", "team-c", nil)
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, "blocks [2]: deletion requests withheld", body.Error)
+	assert.NoError(t, service.ReleaseHold("team-c", "case-1"))
+
+	code, _ = post("/api/v1/admin/tsdb/clean_tombstones", "team-c", nil)
+	assert.Equal(t, http.StatusNoContent, code)
+	statuses, err = service.Deletions("team-c")
+	assert.NoError(t, err)
+	assert.Equal(t, DeletionDone, statuses[0].Status)
+	blocks, err := service.Blocks("team-c")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, blocks[0].DeletionRequests)
+	assert.Equal(t, []string{"deletion-1"}, blocks[1].DeletionRequests)
+	// the pending rewrite is left alone
+	assert.Equal(t, 0, blocks[2].Retained)
+	report, err = service.PlanReport("team-c", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{3}, blockIDs(actionsOf(report.Pending)))
+	assert.Equal(t, theCurrentTime+3*secondsInADay, report.Pending[0].FlushAt)
+
+	resp, err := http.Get(server.URL + "/api/v1/admin/tsdb/clean_tombstones")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}